@@ -0,0 +1,68 @@
+package autoscaler
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/metrics"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Decision string
+
+const (
+	Hold      Decision = "Hold"
+	ScaleUp   Decision = "ScaleUp"
+	ScaleDown Decision = "ScaleDown"
+)
+
+// State is a snapshot of the queue and chrome pool a scaler decides on
+type State struct {
+	QueueLength   int
+	PoolLen       int
+	BusyInstances int
+	Now           time.Time
+}
+
+// IScaler decides whether the pool should grow or shrink by one browser. ScaleDown is a request, the pool is only
+// shrunk once it has been requested for SCALE_DOWN_WINDOW_IN_SECS
+type IScaler interface {
+	Evaluate(state State) Decision
+}
+
+// New returns the scaler selected with SCALER_STRATEGY
+func New(conf config.ProxyQueueConfig, im *metrics.InMemory) (IScaler, error) {
+	throughput := NewThroughputScaler(
+		im,
+		conf.ThroughputScaleUpThreshold,
+		conf.ThroughputScaleDownThreshold,
+		conf.DefaultProxyTimeInSecs,
+	)
+
+	switch conf.ScalerStrategy {
+	case config.ScalerStrategyThroughput:
+		return throughput, nil
+	case config.ScalerStrategyQueueDepth:
+		return NewQueueDepthScaler(conf.ScalerTargetQueueDepth), nil
+	case config.ScalerStrategyUtilization:
+		return NewUtilizationScaler(conf.ScalerTargetUtilization), nil
+	case config.ScalerStrategyScheduled:
+		windows, err := config.ParseScalerSchedule(conf.ScalerSchedule)
+		if err != nil {
+			return nil, err
+		}
+		return NewScheduledScaler(windows, throughput), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown %s %s", config.ScalerStrategy, conf.ScalerStrategy))
+}
+
+// AverageProxyTimeSecs returns the mean proxy session time from the in memory samples, or defaultVal if no session
+// has finished recently
+func AverageProxyTimeSecs(im *metrics.InMemory, defaultVal float64) float64 {
+	// sag may be nil if we just received a load after a period of inactivity
+	sag, _ := im.GetLastSampleAggregate(metrics.ProxyTimeSecs)
+	if sag == nil || sag.Count == 0 {
+		return defaultVal
+	}
+	return sag.Mean()
+}
@@ -0,0 +1,124 @@
+package autoscaler
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+type AutoscalerTestSuite struct {
+	suite.Suite
+}
+
+// run before each test
+func (suite *AutoscalerTestSuite) SetupTest() {
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+}
+
+func (suite *AutoscalerTestSuite) TestNewSelectsStrategy() {
+	conf := config.Get().GetProxyQueueConfig()
+
+	s, err := New(conf, &metrics.Get().InMemory)
+	assert.Nil(suite.T(), err)
+	assert.IsType(suite.T(), &ThroughputScaler{}, s)
+
+	conf.ScalerStrategy = config.ScalerStrategyQueueDepth
+	s, err = New(conf, &metrics.Get().InMemory)
+	assert.Nil(suite.T(), err)
+	assert.IsType(suite.T(), &QueueDepthScaler{}, s)
+
+	conf.ScalerStrategy = config.ScalerStrategyUtilization
+	s, err = New(conf, &metrics.Get().InMemory)
+	assert.Nil(suite.T(), err)
+	assert.IsType(suite.T(), &UtilizationScaler{}, s)
+
+	conf.ScalerStrategy = config.ScalerStrategyScheduled
+	_, err = New(conf, &metrics.Get().InMemory)
+	assert.NotNil(suite.T(), err)
+
+	conf.ScalerSchedule = "09:00-17:00=4"
+	s, err = New(conf, &metrics.Get().InMemory)
+	assert.Nil(suite.T(), err)
+	assert.IsType(suite.T(), &ScheduledScaler{}, s)
+
+	conf.ScalerStrategy = "unknown"
+	_, err = New(conf, &metrics.Get().InMemory)
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *AutoscalerTestSuite) TestThroughputScaler() {
+	im := &metrics.Get().InMemory
+	ts := NewThroughputScaler(im, 0.3, 0.1, 2)
+
+	im.IncCounter(metrics.ProxyQueue, 1)
+	im.IncCounter(metrics.ProxyQueue, 1)
+	assert.Equal(suite.T(), 2/AverageProxyTimeSecs(im, 2), ts.Throughput(1))
+	assert.Equal(suite.T(), ScaleUp, ts.Evaluate(State{QueueLength: 2, PoolLen: 1}))
+	assert.Equal(suite.T(), Hold, ts.Evaluate(State{QueueLength: 0, PoolLen: 1}))
+	assert.Equal(suite.T(), ScaleDown, ts.Evaluate(State{QueueLength: 0, PoolLen: 20}))
+}
+
+func (suite *AutoscalerTestSuite) TestAverageProxyTimeSecsFallsBackToDefault() {
+	im := &metrics.Get().InMemory
+	assert.Equal(suite.T(), float64(7), AverageProxyTimeSecs(im, 7))
+
+	im.AddSample(metrics.ProxyTimeSecs, 4)
+	im.AddSample(metrics.ProxyTimeSecs, 6)
+	assert.Equal(suite.T(), float64(5), AverageProxyTimeSecs(im, 7))
+}
+
+func (suite *AutoscalerTestSuite) TestQueueDepthScaler() {
+	qds := NewQueueDepthScaler(2)
+
+	assert.Equal(suite.T(), ScaleUp, qds.Evaluate(State{QueueLength: 5, PoolLen: 2, BusyInstances: 2}))
+	assert.Equal(suite.T(), Hold, qds.Evaluate(State{QueueLength: 4, PoolLen: 2, BusyInstances: 2}))
+	assert.Equal(suite.T(), Hold, qds.Evaluate(State{QueueLength: 0, PoolLen: 2, BusyInstances: 2}))
+	assert.Equal(suite.T(), ScaleDown, qds.Evaluate(State{QueueLength: 0, PoolLen: 2, BusyInstances: 1}))
+}
+
+func (suite *AutoscalerTestSuite) TestUtilizationScaler() {
+	us := NewUtilizationScaler(0.75)
+
+	assert.Equal(suite.T(), ScaleUp, us.Evaluate(State{QueueLength: 1, PoolLen: 4, BusyInstances: 3}))
+	assert.Equal(suite.T(), Hold, us.Evaluate(State{QueueLength: 0, PoolLen: 4, BusyInstances: 3}))
+	// retiring one of 4 browsers with 2 busy would put utilization at 0.67
+	assert.Equal(suite.T(), ScaleDown, us.Evaluate(State{QueueLength: 0, PoolLen: 4, BusyInstances: 2}))
+	assert.Equal(suite.T(), ScaleUp, us.Evaluate(State{QueueLength: 1, PoolLen: 0}))
+	assert.Equal(suite.T(), Hold, us.Evaluate(State{PoolLen: 1}))
+}
+
+type decisionScaler Decision
+
+func (ds decisionScaler) Evaluate(State) Decision {
+	return Decision(ds)
+}
+
+func (suite *AutoscalerTestSuite) TestScheduledScaler() {
+	windows, err := config.ParseScalerSchedule("09:00-17:00=4,22:00-02:00=2")
+	assert.Nil(suite.T(), err)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ss := NewScheduledScaler(windows, decisionScaler(ScaleDown))
+	assert.Equal(suite.T(), 4, ss.ScheduledInstances(day.Add(9*time.Hour)))
+	assert.Equal(suite.T(), 0, ss.ScheduledInstances(day.Add(17*time.Hour)))
+	assert.Equal(suite.T(), 2, ss.ScheduledInstances(day.Add(23*time.Hour)))
+	assert.Equal(suite.T(), 2, ss.ScheduledInstances(day.Add(time.Hour)))
+
+	noon := day.Add(12 * time.Hour)
+	assert.Equal(suite.T(), ScaleUp, ss.Evaluate(State{PoolLen: 3, Now: noon}))
+	assert.Equal(suite.T(), Hold, ss.Evaluate(State{PoolLen: 4, Now: noon}))
+	assert.Equal(suite.T(), ScaleDown, ss.Evaluate(State{PoolLen: 5, Now: noon}))
+	assert.Equal(suite.T(), ScaleDown, ss.Evaluate(State{PoolLen: 1, Now: day.Add(18 * time.Hour)}))
+
+	ss = NewScheduledScaler(windows, decisionScaler(ScaleUp))
+	assert.Equal(suite.T(), ScaleUp, ss.Evaluate(State{PoolLen: 5, Now: noon}))
+}
+
+func TestAutoscalerSuite(t *testing.T) {
+	suite.Run(t, new(AutoscalerTestSuite))
+}
@@ -0,0 +1,25 @@
+package autoscaler
+
+import "math"
+
+// QueueDepthScaler keeps the number of queued sessions per browser at or below a target
+type QueueDepthScaler struct {
+	targetQueueDepth float64
+}
+
+func NewQueueDepthScaler(targetQueueDepth float64) *QueueDepthScaler {
+	return &QueueDepthScaler{
+		targetQueueDepth: targetQueueDepth,
+	}
+}
+
+func (qds *QueueDepthScaler) Evaluate(state State) Decision {
+	depth := float64(state.QueueLength) / math.Max(float64(state.PoolLen), 1)
+	if state.QueueLength > 0 && depth > qds.targetQueueDepth {
+		return ScaleUp
+	}
+	if state.QueueLength == 0 && state.BusyInstances < state.PoolLen {
+		return ScaleDown
+	}
+	return Hold
+}
@@ -0,0 +1,60 @@
+package autoscaler
+
+import (
+	"chromium-websocket-proxy/config"
+	"time"
+)
+
+// ScheduledScaler keeps a scheduled number of browsers warm during each window, e.g. 20 from 9 to 17, and defers to
+// fallback for everything above that
+type ScheduledScaler struct {
+	windows  []config.ScalerScheduleWindow
+	fallback IScaler
+}
+
+func NewScheduledScaler(windows []config.ScalerScheduleWindow, fallback IScaler) *ScheduledScaler {
+	return &ScheduledScaler{
+		windows:  windows,
+		fallback: fallback,
+	}
+}
+
+func (ss *ScheduledScaler) Evaluate(state State) Decision {
+	scheduled := ss.ScheduledInstances(state.Now)
+	if state.PoolLen < scheduled {
+		return ScaleUp
+	}
+
+	decision := ss.fallback.Evaluate(state)
+	if decision == ScaleDown && state.PoolLen <= scheduled {
+		return Hold
+	}
+	return decision
+}
+
+// ScheduledInstances returns the most instances any window scheduled at now asks for
+func (ss *ScheduledScaler) ScheduledInstances(now time.Time) int {
+	return ScheduledInstances(ss.windows, now)
+}
+
+// ScheduledInstances returns the most instances any of windows scheduled at now asks for
+func ScheduledInstances(windows []config.ScalerScheduleWindow, now time.Time) int {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	timeOfDay := now.Sub(midnight)
+
+	scheduled := 0
+	for _, w := range windows {
+		if w.Instances > scheduled && isInWindow(w, timeOfDay) {
+			scheduled = w.Instances
+		}
+	}
+	return scheduled
+}
+
+func isInWindow(w config.ScalerScheduleWindow, timeOfDay time.Duration) bool {
+	if w.Start <= w.End {
+		return timeOfDay >= w.Start && timeOfDay < w.End
+	}
+	// the window wraps around midnight
+	return timeOfDay >= w.Start || timeOfDay < w.End
+}
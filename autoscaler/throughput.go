@@ -0,0 +1,71 @@
+package autoscaler
+
+import (
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"math"
+)
+
+// ThroughputScaler scales on the rate sessions are queued relative to how fast the pool works through them
+type ThroughputScaler struct {
+	im                     *metrics.InMemory
+	scaleUpThreshold       float64
+	scaleDownThreshold     float64
+	defaultProxyTimeInSecs float64
+}
+
+func NewThroughputScaler(
+	im *metrics.InMemory,
+	scaleUpThreshold float64,
+	scaleDownThreshold float64,
+	defaultProxyTimeInSecs float64,
+) *ThroughputScaler {
+	return &ThroughputScaler{
+		im:                     im,
+		scaleUpThreshold:       scaleUpThreshold,
+		scaleDownThreshold:     scaleDownThreshold,
+		defaultProxyTimeInSecs: defaultProxyTimeInSecs,
+	}
+}
+
+func (ts *ThroughputScaler) Evaluate(state State) Decision {
+	tp := ts.Throughput(state.PoolLen)
+	if state.QueueLength > 0 && tp > ts.scaleUpThreshold {
+		return ScaleUp
+	}
+	if tp < ts.scaleDownThreshold {
+		return ScaleDown
+	}
+	return Hold
+}
+
+// Throughput returns the current throughput, or 0 if no sessions have been queued recently
+func (ts *ThroughputScaler) Throughput(poolLen int) float64 {
+	log := logger.Get()
+
+	// Formula and Calculation of Throughput
+	// can be calculated using the following formula:
+	//
+	// tp = qp/apt
+	// where:
+	// qp = queued proxy session / chrome instances
+	// apt = average proxy session time to complete
+	// tp = throughput
+
+	// Get current mean count of in queue & working
+	cag, err := ts.im.GetLastCounterAggregate(metrics.ProxyQueue)
+	if err != nil {
+		log.Err(err).Msg("GetLastCounterAggregate")
+	}
+	if cag == nil || cag.Count == 0 {
+		return 0
+	}
+
+	// Get current mean time to complete a proxy
+	apt := AverageProxyTimeSecs(ts.im, ts.defaultProxyTimeInSecs)
+
+	// an empty pool has to start a browser for any queued session
+	l := math.Max(float64(poolLen), 1)
+	qp := float64(cag.Count) / l
+	return qp / apt
+}
@@ -0,0 +1,31 @@
+package autoscaler
+
+// UtilizationScaler keeps the share of busy browsers at a target. The pool is only shrunk if it would still be
+// below the target with one browser less, which keeps it from flapping around the target
+type UtilizationScaler struct {
+	targetUtilization float64
+}
+
+func NewUtilizationScaler(targetUtilization float64) *UtilizationScaler {
+	return &UtilizationScaler{
+		targetUtilization: targetUtilization,
+	}
+}
+
+func (us *UtilizationScaler) Evaluate(state State) Decision {
+	if state.PoolLen == 0 {
+		if state.QueueLength > 0 {
+			return ScaleUp
+		}
+		return Hold
+	}
+
+	utilization := float64(state.BusyInstances) / float64(state.PoolLen)
+	if state.QueueLength > 0 && utilization >= us.targetUtilization {
+		return ScaleUp
+	}
+	if state.PoolLen > 1 && float64(state.BusyInstances)/float64(state.PoolLen-1) < us.targetUtilization {
+		return ScaleDown
+	}
+	return Hold
+}
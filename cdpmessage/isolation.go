@@ -0,0 +1,394 @@
+package cdpmessage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
+	"strings"
+	"sync"
+)
+
+// IBrowserContextOwner tracks the browser contexts created by a session so they are disposed when it ends
+type IBrowserContextOwner interface {
+	AddBrowserContext(cdp.BrowserContextID)
+	RemoveBrowserContext(cdp.BrowserContextID)
+}
+
+// BrowserContextIsolation restricts the Target domain traffic of a session to the browser contexts it owns.
+// Pages created by the client without a browserContextId are created in the session's primary browser context,
+// and targets, cdp sessions and browser contexts belonging to anything else are hidden from the client.
+// Commands of any domain naming a browser context the session does not own are denied
+type BrowserContextIsolation struct {
+	primaryBrowserContextID cdp.BrowserContextID
+	owner                   IBrowserContextOwner
+	mutex                   sync.Mutex
+	browserContextIDs       map[cdp.BrowserContextID]bool
+	targetIDs               map[string]bool
+	sessionIDs              map[string]bool
+	pending                 map[pendingKey]pendingCommand
+}
+
+// pendingKey identifies a command awaiting a response. Command ids are only unique per cdp session
+type pendingKey struct {
+	sessionID string
+	id        int64
+}
+
+type pendingCommand struct {
+	method           string
+	browserContextID cdp.BrowserContextID
+}
+
+// browserContextCommands act on the default browser context when sent to the browser target without a
+// browserContextId, so they are sent to the session's primary browser context instead
+var browserContextCommands = map[string]bool{
+	"Browser.cancelDownload":      true,
+	"Browser.grantPermissions":    true,
+	"Browser.resetPermissions":    true,
+	"Browser.setDownloadBehavior": true,
+	"Browser.setPermission":       true,
+	"Storage.clearCookies":        true,
+	"Storage.getCookies":          true,
+	"Storage.setCookies":          true,
+}
+
+type targetInfo struct {
+	TargetID         string               `json:"targetId"`
+	BrowserContextID cdp.BrowserContextID `json:"browserContextId"`
+}
+
+// targetParams are the params of Target domain commands and events that reference targets, sessions, or contexts
+type targetParams struct {
+	TargetID         string               `json:"targetId"`
+	SessionID        string               `json:"sessionId"`
+	BrowserContextID cdp.BrowserContextID `json:"browserContextId"`
+	TargetInfo       *targetInfo          `json:"targetInfo"`
+}
+
+// parseTargetParams decodes params by their exact keys, as chrome reads them
+func parseTargetParams(raw json.RawMessage) (targetParams, error) {
+	var params targetParams
+	if len(raw) == 0 {
+		return params, nil
+	}
+	fields, err := decodeObject(raw)
+	if err != nil {
+		return params, err
+	}
+	err = errors.Join(
+		decodeField(fields, "targetId", &params.TargetID),
+		decodeField(fields, "sessionId", &params.SessionID),
+		decodeField(fields, "browserContextId", &params.BrowserContextID),
+		decodeField(fields, "targetInfo", &params.TargetInfo),
+	)
+	return params, err
+}
+
+func NewBrowserContextIsolation(
+	primaryBrowserContextID cdp.BrowserContextID,
+	owner IBrowserContextOwner,
+) *BrowserContextIsolation {
+	return &BrowserContextIsolation{
+		primaryBrowserContextID: primaryBrowserContextID,
+		owner:                   owner,
+		browserContextIDs:       map[cdp.BrowserContextID]bool{primaryBrowserContextID: true},
+		targetIDs:               make(map[string]bool),
+		sessionIDs:              make(map[string]bool),
+		pending:                 make(map[pendingKey]pendingCommand),
+	}
+}
+
+// ClientInterceptor returns the interceptor for messages sent by the client to chrome. Messages that cannot be parsed
+// are answered with an error, since the targets and browser contexts they name cannot be checked
+func (bci *BrowserContextIsolation) ClientInterceptor() InterceptorFunc {
+	return func(_ context.Context, msg []byte) ([]byte, []byte) {
+		cdpMsg, err := Parse(msg)
+		if err != nil {
+			return bci.invalidMessageReply(msg, err)
+		}
+		if !cdpMsg.IsCommand() {
+			return msg, nil
+		}
+		return bci.interceptCommand(msg, cdpMsg)
+	}
+}
+
+// ChromeInterceptor returns the interceptor for messages sent by chrome to the client. Large responses, e.g.
+// screenshots, are only inspected if they answer a Target command
+func (bci *BrowserContextIsolation) ChromeInterceptor() *SelectiveInterceptor {
+	return &SelectiveInterceptor{
+		intercept: func(_ context.Context, msg []byte) ([]byte, []byte) {
+			cdpMsg, err := Parse(msg)
+			if err != nil {
+				return msg, nil
+			}
+			return bci.interceptFromChrome(msg, cdpMsg), nil
+		},
+		inspects: bci.inspectsFromChrome,
+	}
+}
+
+// inspectsFromChrome returns false for responses to commands other than the Target commands awaiting a response.
+// Those commands were checked when the client sent them, so their responses belong to the session. Events are always
+// inspected, since their cdp session is only known once they have been read whole
+func (bci *BrowserContextIsolation) inspectsFromChrome(header []byte) bool {
+	id, isResponse := peekResponseId(header)
+	if !isResponse {
+		return true
+	}
+
+	bci.mutex.Lock()
+	defer bci.mutex.Unlock()
+	for key := range bci.pending {
+		if key.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (bci *BrowserContextIsolation) interceptCommand(msg []byte, cdpMsg *Message) ([]byte, []byte) {
+	bci.mutex.Lock()
+	defer bci.mutex.Unlock()
+
+	if len(cdpMsg.SessionId) > 0 && !bci.sessionIDs[cdpMsg.SessionId] {
+		return bci.errorReply(cdpMsg, "No session with given id")
+	}
+
+	if !strings.HasPrefix(cdpMsg.Method, "Target.") {
+		return bci.interceptBrowserContextCommand(msg, cdpMsg)
+	}
+
+	// attaching to the browser target would bypass isolation entirely
+	if cdpMsg.Method == "Target.attachToBrowserTarget" {
+		return bci.errorReply(cdpMsg, fmt.Sprintf("'%s' is not allowed for isolated sessions", cdpMsg.Method))
+	}
+
+	params, err := parseTargetParams(cdpMsg.Params)
+	if err != nil {
+		return bci.invalidMessageReply(msg, err)
+	}
+
+	if len(params.TargetID) > 0 && !bci.targetIDs[params.TargetID] {
+		return bci.errorReply(cdpMsg, "No target with given id found")
+	}
+	if len(params.SessionID) > 0 && !bci.sessionIDs[params.SessionID] {
+		return bci.errorReply(cdpMsg, "No session with given id")
+	}
+	if len(params.BrowserContextID) > 0 && !bci.browserContextIDs[params.BrowserContextID] {
+		return bci.errorReply(cdpMsg, "Failed to find browser context with id "+string(params.BrowserContextID))
+	}
+
+	switch cdpMsg.Method {
+	case "Target.createTarget":
+		if len(params.BrowserContextID) == 0 {
+			rewritten, err := setField(msg, "params", "browserContextId", bci.primaryBrowserContextID)
+			if err != nil {
+				return bci.invalidMessageReply(msg, err)
+			}
+			msg = rewritten
+		}
+	case "Target.disposeBrowserContext":
+		if params.BrowserContextID == bci.primaryBrowserContextID {
+			return bci.errorReply(cdpMsg, "Failed to find browser context with id "+string(params.BrowserContextID))
+		}
+	}
+
+	switch cdpMsg.Method {
+	case "Target.createTarget",
+		"Target.attachToTarget",
+		"Target.getTargets",
+		"Target.createBrowserContext",
+		"Target.disposeBrowserContext",
+		"Target.getBrowserContexts":
+		bci.pending[pendingKey{sessionID: cdpMsg.SessionId, id: *cdpMsg.Id}] = pendingCommand{
+			method:           cdpMsg.Method,
+			browserContextID: params.BrowserContextID,
+		}
+	}
+	return msg, nil
+}
+
+// interceptBrowserContextCommand checks the browserContextId of commands outside the Target domain,
+// e.g. Storage.getCookies or Browser.grantPermissions
+func (bci *BrowserContextIsolation) interceptBrowserContextCommand(msg []byte, cdpMsg *Message) ([]byte, []byte) {
+	params, err := parseTargetParams(cdpMsg.Params)
+	if err != nil {
+		return bci.invalidMessageReply(msg, err)
+	}
+
+	if len(params.BrowserContextID) > 0 {
+		if !bci.browserContextIDs[params.BrowserContextID] {
+			return bci.errorReply(cdpMsg, "Failed to find browser context with id "+string(params.BrowserContextID))
+		}
+		return msg, nil
+	}
+
+	if len(cdpMsg.SessionId) == 0 && browserContextCommands[cdpMsg.Method] {
+		rewritten, err := setField(msg, "params", "browserContextId", bci.primaryBrowserContextID)
+		if err != nil {
+			return bci.invalidMessageReply(msg, err)
+		}
+		return rewritten, nil
+	}
+	return msg, nil
+}
+
+// interceptFromChrome returns the message to forward to the client, or nil if it should be hidden
+func (bci *BrowserContextIsolation) interceptFromChrome(msg []byte, cdpMsg *Message) []byte {
+	bci.mutex.Lock()
+	defer bci.mutex.Unlock()
+
+	// messages for cdp sessions attached to targets outside the session's browser contexts
+	if len(cdpMsg.SessionId) > 0 && !bci.sessionIDs[cdpMsg.SessionId] {
+		return nil
+	}
+
+	if cdpMsg.IsResponse() {
+		return bci.interceptResponse(msg, cdpMsg)
+	}
+
+	if !cdpMsg.IsEvent() || !strings.HasPrefix(cdpMsg.Method, "Target.") {
+		return msg
+	}
+
+	params, err := parseTargetParams(cdpMsg.Params)
+	if err != nil {
+		return msg
+	}
+
+	switch cdpMsg.Method {
+	case "Target.targetCreated", "Target.targetInfoChanged":
+		if params.TargetInfo == nil || !bci.browserContextIDs[params.TargetInfo.BrowserContextID] {
+			return nil
+		}
+		bci.targetIDs[params.TargetInfo.TargetID] = true
+	case "Target.attachedToTarget":
+		if params.TargetInfo == nil || !bci.browserContextIDs[params.TargetInfo.BrowserContextID] {
+			return nil
+		}
+		bci.targetIDs[params.TargetInfo.TargetID] = true
+		bci.sessionIDs[params.SessionID] = true
+	case "Target.targetDestroyed", "Target.targetCrashed":
+		if !bci.targetIDs[params.TargetID] {
+			return nil
+		}
+		if cdpMsg.Method == "Target.targetDestroyed" {
+			delete(bci.targetIDs, params.TargetID)
+		}
+	case "Target.detachedFromTarget", "Target.receivedMessageFromTarget":
+		if !bci.sessionIDs[params.SessionID] {
+			return nil
+		}
+		if cdpMsg.Method == "Target.detachedFromTarget" {
+			delete(bci.sessionIDs, params.SessionID)
+		}
+	}
+	return msg
+}
+
+func (bci *BrowserContextIsolation) interceptResponse(msg []byte, cdpMsg *Message) []byte {
+	key := pendingKey{sessionID: cdpMsg.SessionId, id: *cdpMsg.Id}
+	command, exists := bci.pending[key]
+	if !exists {
+		return msg
+	}
+	delete(bci.pending, key)
+
+	if cdpMsg.Error != nil {
+		return msg
+	}
+
+	var result struct {
+		TargetID          string                 `json:"targetId"`
+		SessionID         string                 `json:"sessionId"`
+		BrowserContextID  cdp.BrowserContextID   `json:"browserContextId"`
+		BrowserContextIDs []cdp.BrowserContextID `json:"browserContextIds"`
+		TargetInfos       []json.RawMessage      `json:"targetInfos"`
+	}
+	if err := json.Unmarshal(cdpMsg.Result, &result); err != nil {
+		return msg
+	}
+
+	switch command.method {
+	case "Target.createTarget":
+		bci.targetIDs[result.TargetID] = true
+	case "Target.attachToTarget":
+		bci.sessionIDs[result.SessionID] = true
+	case "Target.createBrowserContext":
+		bci.browserContextIDs[result.BrowserContextID] = true
+		bci.owner.AddBrowserContext(result.BrowserContextID)
+	case "Target.disposeBrowserContext":
+		delete(bci.browserContextIDs, command.browserContextID)
+		bci.owner.RemoveBrowserContext(command.browserContextID)
+	case "Target.getTargets":
+		targetInfos := make([]json.RawMessage, 0, len(result.TargetInfos))
+		for _, raw := range result.TargetInfos {
+			var ti targetInfo
+			if err := json.Unmarshal(raw, &ti); err != nil || !bci.browserContextIDs[ti.BrowserContextID] {
+				continue
+			}
+			bci.targetIDs[ti.TargetID] = true
+			targetInfos = append(targetInfos, raw)
+		}
+		if rewritten, err := setField(msg, "result", "targetInfos", targetInfos); err == nil {
+			msg = rewritten
+		}
+	case "Target.getBrowserContexts":
+		// the primary context is hidden, so only contexts created by the client are listed
+		browserContextIDs := make([]cdp.BrowserContextID, 0)
+		for _, id := range result.BrowserContextIDs {
+			if id != bci.primaryBrowserContextID && bci.browserContextIDs[id] {
+				browserContextIDs = append(browserContextIDs, id)
+			}
+		}
+		if rewritten, err := setField(msg, "result", "browserContextIds", browserContextIDs); err == nil {
+			msg = rewritten
+		}
+	}
+	return msg
+}
+
+func (bci *BrowserContextIsolation) invalidMessageReply(msg []byte, parseErr error) ([]byte, []byte) {
+	reply, err := NewInvalidMessageResponse(msg, parseErr)
+	if err != nil {
+		return nil, nil
+	}
+	return nil, reply
+}
+
+func (bci *BrowserContextIsolation) errorReply(cdpMsg *Message, message string) ([]byte, []byte) {
+	reply, err := NewErrorResponse(cdpMsg, ServerError, message)
+	if err != nil {
+		return nil, nil
+	}
+	return nil, reply
+}
+
+// setField sets msg[object][field] to val, leaving every other field of msg untouched
+func setField(msg []byte, object string, field string, val interface{}) ([]byte, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+
+	o := make(map[string]json.RawMessage)
+	if raw, exists := m[object]; exists {
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, err
+		}
+	}
+
+	rawVal, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	o[field] = rawVal
+
+	if m[object], err = json.Marshal(o); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
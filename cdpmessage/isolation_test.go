@@ -0,0 +1,206 @@
+package cdpmessage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+const primaryBrowserContextID = cdp.BrowserContextID("primary")
+
+type mockBrowserContextOwner struct {
+	browserContextIDs []cdp.BrowserContextID
+}
+
+func (o *mockBrowserContextOwner) AddBrowserContext(id cdp.BrowserContextID) {
+	o.browserContextIDs = append(o.browserContextIDs, id)
+}
+
+func (o *mockBrowserContextOwner) RemoveBrowserContext(id cdp.BrowserContextID) {
+	for i := range o.browserContextIDs {
+		if o.browserContextIDs[i] == id {
+			o.browserContextIDs = append(o.browserContextIDs[:i], o.browserContextIDs[i+1:]...)
+			return
+		}
+	}
+}
+
+type IsolationTestSuite struct {
+	suite.Suite
+	owner    *mockBrowserContextOwner
+	client   InterceptorFunc
+	chrome   InterceptorFunc
+	inspects func(header []byte) bool
+}
+
+// run before each test
+func (suite *IsolationTestSuite) SetupTest() {
+	suite.owner = &mockBrowserContextOwner{}
+	bci := NewBrowserContextIsolation(primaryBrowserContextID, suite.owner)
+	suite.client = bci.ClientInterceptor()
+	suite.chrome = bci.ChromeInterceptor().Intercept
+	suite.inspects = bci.ChromeInterceptor().Inspects
+}
+
+func (suite *IsolationTestSuite) fromClient(msg string) (map[string]interface{}, map[string]interface{}) {
+	forward, reply := suite.client(context.Background(), []byte(msg))
+	return suite.decode(forward), suite.decode(reply)
+}
+
+func (suite *IsolationTestSuite) fromChrome(msg string) map[string]interface{} {
+	forward, reply := suite.chrome(context.Background(), []byte(msg))
+	assert.Nil(suite.T(), reply)
+	return suite.decode(forward)
+}
+
+func (suite *IsolationTestSuite) decode(b []byte) map[string]interface{} {
+	if b == nil {
+		return nil
+	}
+	var m map[string]interface{}
+	assert.Nil(suite.T(), json.Unmarshal(b, &m))
+	return m
+}
+
+func (suite *IsolationTestSuite) TestCreateTargetUsesPrimaryBrowserContext() {
+	forward, reply := suite.fromClient(`{"id":1,"method":"Target.createTarget","params":{"url":"about:blank"}}`)
+
+	assert.Nil(suite.T(), reply)
+	params := forward["params"].(map[string]interface{})
+	assert.Equal(suite.T(), string(primaryBrowserContextID), params["browserContextId"])
+	assert.Equal(suite.T(), "about:blank", params["url"])
+
+	res := suite.fromChrome(`{"id":1,"result":{"targetId":"owned"}}`)
+	assert.NotNil(suite.T(), res)
+
+	// the created target can now be used
+	forward, reply = suite.fromClient(`{"id":2,"method":"Target.attachToTarget","params":{"targetId":"owned","flatten":true}}`)
+	assert.NotNil(suite.T(), forward)
+	assert.Nil(suite.T(), reply)
+}
+
+func (suite *IsolationTestSuite) TestForeignTargetsAreHidden() {
+	assert.Nil(suite.T(), suite.fromChrome(`{"method":"Target.targetCreated","params":{"targetInfo":{"targetId":"foreign","browserContextId":"other"}}}`))
+	assert.NotNil(suite.T(), suite.fromChrome(`{"method":"Target.targetCreated","params":{"targetInfo":{"targetId":"owned","browserContextId":"primary"}}}`))
+
+	assert.Nil(suite.T(), suite.fromChrome(`{"method":"Target.attachedToTarget","params":{"sessionId":"s1","targetInfo":{"targetId":"foreign","browserContextId":"other"}}}`))
+	assert.Nil(suite.T(), suite.fromChrome(`{"method":"Page.loadEventFired","sessionId":"s1","params":{}}`))
+
+	forward, reply := suite.fromClient(`{"id":3,"method":"Target.closeTarget","params":{"targetId":"foreign"}}`)
+	assert.Nil(suite.T(), forward)
+	assert.NotNil(suite.T(), reply["error"])
+
+	forward, reply = suite.fromClient(`{"id":4,"method":"Runtime.evaluate","sessionId":"s1","params":{}}`)
+	assert.Nil(suite.T(), forward)
+	assert.NotNil(suite.T(), reply["error"])
+}
+
+func (suite *IsolationTestSuite) TestGetTargetsIsFiltered() {
+	forward, _ := suite.fromClient(`{"id":5,"method":"Target.getTargets"}`)
+	assert.NotNil(suite.T(), forward)
+
+	res := suite.fromChrome(`{"id":5,"result":{"targetInfos":[{"targetId":"foreign","browserContextId":"other"},{"targetId":"owned","browserContextId":"primary"}]}}`)
+	targetInfos := res["result"].(map[string]interface{})["targetInfos"].([]interface{})
+	assert.Len(suite.T(), targetInfos, 1)
+	assert.Equal(suite.T(), "owned", targetInfos[0].(map[string]interface{})["targetId"])
+}
+
+func (suite *IsolationTestSuite) TestClientCreatedBrowserContextsAreOwned() {
+	suite.fromClient(`{"id":6,"method":"Target.createBrowserContext"}`)
+	suite.fromChrome(`{"id":6,"result":{"browserContextId":"client"}}`)
+	assert.Equal(suite.T(), []cdp.BrowserContextID{"client"}, suite.owner.browserContextIDs)
+
+	suite.fromClient(`{"id":7,"method":"Target.getBrowserContexts"}`)
+	res := suite.fromChrome(`{"id":7,"result":{"browserContextIds":["primary","client","other"]}}`)
+	assert.Equal(suite.T(), []interface{}{"client"}, res["result"].(map[string]interface{})["browserContextIds"])
+
+	forward, reply := suite.fromClient(`{"id":8,"method":"Target.disposeBrowserContext","params":{"browserContextId":"primary"}}`)
+	assert.Nil(suite.T(), forward)
+	assert.NotNil(suite.T(), reply["error"])
+
+	forward, _ = suite.fromClient(`{"id":9,"method":"Target.disposeBrowserContext","params":{"browserContextId":"client"}}`)
+	assert.NotNil(suite.T(), forward)
+	suite.fromChrome(`{"id":9,"result":{}}`)
+	assert.Empty(suite.T(), suite.owner.browserContextIDs)
+}
+
+func (suite *IsolationTestSuite) TestAttachToBrowserTargetIsDenied() {
+	forward, reply := suite.fromClient(`{"id":10,"method":"Target.attachToBrowserTarget"}`)
+	assert.Nil(suite.T(), forward)
+	assert.NotNil(suite.T(), reply["error"])
+}
+
+func (suite *IsolationTestSuite) TestForeignBrowserContextsAreDeniedInEveryDomain() {
+	for i, method := range []string{"Storage.getCookies", "Storage.setCookies", "Storage.clearCookies", "Browser.grantPermissions", "Browser.setPermission"} {
+		forward, reply := suite.fromClient(fmt.Sprintf(`{"id":%d,"method":"%s","params":{"browserContextId":"other"}}`, 20+i, method))
+		assert.Nil(suite.T(), forward, method)
+		assert.NotNil(suite.T(), reply["error"], method)
+	}
+
+	forward, reply := suite.fromClient(`{"id":30,"method":"Storage.getCookies","params":{"browserContextId":"primary"}}`)
+	assert.NotNil(suite.T(), forward)
+	assert.Nil(suite.T(), reply)
+}
+
+func (suite *IsolationTestSuite) TestBrowserContextCommandsUsePrimaryBrowserContext() {
+	forward, reply := suite.fromClient(`{"id":31,"method":"Storage.clearCookies"}`)
+	assert.Nil(suite.T(), reply)
+	assert.Equal(suite.T(), string(primaryBrowserContextID), forward["params"].(map[string]interface{})["browserContextId"])
+
+	forward, _ = suite.fromClient(`{"id":32,"method":"Browser.grantPermissions","params":{"permissions":["geolocation"]}}`)
+	params := forward["params"].(map[string]interface{})
+	assert.Equal(suite.T(), string(primaryBrowserContextID), params["browserContextId"])
+	assert.Equal(suite.T(), []interface{}{"geolocation"}, params["permissions"])
+
+	// other commands are forwarded unchanged
+	forward, _ = suite.fromClient(`{"id":33,"method":"Browser.getVersion"}`)
+	assert.Nil(suite.T(), forward["params"])
+}
+
+func (suite *IsolationTestSuite) TestCaseVariantAndDuplicateKeysAreDenied() {
+	for i, msg := range []string{
+		`{"id":%d,"method":"Storage.getCookies","params":{"browserContextId":"other","BrowserContextId":"primary"}}`,
+		`{"id":%d,"method":"Storage.getCookies","params":{"browserContextId":"other","browserContextId":"primary"}}`,
+		`{"id":%d,"method":"Target.attachToTarget","params":{"targetId":"foreign","TargetId":"owned"}}`,
+		`{"id":%d,"method":"Target.attachToTarget","params":{"targetId":"foreign","targetId":"owned"}}`,
+		`{"id":%d,"method":"Storage.getCookies","Method":"Browser.getVersion"}`,
+		`{"id":%d,"method":"Storage.getCookies","params":"not an object"}`,
+	} {
+		msg = fmt.Sprintf(msg, 40+i)
+		forward, reply := suite.fromClient(msg)
+		assert.Nil(suite.T(), forward, msg)
+		assert.Equal(suite.T(), float64(40+i), reply["id"], msg)
+		assert.Equal(suite.T(), float64(InvalidRequest), reply["error"].(map[string]interface{})["code"], msg)
+	}
+
+	forward, reply := suite.fromClient(`not json`)
+	assert.Nil(suite.T(), forward)
+	assert.NotNil(suite.T(), reply["error"])
+}
+
+func (suite *IsolationTestSuite) TestKeysAreMatchedExactly() {
+	// chrome ignores keys that differ by case, so the page is created in the primary browser context
+	forward, reply := suite.fromClient(`{"id":50,"method":"Target.createTarget","params":{"url":"about:blank","BrowserContextId":"primary"}}`)
+	assert.Nil(suite.T(), reply)
+	assert.Equal(suite.T(), string(primaryBrowserContextID), forward["params"].(map[string]interface{})["browserContextId"])
+}
+
+func (suite *IsolationTestSuite) TestOnlyLargeTargetResponsesAndEventsAreInspected() {
+	suite.fromClient(`{"id":60,"method":"Target.getTargets"}`)
+
+	assert.True(suite.T(), suite.inspects([]byte(`{"id":60,"result":{"targetInfos":[{"targetId":"`)))
+	assert.True(suite.T(), suite.inspects([]byte(`{"method":"Target.targetCreated","params":{"targetInfo":{"`)))
+	assert.True(suite.T(), suite.inspects([]byte(`{"method":"Page.screencastFrame","params":{"data":"`)))
+	assert.True(suite.T(), suite.inspects([]byte(`not json`)))
+
+	// e.g. a screenshot, sent by a command the client was allowed to send
+	assert.False(suite.T(), suite.inspects([]byte(`{"id":61,"result":{"data":"iVBORw0KGgo`)))
+}
+
+func TestIsolationSuite(t *testing.T) {
+	suite.Run(t, new(IsolationTestSuite))
+}
@@ -0,0 +1,182 @@
+package cdpmessage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ServerError is the JSON-RPC error code chrome uses for failed commands
+const ServerError int64 = -32000
+
+// InvalidRequest is the JSON-RPC error code for messages that are not valid commands
+const InvalidRequest int64 = -32600
+
+// Message is a CDP JSON-RPC message. Commands have an Id and Method, responses have an Id and
+// a Result or Error, and events only have a Method
+type Message struct {
+	Id        *int64          `json:"id,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	SessionId string          `json:"sessionId,omitempty"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *Error          `json:"error,omitempty"`
+}
+
+// InterceptorFunc adapts a function to websocketproxy.IMessageInterceptor
+type InterceptorFunc func(ctx context.Context, msg []byte) (forward []byte, reply []byte)
+
+func (f InterceptorFunc) Intercept(ctx context.Context, msg []byte) ([]byte, []byte) {
+	return f(ctx, msg)
+}
+
+// SelectiveInterceptor is an interceptor that only needs to inspect the large messages inspects returns true for.
+// It implements websocketproxy.IMessageInspector
+type SelectiveInterceptor struct {
+	intercept InterceptorFunc
+	inspects  func(header []byte) bool
+}
+
+func (si *SelectiveInterceptor) Intercept(ctx context.Context, msg []byte) ([]byte, []byte) {
+	return si.intercept(ctx, msg)
+}
+
+func (si *SelectiveInterceptor) Inspects(header []byte) bool {
+	return si.inspects(header)
+}
+
+// peekResponseId returns the id of the message starting with header if id is its first key, as chrome writes
+// responses. ok is false for events, and for anything chrome does not write
+func peekResponseId(header []byte) (id int64, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(header))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return 0, false
+	}
+	if t, err := dec.Token(); err != nil || t != "id" {
+		return 0, false
+	}
+	t, err := dec.Token()
+	if err != nil {
+		return 0, false
+	}
+	number, isNumber := t.(json.Number)
+	if !isNumber {
+		return 0, false
+	}
+	id, err = number.Int64()
+	return id, err == nil
+}
+
+type Error struct {
+	Code    int64  `json:"code"`
+	Message string `json:"message"`
+}
+
+// Parse decodes a CDP message. Only exact keys are read, as chrome does, and messages with duplicate keys or keys
+// that only differ by case are rejected, since encoding/json would read another field than chrome does
+func Parse(b []byte) (*Message, error) {
+	fields, err := decodeObject(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	err = errors.Join(
+		decodeField(fields, "id", &msg.Id),
+		decodeField(fields, "method", &msg.Method),
+		decodeField(fields, "sessionId", &msg.SessionId),
+		decodeField(fields, "params", &msg.Params),
+		decodeField(fields, "result", &msg.Result),
+		decodeField(fields, "error", &msg.Error),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// decodeObject decodes the JSON object b into its fields by exact key. Objects with duplicate keys or keys that
+// only differ by case are rejected
+func decodeObject(b []byte) (map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("cdp message must be a json object")
+	}
+
+	fields := make(map[string]json.RawMessage)
+	keys := make(map[string]bool)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := t.(string)
+		if keys[strings.ToLower(key)] {
+			return nil, errors.New(fmt.Sprintf("cdp message has duplicate key '%s'", key))
+		}
+		keys[strings.ToLower(key)] = true
+
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			return nil, err
+		}
+		fields[key] = val
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, errors.New("cdp message has data after the json object")
+	}
+	return fields, nil
+}
+
+// decodeField decodes fields[key] into v, leaving v untouched if fields has no key
+func decodeField(fields map[string]json.RawMessage, key string, v interface{}) error {
+	raw, exists := fields[key]
+	if !exists {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("cdp message field '%s' is invalid: %w", key, err)
+	}
+	return nil
+}
+
+func (msg *Message) IsCommand() bool {
+	return msg.Id != nil && len(msg.Method) > 0
+}
+
+func (msg *Message) IsResponse() bool {
+	return msg.Id != nil && len(msg.Method) == 0
+}
+
+func (msg *Message) IsEvent() bool {
+	return msg.Id == nil && len(msg.Method) > 0
+}
+
+// NewErrorResponse creates the response chrome would send for a failed command
+func NewErrorResponse(command *Message, code int64, message string) ([]byte, error) {
+	return json.Marshal(Message{
+		Id:        command.Id,
+		SessionId: command.SessionId,
+		Error: &Error{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// NewInvalidMessageResponse creates the response to a client message that could not be parsed. The id and session of
+// the message are kept if they can be read
+func NewInvalidMessageResponse(msg []byte, parseErr error) ([]byte, error) {
+	var command Message
+	_ = json.Unmarshal(msg, &command)
+	return NewErrorResponse(&command, InvalidRequest, fmt.Sprintf("invalid cdp message: %s", parseErr.Error()))
+}
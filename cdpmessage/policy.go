@@ -0,0 +1,121 @@
+package cdpmessage
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"context"
+	"fmt"
+	"path"
+)
+
+// Policy denies CDP commands sent by the client whose method matches one of its patterns.
+// Patterns use path.Match syntax, e.g. "Browser.close" or "Target.*BrowserContext"
+type Policy struct {
+	denyPatterns []string
+}
+
+// namedPolicyDenyPatterns are the built-in policies that can be selected with config or per connection
+var namedPolicyDenyPatterns = map[string][]string{
+	config.CdpPolicyPermissive: {},
+	config.CdpPolicySharedBrowser: {
+		"Browser.close",
+		"Browser.crash",
+		"Browser.crashGpuProcess",
+		"Browser.resetPermissions",
+		"Target.createBrowserContext",
+		"Target.disposeBrowserContext",
+		"Network.setCookie",
+		"Network.setCookies",
+		"Network.deleteCookies",
+		"Network.clearBrowserCookies",
+		"Storage.setCookies",
+		"Storage.clearCookies",
+		"Storage.clearDataForOrigin",
+	},
+}
+
+func NewPolicy(denyPatterns []string) *Policy {
+	return &Policy{
+		denyPatterns: denyPatterns,
+	}
+}
+
+// GetNamedPolicy returns the built-in policy with name
+func GetNamedPolicy(name string) (*Policy, bool) {
+	denyPatterns, exists := namedPolicyDenyPatterns[name]
+	if !exists {
+		return nil, false
+	}
+	return NewPolicy(denyPatterns), true
+}
+
+// GetPolicyForConnection returns the configured policy combined with the built-in policy named by the connection.
+// A connection can only add restrictions to the configured policy, never remove them
+func GetPolicyForConnection(connectionPolicyName string) (*Policy, error) {
+	conf := config.Get().GetCdpPolicyConfig()
+
+	p, exists := GetNamedPolicy(conf.Policy)
+	if !exists {
+		return nil, fmt.Errorf("%s %s does not exist", config.CdpPolicy, conf.Policy)
+	}
+	p = p.Merge(NewPolicy(conf.DenyMethods))
+
+	if len(connectionPolicyName) > 0 {
+		cp, exists := GetNamedPolicy(connectionPolicyName)
+		if !exists {
+			return nil, fmt.Errorf("policy %s does not exist", connectionPolicyName)
+		}
+		p = p.Merge(cp)
+	}
+	return p, nil
+}
+
+// Merge returns a new policy denying everything denied by p or o
+func (p *Policy) Merge(o *Policy) *Policy {
+	denyPatterns := make([]string, 0, len(p.denyPatterns)+len(o.denyPatterns))
+	denyPatterns = append(denyPatterns, p.denyPatterns...)
+	denyPatterns = append(denyPatterns, o.denyPatterns...)
+	return NewPolicy(denyPatterns)
+}
+
+func (p *Policy) IsEmpty() bool {
+	return len(p.denyPatterns) == 0
+}
+
+func (p *Policy) IsDenied(method string) bool {
+	for _, pattern := range p.denyPatterns {
+		if matched, _ := path.Match(pattern, method); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Intercept answers denied commands with a CDP error instead of forwarding them to chrome.
+// Messages that cannot be parsed are answered with an error too, since their method cannot be checked
+func (p *Policy) Intercept(ctx context.Context, msg []byte) (forward []byte, reply []byte) {
+	log := logger.Get()
+
+	cdpMsg, err := Parse(msg)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg("blocked cdp message that cannot be parsed")
+		reply, err = NewInvalidMessageResponse(msg, err)
+		if err != nil {
+			log.Err(err).Ctx(ctx).Msg("unable to create cdp error response")
+			return nil, nil
+		}
+		return nil, reply
+	}
+	if !cdpMsg.IsCommand() || !p.IsDenied(cdpMsg.Method) {
+		return msg, nil
+	}
+
+	log.Warn().Ctx(ctx).Str("method", cdpMsg.Method).Msg("blocked cdp command denied by policy")
+
+	reply, err = NewErrorResponse(cdpMsg, ServerError, fmt.Sprintf("'%s' is not allowed by the proxy policy", cdpMsg.Method))
+	if err != nil {
+		log.Err(err).Ctx(ctx).Msg("unable to create cdp error response")
+		return nil, nil
+	}
+	return nil, reply
+}
@@ -0,0 +1,107 @@
+package cdpmessage
+
+import (
+	"chromium-websocket-proxy/config"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+)
+
+type PolicyTestSuite struct {
+	suite.Suite
+}
+
+// run before each test
+func (suite *PolicyTestSuite) SetupTest() {
+	config.Once = sync.Once{}
+}
+
+func (suite *PolicyTestSuite) TestIsDeniedMatchesPatterns() {
+	p := NewPolicy([]string{"Browser.close", "Target.*BrowserContext"})
+
+	assert.True(suite.T(), p.IsDenied("Browser.close"))
+	assert.True(suite.T(), p.IsDenied("Target.createBrowserContext"))
+	assert.True(suite.T(), p.IsDenied("Target.disposeBrowserContext"))
+	assert.False(suite.T(), p.IsDenied("Target.createTarget"))
+	assert.False(suite.T(), p.IsDenied("Page.navigate"))
+}
+
+func (suite *PolicyTestSuite) TestInterceptRepliesToDeniedCommand() {
+	p := NewPolicy([]string{"Browser.close"})
+	msg := []byte(`{"id":7,"method":"Browser.close","sessionId":"abc"}`)
+
+	forward, reply := p.Intercept(context.Background(), msg)
+
+	assert.Nil(suite.T(), forward)
+	var res Message
+	assert.Nil(suite.T(), json.Unmarshal(reply, &res))
+	assert.Equal(suite.T(), int64(7), *res.Id)
+	assert.Equal(suite.T(), "abc", res.SessionId)
+	assert.Equal(suite.T(), ServerError, res.Error.Code)
+	assert.Contains(suite.T(), res.Error.Message, "Browser.close")
+}
+
+func (suite *PolicyTestSuite) TestInterceptForwardsAllowedMessages() {
+	p := NewPolicy([]string{"Browser.close"})
+
+	for _, msg := range [][]byte{
+		[]byte(`{"id":1,"method":"Page.navigate","params":{"url":"about:blank"}}`),
+		[]byte(`{"method":"Browser.close"}`),
+	} {
+		forward, reply := p.Intercept(context.Background(), msg)
+		assert.Equal(suite.T(), msg, forward)
+		assert.Nil(suite.T(), reply)
+	}
+}
+
+func (suite *PolicyTestSuite) TestInterceptRepliesToUnparsableMessages() {
+	p := NewPolicy([]string{"Browser.close"})
+
+	for _, msg := range [][]byte{
+		[]byte(`not json`),
+		// encoding/json would read the last duplicate, or the key differing by case, while chrome reads the exact key
+		[]byte(`{"id":1,"method":"Browser.close","Method":"Runtime.evaluate"}`),
+		[]byte(`{"id":1,"method":"Browser.close","method":"Runtime.evaluate"}`),
+		[]byte(`{"id":1,"method":"Browser.close"} {"id":2}`),
+	} {
+		forward, reply := p.Intercept(context.Background(), msg)
+		assert.Nil(suite.T(), forward, string(msg))
+		var res Message
+		assert.Nil(suite.T(), json.Unmarshal(reply, &res))
+		assert.Equal(suite.T(), InvalidRequest, res.Error.Code)
+	}
+}
+
+func (suite *PolicyTestSuite) TestParseReadsExactKeys() {
+	msg, err := Parse([]byte(`{"id":1,"Method":"Browser.close","sessionId":"abc"}`))
+	assert.Nil(suite.T(), err)
+	assert.Empty(suite.T(), msg.Method)
+	assert.False(suite.T(), msg.IsCommand())
+	assert.Equal(suite.T(), "abc", msg.SessionId)
+}
+
+func (suite *PolicyTestSuite) TestConnectionPolicyOnlyAddsRestrictions() {
+	suite.T().Setenv(config.CdpPolicyDenyMethods, "Page.navigate")
+
+	p, err := GetPolicyForConnection(config.CdpPolicySharedBrowser)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), p.IsDenied("Page.navigate"))
+	assert.True(suite.T(), p.IsDenied("Browser.close"))
+
+	p, err = GetPolicyForConnection(config.CdpPolicyPermissive)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), p.IsDenied("Page.navigate"))
+	assert.False(suite.T(), p.IsDenied("Browser.close"))
+}
+
+func (suite *PolicyTestSuite) TestUnknownConnectionPolicyErrors() {
+	_, err := GetPolicyForConnection("unknown")
+	assert.Error(suite.T(), err)
+}
+
+func TestPolicySuite(t *testing.T) {
+	suite.Run(t, new(PolicyTestSuite))
+}
@@ -5,9 +5,12 @@ import (
 	"chromium-websocket-proxy/config"
 	"chromium-websocket-proxy/logger"
 	"context"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/target"
 	"github.com/chromedp/chromedp"
 	"github.com/google/uuid"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
@@ -22,6 +25,7 @@ type IChrome interface {
 	Ctx() context.Context
 	BrowserID() uuid.UUID
 	Port() int
+	StartedAt() time.Time
 	SessionId() uuid.UUID
 	SetSessionId(uuid.UUID)
 	IsIdle() bool
@@ -30,22 +34,32 @@ type IChrome interface {
 	SetIdleOrStop()
 	StartTicker()
 	PauseTicker()
+	CreateBrowserContext() (cdp.BrowserContextID, error)
+	AddBrowserContext(cdp.BrowserContextID)
+	RemoveBrowserContext(cdp.BrowserContextID)
+	BrowserContextIDs() []cdp.BrowserContextID
+	CreatePageTarget(url string, browserContextID cdp.BrowserContextID) (target.ID, error)
+	PageDebugUrl(targetID target.ID) string
+	GetTargetInfo(targetID target.ID) (*target.Info, error)
+	Version() Version
 }
 
 type Chrome struct {
-	ea        ctxWithCancel
-	ctx       context.Context
-	cancel    context.CancelFunc
-	sessionId uuid.UUID
-	port      int
-	conf      config.ChromeConfig
-	mutex     sync.RWMutex
-	meta      Meta
-	event     event
-	isIdle    bool
-	isNew     bool
-	isNewOnce sync.Once
-	options   config.ChromeConfigOptions
+	ea                ctxWithCancel
+	ctx               context.Context
+	cancel            context.CancelFunc
+	sessionId         uuid.UUID
+	port              int
+	conf              config.ChromeConfig
+	mutex             sync.RWMutex
+	meta              Meta
+	event             event
+	isIdle            bool
+	isNew             bool
+	isNewOnce         sync.Once
+	options           config.ChromeConfigOptions
+	browserContextIDs []cdp.BrowserContextID
+	startedAt         time.Time
 }
 
 type EventData struct {
@@ -149,6 +163,20 @@ func (crm *Chrome) DebugUrl() string {
 	return crm.meta.debugUrl
 }
 
+// PageDebugUrl returns the websocket url of the page target with targetID, on the same host as DebugUrl
+func (crm *Chrome) PageDebugUrl(targetID target.ID) string {
+	debugUrl, err := url.Parse(crm.meta.debugUrl)
+	if err != nil {
+		return fmt.Sprintf("ws://localhost:%d/devtools/page/%s", crm.port, targetID)
+	}
+	debugUrl.Path = fmt.Sprintf("/devtools/page/%s", targetID)
+	return debugUrl.String()
+}
+
+func (crm *Chrome) Version() Version {
+	return crm.meta.version
+}
+
 func (crm *Chrome) SessionId() uuid.UUID {
 	return crm.sessionId
 }
@@ -178,6 +206,11 @@ func (crm *Chrome) Port() int {
 	return crm.port
 }
 
+// StartedAt returns when the browser finished starting
+func (crm *Chrome) StartedAt() time.Time {
+	return crm.startedAt
+}
+
 func (crm *Chrome) IsIdle() bool {
 	return crm.isIdle
 }
@@ -193,9 +226,85 @@ func (crm *Chrome) SetNotIdle() {
 	})
 }
 
+// browserExecutorCtx returns a ctx that runs cdp commands against the browser target instead of the first page
+func (crm *Chrome) browserExecutorCtx() context.Context {
+	return cdp.WithExecutor(crm.ctx, chromedp.FromContext(crm.ctx).Browser)
+}
+
+// CreateBrowserContext creates an incognito browser context owned by the current session.
+// It is disposed when the session ends in SetIdleOrStop
+func (crm *Chrome) CreateBrowserContext() (cdp.BrowserContextID, error) {
+	browserContextID, err := target.CreateBrowserContext().Do(crm.browserExecutorCtx())
+	if err != nil {
+		return "", err
+	}
+	crm.AddBrowserContext(browserContextID)
+
+	log := logger.Get()
+	log.Debug().Ctx(crm.ea.ctx).Str("browserContextId", string(browserContextID)).Msg("created browser context for session")
+	return browserContextID, nil
+}
+
+// CreatePageTarget opens a page at url for sessions connecting to a page instead of the browser. The page is created
+// in browserContextID if it is set, so that it is closed with the session's browser context
+func (crm *Chrome) CreatePageTarget(url string, browserContextID cdp.BrowserContextID) (target.ID, error) {
+	createTarget := target.CreateTarget(url)
+	if len(browserContextID) > 0 {
+		createTarget = createTarget.WithBrowserContextID(browserContextID)
+	}
+	return createTarget.Do(crm.browserExecutorCtx())
+}
+
+// GetTargetInfo returns the info of the target with targetID, or an error if the browser has no such target
+func (crm *Chrome) GetTargetInfo(targetID target.ID) (*target.Info, error) {
+	return target.GetTargetInfo().WithTargetID(targetID).Do(crm.browserExecutorCtx())
+}
+
+// AddBrowserContext tracks a browser context created by the current session so that it is disposed with the session
+func (crm *Chrome) AddBrowserContext(browserContextID cdp.BrowserContextID) {
+	crm.mutex.Lock()
+	defer crm.mutex.Unlock()
+	crm.browserContextIDs = append(crm.browserContextIDs, browserContextID)
+}
+
+// RemoveBrowserContext stops tracking a browser context the session has already disposed
+func (crm *Chrome) RemoveBrowserContext(browserContextID cdp.BrowserContextID) {
+	crm.mutex.Lock()
+	defer crm.mutex.Unlock()
+	for i := range crm.browserContextIDs {
+		if crm.browserContextIDs[i] == browserContextID {
+			crm.browserContextIDs = append(crm.browserContextIDs[:i], crm.browserContextIDs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (crm *Chrome) BrowserContextIDs() []cdp.BrowserContextID {
+	crm.mutex.RLock()
+	defer crm.mutex.RUnlock()
+	return append([]cdp.BrowserContextID{}, crm.browserContextIDs...)
+}
+
+// disposeBrowserContexts closes every browser context owned by the session along with their pages, cookies and storage
+func (crm *Chrome) disposeBrowserContexts() {
+	crm.mutex.Lock()
+	browserContextIDs := crm.browserContextIDs
+	crm.browserContextIDs = nil
+	crm.mutex.Unlock()
+
+	log := logger.Get()
+	for _, browserContextID := range browserContextIDs {
+		err := target.DisposeBrowserContext(browserContextID).Do(crm.browserExecutorCtx())
+		if err != nil {
+			log.Err(err).Ctx(crm.ea.ctx).Str("browserContextId", string(browserContextID)).Msg("unable to dispose browser context")
+		}
+	}
+}
+
 func (crm *Chrome) SetIdleOrStop() {
 	log := logger.Get()
 	if crm.conf.EnableBrowserReuse {
+		crm.disposeBrowserContexts()
 		crm.isIdle = true
 		crm.SetSessionId(uuid.Nil)
 		log.Info().Ctx(crm.ea.ctx).Msg("set chrome instance to idle for reuse")
@@ -243,6 +352,8 @@ func (crm *Chrome) Start() error {
 		return err
 	}
 
+	crm.startedAt = time.Now()
+
 	// register browser listener
 	chromedp.ListenBrowser(crm.ctx, crm.onBrowserEvent)
 
@@ -17,6 +17,21 @@ type Meta struct {
 	debugUrl          string
 	browserID         uuid.UUID
 	firstPageTargetID target.ID
+	version           Version
+}
+
+// Version is the browser version reported by the /json/version endpoint of the debug port
+type Version struct {
+	Browser         string `json:"Browser"`
+	ProtocolVersion string `json:"Protocol-Version"`
+	UserAgent       string `json:"User-Agent"`
+	V8Version       string `json:"V8-Version"`
+	WebKitVersion   string `json:"WebKit-Version"`
+}
+
+type versionResponse struct {
+	Version
+	WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
 }
 
 func (crm *Chrome) fetchAndSetMeta() error {
@@ -31,14 +46,15 @@ func (crm *Chrome) fetchAndSetMeta() error {
 		return err
 	}
 
-	var result map[string]interface{}
+	var result versionResponse
 
 	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return err
 	}
 
-	// set debugUrl from response
-	crm.meta.debugUrl = result["webSocketDebuggerUrl"].(string)
+	// set debugUrl and version from response
+	crm.meta.debugUrl = result.WebSocketDebuggerUrl
+	crm.meta.version = result.Version
 
 	// parse browser id from debugUrl
 	browserID := r.FindString(crm.meta.debugUrl)
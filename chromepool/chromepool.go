@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"sync"
+	"time"
 )
 
 type IChromePool interface {
@@ -17,6 +18,19 @@ type IChromePool interface {
 	HasIdleChromeInstance() bool
 	CreateNewInstance(options config.ChromeConfigOptions) error
 	IsPoolAtCapacity() bool
+	GetInstances() []chrome.IChrome
+	GetInstanceBySessionId(sessionId uuid.UUID) (chrome.IChrome, error)
+	StopInstance(browserID uuid.UUID) error
+	DrainIdleInstances() int
+	RetireIdleInstance() bool
+	PrewarmInstances(count int, options config.ChromeConfigOptions) (int, error)
+	MaintainWarmInstances() int
+	GetConsecutiveLaunchFailures() int
+	GetPinnedChrome(pin Pin, maxPins int, sessionId uuid.UUID, options config.ChromeConfigOptions) (*chrome.IChrome, error)
+	ReturnPinnedChrome(pin Pin)
+	ReleasePin(pin Pin) error
+	ReleaseIdlePins() int
+	HasAvailablePin() bool
 }
 
 type ChromePool struct {
@@ -26,6 +40,21 @@ type ChromePool struct {
 	availableDebuggingPorts   []int
 	chromeEventReceiver       chan chrome.EventData
 	chromeEventReceiveStopper chan bool
+	demand                    *profileDemand
+	consecutiveLaunchFailures int
+	pins                      map[Pin]*pinnedBrowser
+	// windows of the scheduled SCALER_STRATEGY, whose browsers are kept warm like profile minimums
+	schedule []config.ScalerScheduleWindow
+	// browsers launching outside the lock, counted towards MAX_BROWSER_INSTANCES
+	startingInstances int
+	// keeps overlapping MaintainWarmInstances calls from starting the same browsers
+	warmMutex sync.Mutex
+}
+
+// warmTarget is the number of idle browsers to keep warm with options
+type warmTarget struct {
+	options config.ChromeConfigOptions
+	count   int
 }
 
 var once = sync.Once{}
@@ -49,8 +78,12 @@ func Get() IChromePool {
 			availableDebuggingPorts:   make([]int, len(conf.GetChromePoolConfig().DebugPorts)),
 			chromeEventReceiver:       createChromeEventReceiver(),
 			chromeEventReceiveStopper: createChromeEventReceiveStopper(),
+			demand:                    newProfileDemand(),
 		}
 		copy(cp.availableDebuggingPorts[:], conf.GetChromePoolConfig().DebugPorts)
+		if pqConf := conf.GetProxyQueueConfig(); pqConf.ScalerStrategy == config.ScalerStrategyScheduled {
+			cp.schedule, _ = config.ParseScalerSchedule(pqConf.ScalerSchedule)
+		}
 
 		for i := 0; i < conf.GetChromePoolConfig().MinBrowserInstances; i++ {
 			err := cp.CreateNewInstance(conf.GetChromeConfig().DefaultOptions)
@@ -58,6 +91,7 @@ func Get() IChromePool {
 				log.Fatal().Err(err).Msg("unable to start chromium")
 			}
 		}
+		cp.MaintainWarmInstances()
 		go cp.chromiumEventReceiver()
 
 		log.Info().Msg(fmt.Sprintf("initialized %d chromium browser(s)", cp.GetInstancePoolLen()))
@@ -114,6 +148,17 @@ func (cp *ChromePool) checkInstanceByBrowserIdToRemove(browserID uuid.UUID) {
 		(*crm).PauseTicker()
 		return
 	}
+
+	// this is a warm browser for a profile in demand or with a minimum. Leave it be
+	hash := (*crm).Options().Hash
+	if (*crm).IsIdle() && cp.countIdleInstancesLocked(hash) <= cp.warmTargetsLocked(time.Now())[hash].count {
+		log.Debug().
+			Str("browserId", (*crm).BrowserID().String()).
+			Str("profile", (*crm).Options().Profile).
+			Msg("Warm browser for profile has been idle, pausing event handlers to reduce memory")
+		(*crm).PauseTicker()
+		return
+	}
 	log.Debug().
 		Str("browserId", (*crm).BrowserID().String()).
 		Msg(fmt.Sprintf(
@@ -132,7 +177,7 @@ func (cp *ChromePool) removeInstanceByBrowserId(browserID uuid.UUID) {
 func (cp *ChromePool) IsPoolAtCapacity() bool {
 	cp.instancePoolMutex.RLock()
 	defer cp.instancePoolMutex.RUnlock()
-	return cp.getInstancePoolLenLocked() >= config.Get().GetChromePoolConfig().MaxBrowserInstances
+	return cp.getInstancePoolLenLocked()+cp.startingInstances >= config.Get().GetChromePoolConfig().MaxBrowserInstances
 }
 
 func (cp *ChromePool) HasIdleChromeInstance() bool {
@@ -142,36 +187,13 @@ func (cp *ChromePool) HasIdleChromeInstance() bool {
 }
 
 func (cp *ChromePool) GetAvailableChrome(sessionId uuid.UUID, options config.ChromeConfigOptions) (*chrome.IChrome, error) {
-	cp.instancePoolMutex.Lock()
-	defer cp.instancePoolMutex.Unlock()
-
-	ipLen := cp.getInstancePoolLenLocked()
-
-	// create instance if none exist
-	if ipLen == 0 {
-		return cp.createChromeWLocked(sessionId, options)
-	}
-
-	// get existing idle browser with profile
-	crm := cp.getIdleChromeLocked(options)
-	if crm != nil {
-		log := logger.Get()
-		log.Info().
-			Str("browserId", (*crm).BrowserID().String()).
-			Str("sessionId", sessionId.String()).
-			Msg("using idle chrome instance for session")
-		(*crm).SetSessionId(sessionId)
-		(*crm).SetNotIdle()
-		(*crm).StartTicker()
-		return crm, nil
-	}
-
-	// This is not default options. Attempt to create
 	if options.Hash != config.Get().GetChromeConfig().DefaultOptions.Hash {
-		return cp.createChromeWLocked(sessionId, options)
+		cp.demand.record(options, time.Now())
 	}
 
-	return nil, errors.New("no browser available for use")
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+	return cp.getAvailableChromeWLocked(sessionId, options)
 }
 
 func (cp *ChromePool) ShutDownPool() {
@@ -188,6 +210,152 @@ func (cp *ChromePool) ShutDownPool() {
 	log.Info().Msg("gracefully shutdown chrome pool")
 }
 
+// GetInstances returns a snapshot of every chrome instance in the pool
+func (cp *ChromePool) GetInstances() []chrome.IChrome {
+	cp.instancePoolMutex.RLock()
+	defer cp.instancePoolMutex.RUnlock()
+	instances := make([]chrome.IChrome, len(cp.instancePool))
+	for i := range cp.instancePool {
+		instances[i] = *cp.instancePool[i]
+	}
+	return instances
+}
+
+// GetInstanceBySessionId returns the chrome instance the session with sessionId is using
+func (cp *ChromePool) GetInstanceBySessionId(sessionId uuid.UUID) (chrome.IChrome, error) {
+	cp.instancePoolMutex.RLock()
+	defer cp.instancePoolMutex.RUnlock()
+	crm := cp.getInstanceBySessionIdLocked(sessionId)
+	if crm == nil {
+		return nil, errors.New(fmt.Sprintf("no browser for session %s", sessionId.String()))
+	}
+	return *crm, nil
+}
+
+// StopInstance stops the chrome instance with browserID, ending any session using it
+func (cp *ChromePool) StopInstance(browserID uuid.UUID) error {
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+	crm, _ := cp.getInstanceByBrowserIDLocked(browserID)
+	if crm == nil {
+		return errors.New(fmt.Sprintf("no browser with id %s", browserID.String()))
+	}
+	cp.removeInstanceByBrowserIdWLocked(browserID)
+	return nil
+}
+
+// DrainIdleInstances stops every idle chrome instance, leaving browsers with sessions running, and starts fresh
+// browsers with the default options until the pool is back at MIN_BROWSER_INSTANCES. Returns the number of
+// instances stopped
+func (cp *ChromePool) DrainIdleInstances() int {
+	cp.instancePoolMutex.Lock()
+	drained := 0
+	for i := cp.getInstancePoolLenLocked() - 1; i >= 0; i-- {
+		if (*cp.instancePool[i]).IsIdle() {
+			cp.removeInstanceAtIndexWLocked(i)
+			drained++
+		}
+	}
+	missing := config.Get().GetChromePoolConfig().MinBrowserInstances - cp.getInstancePoolLenLocked() - cp.startingInstances
+	cp.instancePoolMutex.Unlock()
+
+	log := logger.Get()
+	for i := 0; i < missing; i++ {
+		if err := cp.startChrome(config.Get().GetChromeConfig().DefaultOptions); err != nil {
+			log.Err(err).Msg("unable to start chrome browser")
+			break
+		}
+	}
+	return drained
+}
+
+// RetireIdleInstance stops one idle chrome instance if the pool is above MIN_BROWSER_INSTANCES. Browsers with
+// non-default options are retired first, since MIN_BROWSER_INSTANCES are started with the default options.
+// Browsers kept warm for a profile are not retired. Returns false if nothing was retired
+func (cp *ChromePool) RetireIdleInstance() bool {
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+
+	conf := config.Get()
+	if cp.getInstancePoolLenLocked() <= conf.GetChromePoolConfig().MinBrowserInstances {
+		return false
+	}
+
+	i := cp.getIdleInstanceIndexToRetireLocked(conf.GetChromeConfig().DefaultOptions, cp.warmTargetsLocked(time.Now()))
+	if i < 0 {
+		return false
+	}
+	cp.removeInstanceAtIndexWLocked(i)
+	return true
+}
+
+// PrewarmInstances starts count idle chrome instances with options, stopping early if the pool reaches
+// MAX_BROWSER_INSTANCES. Returns the number of instances started
+func (cp *ChromePool) PrewarmInstances(count int, options config.ChromeConfigOptions) (int, error) {
+	for i := 0; i < count; i++ {
+		if err := cp.startChrome(options); err != nil {
+			return i, err
+		}
+	}
+	return count, nil
+}
+
+// MaintainWarmInstances starts idle browsers for every profile below its PROFILE_MIN_BROWSER_INSTANCES, and for
+// every profile requested PROFILE_DEMAND_THRESHOLD times within PROFILE_DEMAND_WINDOW_IN_SECS until it has
+// PROFILE_WARM_BROWSER_INSTANCES idle browsers. Default browsers are started until the pool has the instances
+// SCALER_SCHEDULE asks for. Browsers are not started past MAX_BROWSER_INSTANCES.
+// Returns the number of instances started
+func (cp *ChromePool) MaintainWarmInstances() int {
+	cp.warmMutex.Lock()
+	defer cp.warmMutex.Unlock()
+
+	cp.instancePoolMutex.RLock()
+	toStart := cp.getWarmOptionsToStartLocked(cp.warmTargetsLocked(time.Now()))
+	cp.instancePoolMutex.RUnlock()
+
+	log := logger.Get()
+	started := 0
+	failed := make(map[string]bool)
+	for _, options := range toStart {
+		if failed[options.Hash] {
+			continue
+		}
+		if err := cp.startChrome(options); err != nil {
+			log.Err(err).Str("profile", options.Profile).Msg("unable to prewarm chrome browser")
+			failed[options.Hash] = true
+			continue
+		}
+		log.Info().Str("profile", options.Profile).Msg("prewarmed chrome browser")
+		started++
+	}
+	return started
+}
+
+// startChrome starts an idle browser with options. The pool is only locked to reserve and add the browser, so
+// sessions are not held up while it launches
+func (cp *ChromePool) startChrome(options config.ChromeConfigOptions) error {
+	cp.instancePoolMutex.Lock()
+	crm, err := cp.reserveChromeWLocked(uuid.Nil, options)
+	cp.instancePoolMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	startErr := crm.Start()
+
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+	_, err = cp.addStartedChromeWLocked(crm, startErr)
+	return err
+}
+
+// GetConsecutiveLaunchFailures returns the number of browser launches that failed since the last one that started
+func (cp *ChromePool) GetConsecutiveLaunchFailures() int {
+	cp.instancePoolMutex.RLock()
+	defer cp.instancePoolMutex.RUnlock()
+	return cp.consecutiveLaunchFailures
+}
+
 func (cp *ChromePool) GetInstancePoolLen() int {
 	cp.instancePoolMutex.RLock()
 	defer cp.instancePoolMutex.RUnlock()
@@ -340,6 +340,342 @@ func (suite *ChromePoolTestSuite) TestShutdownPool() {
 	assert.Equal(suite.T(), 0, cp.GetInstancePoolLen())
 }
 
+func (suite *ChromePoolTestSuite) TestPrewarmDrainAndStopInstances() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(0, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(3, 10))
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		return cm
+	}
+
+	opt, _ := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{
+		Profile: "",
+	})
+
+	cp := Get()
+
+	// prewarming stops at MAX_BROWSER_INSTANCES
+	started, err := cp.PrewarmInstances(5, opt)
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), 3, started)
+	assert.Len(suite.T(), cp.GetInstances(), 3)
+
+	busy, err := cp.GetAvailableChrome(uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+
+	// only idle instances are drained
+	assert.Equal(suite.T(), 2, cp.DrainIdleInstances())
+	instances := cp.GetInstances()
+	assert.Len(suite.T(), instances, 1)
+	assert.Equal(suite.T(), (*busy).BrowserID(), instances[0].BrowserID())
+
+	assert.Error(suite.T(), cp.StopInstance(uuid.New()))
+	assert.Nil(suite.T(), cp.StopInstance((*busy).BrowserID()))
+	assert.Equal(suite.T(), 0, cp.GetInstancePoolLen())
+
+	cp.ShutDownPool()
+}
+
+func (suite *ChromePoolTestSuite) TestPrewarmDoesNotHoldThePoolWhileStarting() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(0, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(1, 10))
+	_ = metrics.Init()
+
+	starting := make(chan struct{})
+	started := make(chan struct{})
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		cm.SetStart(func() error {
+			starting <- struct{}{}
+			<-started
+			return nil
+		})
+		return cm
+	}
+
+	cp := Get()
+	opt, _ := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{Profile: ""})
+	prewarmed := make(chan int)
+	go func() {
+		n, _ := cp.PrewarmInstances(1, opt)
+		prewarmed <- n
+	}()
+
+	// the pool can be used while the browser launches, and the launching browser counts towards the maximum
+	<-starting
+	assert.Equal(suite.T(), 0, cp.GetInstancePoolLen())
+	assert.True(suite.T(), cp.IsPoolAtCapacity())
+	_, err := cp.PrewarmInstances(1, opt)
+	assert.Error(suite.T(), err)
+	close(started)
+	assert.Equal(suite.T(), 1, <-prewarmed)
+	assert.Equal(suite.T(), 1, cp.GetInstancePoolLen())
+
+	cp.ShutDownPool()
+}
+
+func (suite *ChromePoolTestSuite) TestDrainRefillsMinInstances() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(1, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(3, 10))
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		return cm
+	}
+
+	cp := Get()
+	drained := cp.GetInstances()[0].BrowserID()
+	custom := config.ChromeConfigOptions{Profile: "custom", Hash: "custom"}
+	_, err := cp.PrewarmInstances(1, custom)
+	assert.Nil(suite.T(), err)
+
+	assert.Equal(suite.T(), 2, cp.DrainIdleInstances())
+	instances := cp.GetInstances()
+	assert.Len(suite.T(), instances, 1)
+	assert.NotEqual(suite.T(), drained, instances[0].BrowserID())
+	assert.Equal(suite.T(), config.Get().GetChromeConfig().DefaultOptions.Hash, instances[0].Options().Hash)
+
+	cp.ShutDownPool()
+}
+
+func (suite *ChromePoolTestSuite) TestRetireIdleInstanceRespectsMinInstances() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(1, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(3, 10))
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		return cm
+	}
+
+	cp := Get()
+	custom := config.ChromeConfigOptions{Profile: "custom", Hash: "custom"}
+	started, err := cp.PrewarmInstances(2, custom)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, started)
+	assert.Equal(suite.T(), 3, cp.GetInstancePoolLen())
+
+	// browsers with non-default options are retired first
+	assert.True(suite.T(), cp.RetireIdleInstance())
+	assert.True(suite.T(), cp.RetireIdleInstance())
+	instances := cp.GetInstances()
+	assert.Len(suite.T(), instances, 1)
+	assert.Equal(suite.T(), config.Get().GetChromeConfig().DefaultOptions.Hash, instances[0].Options().Hash)
+
+	// the pool never shrinks below MIN_BROWSER_INSTANCES
+	assert.False(suite.T(), cp.RetireIdleInstance())
+	assert.Equal(suite.T(), 1, cp.GetInstancePoolLen())
+
+	cp.ShutDownPool()
+}
+
+func (suite *ChromePoolTestSuite) TestMaintainWarmInstancesForProfiles() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(0, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(4, 10))
+	suite.T().Setenv(config.ProfileMinBrowserInstances, "pinned:1")
+	suite.T().Setenv(config.ProfileWarmBrowserInstances, strconv.FormatInt(1, 10))
+	suite.T().Setenv(config.ProfileDemandThreshold, strconv.FormatInt(2, 10))
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		if payload.SessionId == uuid.Nil {
+			cm.SetIdleOrStop()
+		}
+		return cm
+	}
+
+	// profile minimums are started with the pool
+	cp := Get()
+	pinned, _ := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{Profile: "pinned"})
+	instances := cp.GetInstances()
+	assert.Len(suite.T(), instances, 1)
+	assert.Equal(suite.T(), pinned.Hash, instances[0].Options().Hash)
+
+	// a profile becomes hot after PROFILE_DEMAND_THRESHOLD requests
+	custom, _ := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{Profile: "custom"})
+	_, err := cp.GetAvailableChrome(uuid.New(), custom)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, cp.MaintainWarmInstances())
+	_, err = cp.GetAvailableChrome(uuid.New(), custom)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, cp.MaintainWarmInstances())
+	assert.Equal(suite.T(), 4, cp.GetInstancePoolLen())
+
+	// warm browsers are kept, and never started past MAX_BROWSER_INSTANCES
+	assert.Equal(suite.T(), 0, cp.MaintainWarmInstances())
+	assert.False(suite.T(), cp.RetireIdleInstance())
+	assert.Equal(suite.T(), 4, cp.GetInstancePoolLen())
+
+	cp.ShutDownPool()
+}
+
+func (suite *ChromePoolTestSuite) TestScheduledInstancesAreKeptWarm() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(0, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(3, 10))
+	suite.T().Setenv(config.ScalerStrategy, config.ScalerStrategyScheduled)
+	// covers the whole day
+	suite.T().Setenv(config.ScalerSchedule, "00:00-12:00=2,12:00-00:00=2")
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		return cm
+	}
+
+	// the scheduled browsers are started with the pool
+	cp := Get()
+	assert.Equal(suite.T(), 2, cp.GetInstancePoolLen())
+
+	// and are not reaped once they have been idle for BROWSER_AUTO_SHUTDOWN_TIMEOUT
+	pool := cp.(*ChromePool)
+	pool.checkInstanceByBrowserIdToRemove(cp.GetInstances()[0].BrowserID())
+	assert.Equal(suite.T(), 2, cp.GetInstancePoolLen())
+	assert.False(suite.T(), cp.RetireIdleInstance())
+
+	// browsers above the schedule are
+	_, err := cp.PrewarmInstances(1, config.Get().GetChromeConfig().DefaultOptions)
+	assert.Nil(suite.T(), err)
+	pool.checkInstanceByBrowserIdToRemove(cp.GetInstances()[2].BrowserID())
+	assert.Equal(suite.T(), 2, cp.GetInstancePoolLen())
+
+	// busy browsers count towards the schedule
+	_, err = cp.GetAvailableChrome(uuid.New(), config.Get().GetChromeConfig().DefaultOptions)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, cp.MaintainWarmInstances())
+
+	cp.ShutDownPool()
+}
+
 func TestLoggerSuite(t *testing.T) {
 	suite.Run(t, new(ChromePoolTestSuite))
 }
+
+func (suite *ChromePoolTestSuite) TestPinnedBrowsers() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(0, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(3, 10))
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		return cm
+	}
+
+	opt, _ := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{
+		Profile: "",
+	})
+
+	cp := Get()
+	started, err := cp.PrewarmInstances(3, opt)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 3, started)
+
+	login := Pin{Tenant: "acme", Key: "login"}
+	pinned, err := cp.GetPinnedChrome(login, 2, uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+	assert.False(suite.T(), (*pinned).IsIdle())
+
+	// one session uses the pinned browser at a time
+	_, err = cp.GetPinnedChrome(login, 2, uuid.New(), opt)
+	assert.ErrorIs(suite.T(), err, ErrPinInUse)
+	assert.False(suite.T(), cp.HasAvailablePin())
+
+	cp.ReturnPinnedChrome(login)
+	assert.True(suite.T(), cp.HasAvailablePin())
+	assert.False(suite.T(), (*pinned).IsIdle(), "pinned browsers are not handed to other sessions")
+
+	again, err := cp.GetPinnedChrome(login, 2, uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), (*pinned).BrowserID(), (*again).BrowserID())
+	cp.ReturnPinnedChrome(login)
+
+	custom := config.ChromeConfigOptions{Profile: "custom", Hash: "custom"}
+	_, err = cp.GetPinnedChrome(login, 2, uuid.New(), custom)
+	assert.ErrorIs(suite.T(), err, ErrPinProfileMismatch)
+
+	// the cap is per tenant
+	_, err = cp.GetPinnedChrome(Pin{Tenant: "acme", Key: "checkout"}, 2, uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+	_, err = cp.GetPinnedChrome(Pin{Tenant: "acme", Key: "search"}, 2, uuid.New(), opt)
+	assert.ErrorIs(suite.T(), err, ErrPinLimitReached)
+	_, err = cp.GetPinnedChrome(Pin{Tenant: "other", Key: "login"}, 2, uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+
+	// released browsers are stopped since they keep the state of the sticky session
+	assert.Nil(suite.T(), cp.ReleasePin(login))
+	assert.ErrorIs(suite.T(), cp.ReleasePin(login), ErrPinNotFound)
+	assert.Equal(suite.T(), 2, cp.GetInstancePoolLen())
+	for _, crm := range cp.GetInstances() {
+		assert.NotEqual(suite.T(), (*pinned).BrowserID(), crm.BrowserID())
+	}
+
+	cp.ShutDownPool()
+	assert.False(suite.T(), cp.HasAvailablePin())
+}
+
+func (suite *ChromePoolTestSuite) TestReleaseIdlePins() {
+	suite.T().Setenv(config.MinBrowserInstances, strconv.FormatInt(0, 10))
+	suite.T().Setenv(config.MaxBrowserInstances, strconv.FormatInt(2, 10))
+	suite.T().Setenv(config.StickySessionIdleTtlInSecs, strconv.FormatInt(60, 10))
+	_ = metrics.Init()
+
+	chromeCreator = func(payload chrome.CreateChromePayload) chrome.IChrome {
+		cm := chromemock.NewMock()
+		cm.SetBrowserID(uuid.New())
+		cm.SetOptions(payload.Options)
+		cm.SetIdleOrStop()
+		return cm
+	}
+
+	opt, _ := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{
+		Profile: "",
+	})
+
+	cp := Get()
+	_, err := cp.PrewarmInstances(2, opt)
+	assert.Nil(suite.T(), err)
+
+	idle := Pin{Key: "idle"}
+	busy := Pin{Key: "busy"}
+	_, err = cp.GetPinnedChrome(idle, 0, uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+	_, err = cp.GetPinnedChrome(busy, 0, uuid.New(), opt)
+	assert.Nil(suite.T(), err)
+	cp.ReturnPinnedChrome(idle)
+
+	// the ttl has not passed yet
+	assert.Equal(suite.T(), 0, cp.ReleaseIdlePins())
+
+	pool := cp.(*ChromePool)
+	pool.instancePoolMutex.Lock()
+	pool.pins[idle].lastUsedAt = time.Now().Add(-time.Minute)
+	pool.instancePoolMutex.Unlock()
+	assert.Equal(suite.T(), 1, cp.ReleaseIdlePins())
+	assert.Equal(suite.T(), 1, cp.GetInstancePoolLen())
+	assert.ErrorIs(suite.T(), cp.ReleasePin(idle), ErrPinNotFound)
+
+	cp.ShutDownPool()
+}
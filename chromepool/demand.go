@@ -0,0 +1,61 @@
+package chromepool
+
+import (
+	"chromium-websocket-proxy/config"
+	"sync"
+	"time"
+)
+
+// profileDemand tracks when sessions asked for browsers with each set of options, so that browsers for profiles in
+// demand can be started before the next session asks for one
+type profileDemand struct {
+	mutex    sync.Mutex
+	requests map[string]*profileRequests
+}
+
+type profileRequests struct {
+	options config.ChromeConfigOptions
+	times   []time.Time
+}
+
+func newProfileDemand() *profileDemand {
+	return &profileDemand{
+		requests: make(map[string]*profileRequests),
+	}
+}
+
+// record adds a request for a browser with options at now
+func (pd *profileDemand) record(options config.ChromeConfigOptions, now time.Time) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pr, exists := pd.requests[options.Hash]
+	if !exists {
+		pr = &profileRequests{options: options}
+		pd.requests[options.Hash] = pr
+	}
+	pr.times = append(pr.times, now)
+}
+
+// hot returns the options requested at least threshold times within window before now. Older requests are forgotten
+func (pd *profileDemand) hot(now time.Time, window time.Duration, threshold int) []config.ChromeConfigOptions {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+
+	var hot []config.ChromeConfigOptions
+	for hash, pr := range pd.requests {
+		i := 0
+		for i < len(pr.times) && now.Sub(pr.times[i]) > window {
+			i++
+		}
+		pr.times = pr.times[i:]
+
+		if len(pr.times) == 0 {
+			delete(pd.requests, hash)
+			continue
+		}
+		if len(pr.times) >= threshold {
+			hot = append(hot, pr.options)
+		}
+	}
+	return hot
+}
@@ -0,0 +1,169 @@
+package chromepool
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Pin identifies the browser a sticky session key of a tenant is pinned to
+type Pin struct {
+	// Tenant is the name of the tenant, or the identity of a caller without one
+	Tenant string
+	Key    string
+}
+
+// pinnedBrowser is kept out of the idle browsers until its pin is released, so that sessions with the pin get
+// the same browser and its cookies and storage
+type pinnedBrowser struct {
+	crm        *chrome.IChrome
+	inUse      bool
+	lastUsedAt time.Time
+}
+
+// ErrPinInUse is returned by GetPinnedChrome while another session uses the pinned browser
+var ErrPinInUse = errors.New("the pinned browser is used by another session")
+
+// ErrPinLimitReached is returned by GetPinnedChrome when the tenant has the maximum number of pinned browsers
+var ErrPinLimitReached = errors.New("tenant has reached its maximum number of pinned browsers")
+
+// ErrPinProfileMismatch is returned by GetPinnedChrome when the pinned browser was started with other options
+var ErrPinProfileMismatch = errors.New("the sticky session is pinned to a browser with another profile")
+
+// ErrPinNotFound is returned by ReleasePin when no browser is pinned
+var ErrPinNotFound = errors.New("no browser is pinned to the sticky session")
+
+// GetPinnedChrome returns the browser pinned to pin, or pins an available browser if there is none. A tenant may
+// have maxPins pinned browsers, 0 is unlimited. The browser must be handed back with ReturnPinnedChrome
+func (cp *ChromePool) GetPinnedChrome(pin Pin, maxPins int, sessionId uuid.UUID, options config.ChromeConfigOptions) (*chrome.IChrome, error) {
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+
+	if p, exists := cp.pins[pin]; exists {
+		if p.inUse {
+			return nil, ErrPinInUse
+		}
+		if (*p.crm).Options().Hash != options.Hash {
+			return nil, ErrPinProfileMismatch
+		}
+		p.inUse = true
+		(*p.crm).SetSessionId(sessionId)
+
+		log := logger.Get()
+		log.Info().
+			Str("browserId", (*p.crm).BrowserID().String()).
+			Str("sessionId", sessionId.String()).
+			Msg("using pinned chrome instance for session")
+		return p.crm, nil
+	}
+
+	if maxPins > 0 && cp.countPinsLocked(pin.Tenant) >= maxPins {
+		return nil, ErrPinLimitReached
+	}
+
+	crm, err := cp.getAvailableChromeWLocked(sessionId, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// pinned browsers are released by their idle ttl instead of the browser's idle timeouts
+	(*crm).SetNotIdle()
+	(*crm).PauseTicker()
+	if cp.pins == nil {
+		cp.pins = make(map[Pin]*pinnedBrowser)
+	}
+	cp.pins[pin] = &pinnedBrowser{crm: crm, inUse: true}
+	return crm, nil
+}
+
+// ReturnPinnedChrome hands back the browser pinned to pin once the session using it has ended.
+// Its idle ttl starts over
+func (cp *ChromePool) ReturnPinnedChrome(pin Pin) {
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+
+	p, exists := cp.pins[pin]
+	if !exists {
+		return
+	}
+	p.inUse = false
+	p.lastUsedAt = time.Now()
+	(*p.crm).SetSessionId(uuid.Nil)
+}
+
+// ReleasePin unpins the browser pinned to pin and stops it, ending the session using it if there is one.
+// Browsers are not reused after being pinned since they keep the state of the sticky session
+func (cp *ChromePool) ReleasePin(pin Pin) error {
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+
+	p, exists := cp.pins[pin]
+	if !exists {
+		return ErrPinNotFound
+	}
+	cp.removeInstanceByBrowserIdWLocked((*p.crm).BrowserID())
+	return nil
+}
+
+// ReleaseIdlePins releases every pin that was not used for STICKY_SESSION_IDLE_TTL_IN_SECS.
+// Returns the number of pins released
+func (cp *ChromePool) ReleaseIdlePins() int {
+	ttl := config.Get().GetChromePoolConfig().StickySessionIdleTtlInSecs
+	if ttl <= 0 {
+		return 0
+	}
+
+	cp.instancePoolMutex.Lock()
+	defer cp.instancePoolMutex.Unlock()
+
+	log := logger.Get()
+	now := time.Now()
+	released := 0
+	for pin, p := range cp.pins {
+		if p.inUse || now.Sub(p.lastUsedAt) < ttl {
+			continue
+		}
+		log.Info().
+			Str("browserId", (*p.crm).BrowserID().String()).
+			Str("session", pin.Key).
+			Msg("releasing pinned chrome instance after idle ttl")
+		cp.removeInstanceByBrowserIdWLocked((*p.crm).BrowserID())
+		released++
+	}
+	return released
+}
+
+// HasAvailablePin returns true if a pinned browser is not used by a session
+func (cp *ChromePool) HasAvailablePin() bool {
+	cp.instancePoolMutex.RLock()
+	defer cp.instancePoolMutex.RUnlock()
+	for _, p := range cp.pins {
+		if !p.inUse {
+			return true
+		}
+	}
+	return false
+}
+
+// countPinsLocked returns the number of browsers pinned by tenant
+func (cp *ChromePool) countPinsLocked(tenant string) int {
+	n := 0
+	for pin := range cp.pins {
+		if pin.Tenant == tenant {
+			n++
+		}
+	}
+	return n
+}
+
+// unpinBrowserWLocked removes the pin of a browser that is being stopped
+func (cp *ChromePool) unpinBrowserWLocked(browserID uuid.UUID) {
+	for pin, p := range cp.pins {
+		if (*p.crm).BrowserID() == browserID {
+			delete(cp.pins, pin)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package chromepool
 
 import (
+	"chromium-websocket-proxy/autoscaler"
 	"chromium-websocket-proxy/chrome"
 	"chromium-websocket-proxy/config"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/phayes/freeport"
+	"sort"
+	"time"
 )
 
 /**
@@ -25,6 +28,75 @@ func (cp *ChromePool) getIdleChromeLocked(options config.ChromeConfigOptions) *c
 	return nil
 }
 
+// getIdleInstanceIndexToRetireLocked returns the index of an idle instance, preferring instances without
+// defaultOptions, or -1 if no instance is idle. Instances needed to keep the warm targets are skipped
+func (cp *ChromePool) getIdleInstanceIndexToRetireLocked(
+	defaultOptions config.ChromeConfigOptions,
+	targets map[string]warmTarget,
+) int {
+	idx := -1
+	for i := range cp.instancePool {
+		if !(*cp.instancePool[i]).IsIdle() {
+			continue
+		}
+		hash := (*cp.instancePool[i]).Options().Hash
+		if cp.countIdleInstancesLocked(hash) <= targets[hash].count {
+			continue
+		}
+		if (*cp.instancePool[i]).Options().Hash != defaultOptions.Hash {
+			return i
+		}
+		if idx < 0 {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// getWarmOptionsToStartLocked returns the options of every browser to start to reach the warm targets, without
+// going past MAX_BROWSER_INSTANCES. Options are ordered by hash
+func (cp *ChromePool) getWarmOptionsToStartLocked(targets map[string]warmTarget) []config.ChromeConfigOptions {
+	hashes := make([]string, 0, len(targets))
+	for hash := range targets {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	room := config.Get().GetChromePoolConfig().MaxBrowserInstances - cp.getInstancePoolLenLocked() - cp.startingInstances
+	var toStart []config.ChromeConfigOptions
+	for _, hash := range hashes {
+		for i := cp.countIdleInstancesLocked(hash); i < targets[hash].count; i++ {
+			if len(toStart) >= room {
+				return toStart
+			}
+			toStart = append(toStart, targets[hash].options)
+		}
+	}
+	return toStart
+}
+
+// countBusyInstancesLocked returns the number of instances used by a session or pinned
+func (cp *ChromePool) countBusyInstancesLocked() int {
+	n := 0
+	for i := range cp.instancePool {
+		if !(*cp.instancePool[i]).IsIdle() {
+			n++
+		}
+	}
+	return n
+}
+
+// countIdleInstancesLocked returns the number of idle instances started with the options hash
+func (cp *ChromePool) countIdleInstancesLocked(hash string) int {
+	n := 0
+	for i := range cp.instancePool {
+		if (*cp.instancePool[i]).IsIdle() && (*cp.instancePool[i]).Options().Hash == hash {
+			n++
+		}
+	}
+	return n
+}
+
 func (cp *ChromePool) getInstanceByBrowserIDLocked(browserID uuid.UUID) (*chrome.IChrome, int) {
 	l := cp.getInstancePoolLenLocked()
 	for i := 0; i < l; i++ {
@@ -35,6 +107,18 @@ func (cp *ChromePool) getInstanceByBrowserIDLocked(browserID uuid.UUID) (*chrome
 	return nil, l
 }
 
+func (cp *ChromePool) getInstanceBySessionIdLocked(sessionId uuid.UUID) *chrome.IChrome {
+	if sessionId == uuid.Nil {
+		return nil
+	}
+	for i := range cp.instancePool {
+		if sessionId == (*cp.instancePool[i]).SessionId() {
+			return cp.instancePool[i]
+		}
+	}
+	return nil
+}
+
 func (cp *ChromePool) hasIdleChromeInstanceLocked() bool {
 	for i := range cp.instancePool {
 		if (*cp.instancePool[i]).IsIdle() {
@@ -70,3 +154,38 @@ func (cp *ChromePool) getAvailablePortLocked() (int, error) {
 	}
 	return freePort()
 }
+
+// warmTargetsLocked returns how many idle browsers to keep warm for each options hash. The default options are
+// warmed with MIN_BROWSER_INSTANCES instead, and with enough idle browsers to reach the instances SCALER_SCHEDULE
+// asks for
+func (cp *ChromePool) warmTargetsLocked(now time.Time) map[string]warmTarget {
+	conf := config.Get()
+	poolConf := conf.GetChromePoolConfig()
+	defaultHash := conf.GetChromeConfig().DefaultOptions.Hash
+
+	targets := make(map[string]warmTarget)
+	if scheduled := autoscaler.ScheduledInstances(cp.schedule, now) - cp.countBusyInstancesLocked(); scheduled > 0 {
+		targets[defaultHash] = warmTarget{options: conf.GetChromeConfig().DefaultOptions, count: scheduled}
+	}
+
+	for profile, n := range poolConf.ProfileMinBrowserInstances {
+		options, err := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{Profile: profile})
+		if err != nil || options.Hash == defaultHash || n <= 0 {
+			continue
+		}
+		targets[options.Hash] = warmTarget{options: options, count: n}
+	}
+
+	if poolConf.ProfileWarmBrowserInstances <= 0 {
+		return targets
+	}
+	for _, options := range cp.demand.hot(now, poolConf.ProfileDemandWindowInSecs, poolConf.ProfileDemandThreshold) {
+		if options.Hash == defaultHash {
+			continue
+		}
+		if t := targets[options.Hash]; t.count < poolConf.ProfileWarmBrowserInstances {
+			targets[options.Hash] = warmTarget{options: options, count: poolConf.ProfileWarmBrowserInstances}
+		}
+	}
+	return targets
+}
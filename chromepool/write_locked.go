@@ -19,8 +19,18 @@ var chromeCreator = chrome.NewChrome
  */
 
 func (cp *ChromePool) createChromeWLocked(sessionId uuid.UUID, options config.ChromeConfigOptions) (*chrome.IChrome, error) {
+	crm, err := cp.reserveChromeWLocked(sessionId, options)
+	if err != nil {
+		return nil, err
+	}
+	return cp.addStartedChromeWLocked(crm, crm.Start())
+}
+
+// reserveChromeWLocked takes a debug port and a slot in the pool for a browser, so that it can be started without
+// holding the lock. The browser must be handed to addStartedChromeWLocked once it has started
+func (cp *ChromePool) reserveChromeWLocked(sessionId uuid.UUID, options config.ChromeConfigOptions) (chrome.IChrome, error) {
 	conf := config.Get()
-	l := cp.getInstancePoolLenLocked()
+	l := cp.getInstancePoolLenLocked() + cp.startingInstances
 
 	if l >= conf.GetChromePoolConfig().MaxBrowserInstances {
 		return nil, errors.New(fmt.Sprintf("%s have been created already", config.MaxBrowserInstances))
@@ -31,26 +41,66 @@ func (cp *ChromePool) createChromeWLocked(sessionId uuid.UUID, options config.Ch
 		return nil, err
 	}
 
-	crm := chromeCreator(chrome.CreateChromePayload{
+	cp.startingInstances++
+	return chromeCreator(chrome.CreateChromePayload{
 		Port:          port,
 		SessionId:     sessionId,
 		EventReceiver: cp.chromeEventReceiver,
 		Options:       options,
-	})
+	}), nil
+}
 
-	if err = crm.Start(); err != nil {
-		return nil, err
+// addStartedChromeWLocked frees the slot reserved for crm, and adds crm to the pool if startErr is nil
+func (cp *ChromePool) addStartedChromeWLocked(crm chrome.IChrome, startErr error) (*chrome.IChrome, error) {
+	cp.startingInstances--
+	if startErr != nil {
+		cp.consecutiveLaunchFailures++
+		return nil, startErr
 	}
+	cp.consecutiveLaunchFailures = 0
 	cp.instancePool = append(cp.instancePool, &crm)
 	metrics.Get().Remote.SetGauge(metrics.ChromeInstances, float32(len(cp.instancePool)))
 	return &crm, nil
 }
 
+// getAvailableChromeWLocked returns an idle browser with options for the session, or starts one if options are not
+// the default options
+func (cp *ChromePool) getAvailableChromeWLocked(sessionId uuid.UUID, options config.ChromeConfigOptions) (*chrome.IChrome, error) {
+	ipLen := cp.getInstancePoolLenLocked()
+
+	// create instance if none exist
+	if ipLen == 0 {
+		return cp.createChromeWLocked(sessionId, options)
+	}
+
+	// get existing idle browser with profile
+	crm := cp.getIdleChromeLocked(options)
+	if crm != nil {
+		log := logger.Get()
+		log.Info().
+			Str("browserId", (*crm).BrowserID().String()).
+			Str("sessionId", sessionId.String()).
+			Msg("using idle chrome instance for session")
+		(*crm).SetSessionId(sessionId)
+		(*crm).SetNotIdle()
+		(*crm).StartTicker()
+		return crm, nil
+	}
+
+	// This is not default options. Attempt to create
+	if options.Hash != config.Get().GetChromeConfig().DefaultOptions.Hash {
+		return cp.createChromeWLocked(sessionId, options)
+	}
+
+	return nil, errors.New("no browser available for use")
+}
+
 func (cp *ChromePool) removeInstanceAtIndexWLocked(i int) {
 	log := logger.Get()
 	log.Info().Str("browserId", (*cp.instancePool[i]).BrowserID().String()).Msg(fmt.Sprintf("destroying chrome browser instance at port %#v\n", (*cp.instancePool[i]).Port()))
 	cp.availableDebuggingPorts = append(cp.availableDebuggingPorts, (*cp.instancePool[i]).Port())
 	(*cp.instancePool[i]).Stop()
+	cp.unpinBrowserWLocked((*cp.instancePool[i]).BrowserID())
 	cp.instancePool = append(cp.instancePool[:i], cp.instancePool[i+1:]...)
 	metrics.Get().Remote.SetGauge(metrics.ChromeInstances, float32(len(cp.instancePool)))
 }
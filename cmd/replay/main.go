@@ -0,0 +1,89 @@
+package main
+
+import (
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/chromeprofile"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/recorder"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+	"os"
+	"time"
+)
+
+// replay plays the client messages of a session recording against a fresh pooled chrome and reports
+// every response that diverges from the recording
+func main() {
+	file := flag.String("file", "", "path to the session recording (.jsonl)")
+	profile := flag.String("profile", "", "chrome profile to replay the session with")
+	timeout := flag.Duration("timeout", 30*time.Second, "time to wait for each response")
+	flag.Parse()
+
+	log := logger.Get()
+	if len(*file) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := recorder.ReadRecording(*file)
+	if err != nil {
+		log.Fatal().Err(err).Msg(fmt.Sprintf("unable to read recording %s", *file))
+	}
+
+	c := config.Get()
+	if err = c.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("service configuration failed validation")
+	}
+	if err = metrics.Init(); err != nil {
+		log.Fatal().Err(err).Msg("unable to start metrics client")
+	}
+	chromeprofile.LoadProfiles()
+
+	options, err := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{Profile: *profile})
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create options for chrome startup")
+	}
+
+	crmPool := chromepool.Get()
+	crm, err := crmPool.GetAvailableChrome(uuid.New(), options)
+	if err != nil {
+		crmPool.ShutDownPool()
+		log.Fatal().Err(err).Msg("unable to get chrome")
+	}
+
+	divergences, err := replay((*crm).DebugUrl(), entries, *timeout)
+	crmPool.ShutDownPool()
+	if err != nil {
+		log.Fatal().Err(err).Msg("replay failed")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, d := range divergences {
+		_ = encoder.Encode(d)
+	}
+	log.Info().Msg(fmt.Sprintf("replayed %d messages with %d diverging responses", len(entries), len(divergences)))
+
+	if len(divergences) > 0 {
+		os.Exit(1)
+	}
+}
+
+func replay(debugUrl string, entries []recorder.Entry, timeout time.Duration) ([]recorder.Divergence, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, debugUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(-1)
+	defer conn.CloseNow()
+
+	return recorder.NewReplayer(conn, timeout).Replay(ctx, entries)
+}
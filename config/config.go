@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/mitchellh/hashstructure/v2"
@@ -25,6 +26,8 @@ const (
 	EnableAutoAssignDebugPortDefault              = true
 	EnableBrowserReuse                            = "ENABLE_BROWSER_REUSE"
 	EnableBrowserReuseDefault                     = false
+	EnableSessionBrowserContexts                  = "ENABLE_SESSION_BROWSER_CONTEXTS"
+	EnableSessionBrowserContextsDefault           = true
 	ChromeDebugPorts                              = "CHROME_DEBUG_PORTS"
 	ChromeHeadless                                = "CHROME_HEADLESS"
 	ChromeHeadlessDefault                         = true
@@ -51,8 +54,121 @@ const (
 	StatsDSink                                    = "STATSD_SINK"
 	DataDogHostName                               = "DATADOG_HOST"
 	DataDogAddress                                = "DATADOG_ADDRESS"
+	PrometheusEnabled                             = "PROMETHEUS_ENABLED"
+	PrometheusEnabledDefault                      = false
+	PrometheusSessionDurationBuckets              = "PROMETHEUS_SESSION_DURATION_BUCKETS"
+	CdpPolicy                                     = "CDP_POLICY"
+	CdpPolicyDefault                              = CdpPolicyPermissive
+	CdpPolicyDenyMethods                          = "CDP_POLICY_DENY_METHODS"
+	SessionRecordingEnabled                       = "SESSION_RECORDING_ENABLED"
+	SessionRecordingEnabledDefault                = false
+	SessionRecordingDir                           = "SESSION_RECORDING_DIR"
+	SessionRecordingDirDefault                    = "./recordings"
+	AdminApiEnabled                               = "ADMIN_API_ENABLED"
+	AdminApiEnabledDefault                        = false
+	AdminAccessToken                              = "ADMIN_ACCESS_TOKEN"
+	AdminAccessTokenDefault                       = ""
+	ServerAccessTokenTiers                        = "SERVER_ACCESS_TOKEN_TIERS"
+	MaxSessionPriority                            = "MAX_SESSION_PRIORITY"
+	MaxSessionPriorityDefault                     = 10
+	DefaultMaxSessionPriority                     = "DEFAULT_MAX_SESSION_PRIORITY" // defaults to MAX_SESSION_PRIORITY
+	PriorityAgingPerSec                           = "PRIORITY_AGING_PER_SEC"
+	PriorityAgingPerSecDefault                    = 0.1
+	MaxQueueLength                                = "MAX_QUEUE_LENGTH"
+	MaxQueueLengthDefault                         = 0
+	MaxQueueWaitInSecs                            = "MAX_QUEUE_WAIT_IN_SECS"
+	MaxQueueWaitInSecsDefault                     = 0
+	MaxSessionDurationInSecs                      = "MAX_SESSION_DURATION_IN_SECS"
+	MaxSessionDurationInSecsDefault               = 0
+	MaxSessionDurationCapInSecs                   = "MAX_SESSION_DURATION_CAP_IN_SECS"
+	MaxSessionDurationCapInSecsDefault            = 0
+	ClientIdleTimeoutInSecs                       = "CLIENT_IDLE_TIMEOUT_IN_SECS"
+	ClientIdleTimeoutInSecsDefault                = 0
+	ProxyReadIdleTimeoutInSecs                    = "PROXY_READ_IDLE_TIMEOUT_IN_SECS"
+	ProxyReadIdleTimeoutInSecsDefault             = 0
+	ProxyWriteTimeoutInSecs                       = "PROXY_WRITE_TIMEOUT_IN_SECS"
+	ProxyWriteTimeoutInSecsDefault                = 10
+	ProxyLimiterWaitTimeoutInSecs                 = "PROXY_LIMITER_WAIT_TIMEOUT_IN_SECS"
+	ProxyLimiterWaitTimeoutInSecsDefault          = 30
+	ProxyReadLimitInBytes                         = "PROXY_READ_LIMIT_IN_BYTES"
+	ProxyReadLimitInBytesDefault                  = 256 * 1024 * 1024
+	SessionResumeGracePeriodInSecs                = "SESSION_RESUME_GRACE_PERIOD_IN_SECS"
+	SessionResumeGracePeriodInSecsDefault         = 0
+	EnableScaleDown                               = "ENABLE_SCALE_DOWN"
+	EnableScaleDownDefault                        = false
+	ThroughputScaleDownThreshold                  = "THROUGHPUT_SCALE_DOWN_THRESHOLD"
+	ThroughputScaleDownThresholdDefault           = 0.1
+	ScaleDownWindowInSecs                         = "SCALE_DOWN_WINDOW_IN_SECS"
+	ScaleDownWindowInSecsDefault                  = 60
+	ScaleDownCooldownInSecs                       = "SCALE_DOWN_COOLDOWN_IN_SECS"
+	ScaleDownCooldownInSecsDefault                = 30
+	ScalerStrategy                                = "SCALER_STRATEGY"
+	ScalerStrategyDefault                         = ScalerStrategyThroughput
+	ScalerTargetQueueDepth                        = "SCALER_TARGET_QUEUE_DEPTH"
+	ScalerTargetQueueDepthDefault                 = 1.0
+	ScalerTargetUtilization                       = "SCALER_TARGET_UTILIZATION"
+	ScalerTargetUtilizationDefault                = 0.8
+	ScalerSchedule                                = "SCALER_SCHEDULE"
+	DefaultProxyTimeInSecs                        = "DEFAULT_PROXY_TIME_IN_SECS"
+	DefaultProxyTimeInSecsDefault                 = 25.0
+	ProfileMinBrowserInstances                    = "PROFILE_MIN_BROWSER_INSTANCES"
+	ProfileWarmBrowserInstances                   = "PROFILE_WARM_BROWSER_INSTANCES"
+	ProfileWarmBrowserInstancesDefault            = 1
+	ProfileDemandThreshold                        = "PROFILE_DEMAND_THRESHOLD"
+	ProfileDemandThresholdDefault                 = 3
+	ProfileDemandWindowInSecs                     = "PROFILE_DEMAND_WINDOW_IN_SECS"
+	ProfileDemandWindowInSecsDefault              = 300
+	StickySessionIdleTtlInSecs                    = "STICKY_SESSION_IDLE_TTL_IN_SECS"
+	StickySessionIdleTtlInSecsDefault             = 300
+	MaxPinnedBrowsersPerTenant                    = "MAX_PINNED_BROWSERS_PER_TENANT"
+	MaxPinnedBrowsersPerTenantDefault             = 0
+	DrainTimeoutInSecs                            = "DRAIN_TIMEOUT_IN_SECS"
+	DrainTimeoutInSecsDefault                     = 30
+	ReadinessMaxQueueLength                       = "READINESS_MAX_QUEUE_LENGTH"
+	ReadinessMaxQueueLengthDefault                = 0
+	ReadinessMaxLaunchFailures                    = "READINESS_MAX_LAUNCH_FAILURES"
+	ReadinessMaxLaunchFailuresDefault             = 3
+	TenantsFile                                   = "TENANTS_FILE"
+	ClientMessagesPerSec                          = "CLIENT_MESSAGES_PER_SEC"
+	ClientMessagesPerSecDefault                   = 100
+	ClientMessageBurst                            = "CLIENT_MESSAGE_BURST"
+	ClientMessageBurstDefault                     = 10
+	ClientBytesPerSec                             = "CLIENT_BYTES_PER_SEC"
+	ClientBytesPerSecDefault                      = 0
+	ClientByteBurst                               = "CLIENT_BYTE_BURST"
+	ClientByteBurstDefault                        = 0
+	ChromeMessagesPerSec                          = "CHROME_MESSAGES_PER_SEC"
+	ChromeMessagesPerSecDefault                   = 100
+	ChromeMessageBurst                            = "CHROME_MESSAGE_BURST"
+	ChromeMessageBurstDefault                     = 10
+	ChromeBytesPerSec                             = "CHROME_BYTES_PER_SEC"
+	ChromeBytesPerSecDefault                      = 0
+	ChromeByteBurst                               = "CHROME_BYTE_BURST"
+	ChromeByteBurstDefault                        = 0
+	ProfileRateLimits                             = "PROFILE_RATE_LIMITS"
+	JwtHs256Secret                                = "JWT_HS256_SECRET"
+	JwtJwksFile                                   = "JWT_JWKS_FILE"
+	JwtIssuer                                     = "JWT_ISSUER"
+	JwtAudience                                   = "JWT_AUDIENCE"
 )
 
+// Autoscaling strategies that can be selected with ScalerStrategy
+const (
+	ScalerStrategyThroughput  = "throughput"
+	ScalerStrategyQueueDepth  = "queue-depth"
+	ScalerStrategyUtilization = "utilization"
+	ScalerStrategyScheduled   = "scheduled"
+)
+
+// Built-in CDP policies that can be selected with CdpPolicy or the policy connect param
+const (
+	CdpPolicyPermissive    = "permissive"
+	CdpPolicySharedBrowser = "shared-browser"
+)
+
+// PrometheusSessionDurationBucketsDefault are the histogram buckets, in seconds, used for proxy session durations
+var PrometheusSessionDurationBucketsDefault = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
 type IConfig interface {
 	GetChromeConfig() ChromeConfig
 	GetChromePoolConfig() ChromePoolConfig
@@ -60,6 +176,9 @@ type IConfig interface {
 	GetServerConfig() ServerConfig
 	GetProxyQueueConfig() ProxyQueueConfig
 	GetMetricsConfig() MetricsConfig
+	GetCdpPolicyConfig() CdpPolicyConfig
+	GetRecorderConfig() RecorderConfig
+	GetRateLimitConfig() RateLimitConfig
 	Validate() error
 }
 
@@ -70,17 +189,97 @@ type Config struct {
 	serverConfig     ServerConfig
 	proxyQueueConfig ProxyQueueConfig
 	metricsConfig    MetricsConfig
+	cdpPolicyConfig  CdpPolicyConfig
+	recorderConfig   RecorderConfig
+	rateLimitConfig  RateLimitConfig
 }
 
 type MetricsConfig struct {
-	StatsiteSink    string
-	StatsDSink      string
-	DataDogHostName string
-	DataDogAddress  string
+	StatsiteSink                     string
+	StatsDSink                       string
+	DataDogHostName                  string
+	DataDogAddress                   string
+	PrometheusEnabled                bool
+	PrometheusSessionDurationBuckets []float64
+}
+
+type CdpPolicyConfig struct {
+	Policy      string
+	DenyMethods []string
+}
+
+type RecorderConfig struct {
+	Enabled bool
+	Dir     string
 }
 
 type ProxyQueueConfig struct {
-	ThroughputScaleUpThreshold float64
+	ThroughputScaleUpThreshold   float64
+	MaxSessionPriority           float64
+	DefaultMaxSessionPriority    float64
+	PriorityAgingPerSec          float64
+	MaxQueueLength               int
+	MaxQueueWaitInSecs           time.Duration
+	MaxSessionDurationInSecs     time.Duration
+	MaxSessionDurationCapInSecs  time.Duration
+	ClientIdleTimeoutInSecs      time.Duration
+	ReadIdleTimeoutInSecs        time.Duration
+	WriteTimeoutInSecs           time.Duration
+	LimiterWaitTimeoutInSecs     time.Duration
+	ReadLimitInBytes             int
+	ResumeGracePeriodInSecs      time.Duration
+	EnableScaleDown              bool
+	ThroughputScaleDownThreshold float64
+	ScaleDownWindowInSecs        time.Duration
+	ScaleDownCooldownInSecs      time.Duration
+	ScalerStrategy               string
+	ScalerTargetQueueDepth       float64
+	ScalerTargetUtilization      float64
+	ScalerSchedule               string
+	DefaultProxyTimeInSecs       float64
+}
+
+// RateLimit budgets the messages and bytes read from one side of a session. A rate of 0 is unlimited and a burst of
+// 0 defaults to one second of the rate
+type RateLimit struct {
+	MessagesPerSec float64 `json:"messagesPerSec"`
+	MessageBurst   int     `json:"messageBurst"`
+	BytesPerSec    float64 `json:"bytesPerSec"`
+	ByteBurst      int     `json:"byteBurst"`
+}
+
+// RateLimits budget both directions of a session. Client limits messages sent by the client to chrome, Chrome limits
+// messages sent by chrome to the client
+type RateLimits struct {
+	Client *RateLimit `json:"client,omitempty"`
+	Chrome *RateLimit `json:"chrome,omitempty"`
+}
+
+// Override returns rl with every direction set in o replaced
+func (rl RateLimits) Override(o *RateLimits) RateLimits {
+	if o == nil {
+		return rl
+	}
+	if o.Client != nil {
+		rl.Client = o.Client
+	}
+	if o.Chrome != nil {
+		rl.Chrome = o.Chrome
+	}
+	return rl
+}
+
+type RateLimitConfig struct {
+	Default              RateLimits
+	Profiles             map[string]RateLimits
+	profileRateLimitsErr error
+}
+
+// ScalerScheduleWindow keeps Instances browsers warm between Start and End, as time of day in server local time
+type ScalerScheduleWindow struct {
+	Start     time.Duration
+	End       time.Duration
+	Instances int
 }
 
 type ChromeConfigOptionsPayload struct {
@@ -94,6 +293,7 @@ type ChromeConfigOptions struct {
 
 type ChromeConfig struct {
 	EnableBrowserReuse               bool
+	EnableSessionBrowserContexts     bool
 	BrowserAutoSetIdleTimeoutInSecs  time.Duration
 	Headless                         bool
 	EnableTaggedBrowserAutoShutdown  bool
@@ -110,6 +310,12 @@ type ChromePoolConfig struct {
 	DebugPorts                  []int
 	MaxCreateBrowserRetries     int
 	CreateBrowserRetrySleepInMs int
+	ProfileMinBrowserInstances  map[string]int
+	ProfileWarmBrowserInstances int
+	ProfileDemandThreshold      int
+	ProfileDemandWindowInSecs   time.Duration
+	StickySessionIdleTtlInSecs  time.Duration
+	MaxPinnedBrowsersPerTenant  int
 }
 
 type LoggerConfig struct {
@@ -121,6 +327,22 @@ type ServerConfig struct {
 	Port                         int
 	AccessToken                  string
 	AccessTokenValidationEnabled bool
+	AdminApiEnabled              bool
+	AdminAccessToken             string
+	AccessTokenTiers             map[string]float64
+	DrainTimeoutInSecs           time.Duration
+	ReadinessMaxQueueLength      int
+	ReadinessMaxLaunchFailures   int
+	TenantsFile                  string
+	JwtHs256Secret               string
+	JwtJwksFile                  string
+	JwtIssuer                    string
+	JwtAudience                  string
+}
+
+// IsJwtEnabled returns true if signed JWTs are accepted as access tokens
+func (s ServerConfig) IsJwtEnabled() bool {
+	return len(s.JwtHs256Secret) > 0 || len(s.JwtJwksFile) > 0
 }
 
 // Once - ONLY REFERENCE IN TESTS
@@ -130,6 +352,7 @@ var c Config
 
 func Get() IConfig {
 	Once.Do(func() {
+		maxSessionPriority := getFloat64FromEnv(MaxSessionPriority, MaxSessionPriorityDefault)
 		c = Config{
 			chromePoolConfig: ChromePoolConfig{
 				MaxBrowserInstances:         getIntFromEnv(MaxBrowserInstances, MaxBrowserInstancesDefault),
@@ -138,12 +361,19 @@ func Get() IConfig {
 				MaxCreateBrowserRetries:     MaxCreateBrowserRetries,
 				CreateBrowserRetrySleepInMs: CreateBrowserRetrySleepInMs,
 				DebugPorts:                  getIntArrayFromEnv(ChromeDebugPorts, make([]int, 0)),
+				ProfileMinBrowserInstances:  getIntMapFromEnv(ProfileMinBrowserInstances, make(map[string]int)),
+				ProfileWarmBrowserInstances: getIntFromEnv(ProfileWarmBrowserInstances, ProfileWarmBrowserInstancesDefault),
+				ProfileDemandThreshold:      getIntFromEnv(ProfileDemandThreshold, ProfileDemandThresholdDefault),
+				ProfileDemandWindowInSecs:   getSecTimeDurationFromEnv(ProfileDemandWindowInSecs, ProfileDemandWindowInSecsDefault),
+				StickySessionIdleTtlInSecs:  getSecTimeDurationFromEnv(StickySessionIdleTtlInSecs, StickySessionIdleTtlInSecsDefault),
+				MaxPinnedBrowsersPerTenant:  getIntFromEnv(MaxPinnedBrowsersPerTenant, MaxPinnedBrowsersPerTenantDefault),
 			},
 			chromeConfig: ChromeConfig{
 				Headless:                         getBoolFromEnv(ChromeHeadless, ChromeHeadlessDefault),
 				EnableBrowserAutoShutdown:        getBoolFromEnv(ChromeEnableBrowserAutoShutdown, ChromeEnableBrowserAutoShutdownDefault),
 				EnableCustomChromeProfiles:       getBoolFromEnv(ChromeEnableCustomProfiles, ChromeEnableCustomProfilesDefault),
 				EnableBrowserReuse:               getBoolFromEnv(EnableBrowserReuse, EnableBrowserReuseDefault),
+				EnableSessionBrowserContexts:     getBoolFromEnv(EnableSessionBrowserContexts, EnableSessionBrowserContextsDefault),
 				BrowserAutoShutdownTimeoutInSecs: getSecTimeDurationFromEnv(ChromeBrowserAutoShutdownTimeoutInSecs, ChromeBrowserAutoShutdownTimeoutInSecsDefault),
 				BrowserAutoSetIdleTimeoutInSecs:  getSecTimeDurationFromEnv(ChromeBrowserAutoIdleTimeoutInSecs, ChromeBrowserAutoIdleTimeoutInSecsDefault),
 			},
@@ -152,20 +382,83 @@ func Get() IConfig {
 				LogFilePath: getStringFromEnv(LogFilePath, ""),
 			},
 			metricsConfig: MetricsConfig{
-				StatsiteSink:    getStringFromEnv(StatsiteSink, ""),
-				StatsDSink:      getStringFromEnv(StatsDSink, ""),
-				DataDogHostName: getStringFromEnv(DataDogHostName, ""),
-				DataDogAddress:  getStringFromEnv(DataDogAddress, ""),
+				StatsiteSink:      getStringFromEnv(StatsiteSink, ""),
+				StatsDSink:        getStringFromEnv(StatsDSink, ""),
+				DataDogHostName:   getStringFromEnv(DataDogHostName, ""),
+				DataDogAddress:    getStringFromEnv(DataDogAddress, ""),
+				PrometheusEnabled: getBoolFromEnv(PrometheusEnabled, PrometheusEnabledDefault),
+				PrometheusSessionDurationBuckets: getFloat64ArrayFromEnv(
+					PrometheusSessionDurationBuckets,
+					PrometheusSessionDurationBucketsDefault,
+				),
 			},
 			serverConfig: ServerConfig{
 				Port:                         getIntFromEnv(ServerPort, ServerPortDefault),
 				AccessToken:                  getStringFromEnv(ServerAccessToken, ServerAccessTokenDefault),
 				AccessTokenValidationEnabled: getBoolFromEnv(ServerAccessTokenValidationEnabled, ServerAccessTokenValidationEnabledDefault),
+				AdminApiEnabled:              getBoolFromEnv(AdminApiEnabled, AdminApiEnabledDefault),
+				AdminAccessToken:             getStringFromEnv(AdminAccessToken, AdminAccessTokenDefault),
+				AccessTokenTiers:             getFloat64MapFromEnv(ServerAccessTokenTiers, make(map[string]float64)),
+				DrainTimeoutInSecs:           getSecTimeDurationFromEnv(DrainTimeoutInSecs, DrainTimeoutInSecsDefault),
+				ReadinessMaxQueueLength:      getIntFromEnv(ReadinessMaxQueueLength, ReadinessMaxQueueLengthDefault),
+				ReadinessMaxLaunchFailures:   getIntFromEnv(ReadinessMaxLaunchFailures, ReadinessMaxLaunchFailuresDefault),
+				TenantsFile:                  getStringFromEnv(TenantsFile, ""),
+				JwtHs256Secret:               getStringFromEnv(JwtHs256Secret, ""),
+				JwtJwksFile:                  getStringFromEnv(JwtJwksFile, ""),
+				JwtIssuer:                    getStringFromEnv(JwtIssuer, ""),
+				JwtAudience:                  getStringFromEnv(JwtAudience, ""),
 			},
 			proxyQueueConfig: ProxyQueueConfig{
-				ThroughputScaleUpThreshold: getFloat64FromEnv(ThroughputScaleUpThreshold, ThroughputScaleUpThresholdDefault),
+				ThroughputScaleUpThreshold:   getFloat64FromEnv(ThroughputScaleUpThreshold, ThroughputScaleUpThresholdDefault),
+				MaxSessionPriority:           maxSessionPriority,
+				DefaultMaxSessionPriority:    getFloat64FromEnv(DefaultMaxSessionPriority, maxSessionPriority),
+				PriorityAgingPerSec:          getFloat64FromEnv(PriorityAgingPerSec, PriorityAgingPerSecDefault),
+				MaxQueueLength:               getIntFromEnv(MaxQueueLength, MaxQueueLengthDefault),
+				MaxQueueWaitInSecs:           getSecTimeDurationFromEnv(MaxQueueWaitInSecs, MaxQueueWaitInSecsDefault),
+				MaxSessionDurationInSecs:     getSecTimeDurationFromEnv(MaxSessionDurationInSecs, MaxSessionDurationInSecsDefault),
+				MaxSessionDurationCapInSecs:  getSecTimeDurationFromEnv(MaxSessionDurationCapInSecs, MaxSessionDurationCapInSecsDefault),
+				ClientIdleTimeoutInSecs:      getSecTimeDurationFromEnv(ClientIdleTimeoutInSecs, ClientIdleTimeoutInSecsDefault),
+				ReadIdleTimeoutInSecs:        getSecTimeDurationFromEnv(ProxyReadIdleTimeoutInSecs, ProxyReadIdleTimeoutInSecsDefault),
+				WriteTimeoutInSecs:           getSecTimeDurationFromEnv(ProxyWriteTimeoutInSecs, ProxyWriteTimeoutInSecsDefault),
+				LimiterWaitTimeoutInSecs:     getSecTimeDurationFromEnv(ProxyLimiterWaitTimeoutInSecs, ProxyLimiterWaitTimeoutInSecsDefault),
+				ReadLimitInBytes:             getIntFromEnv(ProxyReadLimitInBytes, ProxyReadLimitInBytesDefault),
+				ResumeGracePeriodInSecs:      getSecTimeDurationFromEnv(SessionResumeGracePeriodInSecs, SessionResumeGracePeriodInSecsDefault),
+				EnableScaleDown:              getBoolFromEnv(EnableScaleDown, EnableScaleDownDefault),
+				ThroughputScaleDownThreshold: getFloat64FromEnv(ThroughputScaleDownThreshold, ThroughputScaleDownThresholdDefault),
+				ScaleDownWindowInSecs:        getSecTimeDurationFromEnv(ScaleDownWindowInSecs, ScaleDownWindowInSecsDefault),
+				ScaleDownCooldownInSecs:      getSecTimeDurationFromEnv(ScaleDownCooldownInSecs, ScaleDownCooldownInSecsDefault),
+				ScalerStrategy:               getStringFromEnv(ScalerStrategy, ScalerStrategyDefault),
+				ScalerTargetQueueDepth:       getFloat64FromEnv(ScalerTargetQueueDepth, ScalerTargetQueueDepthDefault),
+				ScalerTargetUtilization:      getFloat64FromEnv(ScalerTargetUtilization, ScalerTargetUtilizationDefault),
+				ScalerSchedule:               getStringFromEnv(ScalerSchedule, ""),
+				DefaultProxyTimeInSecs:       getFloat64FromEnv(DefaultProxyTimeInSecs, DefaultProxyTimeInSecsDefault),
+			},
+			cdpPolicyConfig: CdpPolicyConfig{
+				Policy:      getStringFromEnv(CdpPolicy, CdpPolicyDefault),
+				DenyMethods: getStringArrayFromEnv(CdpPolicyDenyMethods, make([]string, 0)),
+			},
+			recorderConfig: RecorderConfig{
+				Enabled: getBoolFromEnv(SessionRecordingEnabled, SessionRecordingEnabledDefault),
+				Dir:     getStringFromEnv(SessionRecordingDir, SessionRecordingDirDefault),
+			},
+			rateLimitConfig: RateLimitConfig{
+				Default: RateLimits{
+					Client: &RateLimit{
+						MessagesPerSec: getFloat64FromEnv(ClientMessagesPerSec, ClientMessagesPerSecDefault),
+						MessageBurst:   getIntFromEnv(ClientMessageBurst, ClientMessageBurstDefault),
+						BytesPerSec:    getFloat64FromEnv(ClientBytesPerSec, ClientBytesPerSecDefault),
+						ByteBurst:      getIntFromEnv(ClientByteBurst, ClientByteBurstDefault),
+					},
+					Chrome: &RateLimit{
+						MessagesPerSec: getFloat64FromEnv(ChromeMessagesPerSec, ChromeMessagesPerSecDefault),
+						MessageBurst:   getIntFromEnv(ChromeMessageBurst, ChromeMessageBurstDefault),
+						BytesPerSec:    getFloat64FromEnv(ChromeBytesPerSec, ChromeBytesPerSecDefault),
+						ByteBurst:      getIntFromEnv(ChromeByteBurst, ChromeByteBurstDefault),
+					},
+				},
 			},
 		}
+		c.rateLimitConfig.Profiles, c.rateLimitConfig.profileRateLimitsErr = ParseProfileRateLimits(getStringFromEnv(ProfileRateLimits, ""))
 
 		// TODO: handle error here
 		defaultOpts, _ := NewCreateOptions(&ChromeConfigOptionsPayload{
@@ -200,6 +493,18 @@ func (c *Config) GetMetricsConfig() MetricsConfig {
 	return c.metricsConfig
 }
 
+func (c *Config) GetCdpPolicyConfig() CdpPolicyConfig {
+	return c.cdpPolicyConfig
+}
+
+func (c *Config) GetRecorderConfig() RecorderConfig {
+	return c.recorderConfig
+}
+
+func (c *Config) GetRateLimitConfig() RateLimitConfig {
+	return c.rateLimitConfig
+}
+
 func (c *Config) Validate() error {
 	var errs []string
 
@@ -218,14 +523,170 @@ func (c *Config) Validate() error {
 		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 1 if set", MaxBrowserInstances))
 	}
 
-	if c.serverConfig.AccessTokenValidationEnabled && len(c.serverConfig.AccessToken) == 0 {
-		errs = append(errs, fmt.Sprintf("%s is required if %s is enabled", ServerAccessToken, ServerAccessTokenValidationEnabled))
+	profileMinBrowserInstances := 0
+	for profile, n := range c.chromePoolConfig.ProfileMinBrowserInstances {
+		if n < 0 {
+			errs = append(errs, fmt.Sprintf("%s for profile %s must be greater than or equal to 0", ProfileMinBrowserInstances, profile))
+		}
+		profileMinBrowserInstances += n
+	}
+	if c.chromePoolConfig.MinBrowserInstances+profileMinBrowserInstances > c.chromePoolConfig.MaxBrowserInstances {
+		errs = append(errs, fmt.Sprintf(
+			"%s plus every %s must be less than or equal to %s",
+			MinBrowserInstances,
+			ProfileMinBrowserInstances,
+			MaxBrowserInstances,
+		))
+	}
+
+	if c.chromePoolConfig.ProfileWarmBrowserInstances < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", ProfileWarmBrowserInstances))
+	}
+
+	if c.chromePoolConfig.ProfileDemandThreshold < 1 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 1", ProfileDemandThreshold))
+	}
+
+	if c.chromePoolConfig.ProfileDemandWindowInSecs <= 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than 0", ProfileDemandWindowInSecs))
+	}
+
+	if c.chromePoolConfig.StickySessionIdleTtlInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", StickySessionIdleTtlInSecs))
+	}
+
+	if c.chromePoolConfig.MaxPinnedBrowsersPerTenant < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", MaxPinnedBrowsersPerTenant))
+	}
+
+	if c.serverConfig.AccessTokenValidationEnabled &&
+		len(c.serverConfig.AccessToken) == 0 &&
+		len(c.serverConfig.TenantsFile) == 0 &&
+		!c.serverConfig.IsJwtEnabled() {
+		errs = append(errs, fmt.Sprintf("%s, %s, %s or %s is required if %s is enabled", ServerAccessToken, TenantsFile, JwtHs256Secret, JwtJwksFile, ServerAccessTokenValidationEnabled))
+	}
+
+	if c.serverConfig.AdminApiEnabled && len(c.serverConfig.AdminAccessToken) == 0 {
+		errs = append(errs, fmt.Sprintf("%s is required if %s is enabled", AdminAccessToken, AdminApiEnabled))
+	}
+
+	if c.serverConfig.DrainTimeoutInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", DrainTimeoutInSecs))
+	}
+
+	if c.serverConfig.ReadinessMaxQueueLength < 0 || c.serverConfig.ReadinessMaxLaunchFailures < 0 {
+		errs = append(errs, fmt.Sprintf("%s and %s must be greater than or equal to 0", ReadinessMaxQueueLength, ReadinessMaxLaunchFailures))
 	}
 
 	if c.proxyQueueConfig.ThroughputScaleUpThreshold <= 0 {
 		errs = append(errs, fmt.Sprintf("%s must be greater than 0.0", ThroughputScaleUpThreshold))
 	}
 
+	// hysteresis between scale-up and scale-down keeps the pool from flapping
+	if c.proxyQueueConfig.EnableScaleDown &&
+		c.proxyQueueConfig.ThroughputScaleDownThreshold >= c.proxyQueueConfig.ThroughputScaleUpThreshold {
+		errs = append(errs, fmt.Sprintf("%s must be less than %s", ThroughputScaleDownThreshold, ThroughputScaleUpThreshold))
+	}
+
+	if c.proxyQueueConfig.ScaleDownWindowInSecs < 0 || c.proxyQueueConfig.ScaleDownCooldownInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s and %s must be greater than or equal to 0", ScaleDownWindowInSecs, ScaleDownCooldownInSecs))
+	}
+
+	switch c.proxyQueueConfig.ScalerStrategy {
+	case ScalerStrategyThroughput, ScalerStrategyQueueDepth, ScalerStrategyUtilization:
+	case ScalerStrategyScheduled:
+		if _, err := ParseScalerSchedule(c.proxyQueueConfig.ScalerSchedule); err != nil {
+			errs = append(errs, fmt.Sprintf("%s is invalid: %s", ScalerSchedule, err.Error()))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf(
+			"%s must be one of %s, %s, %s, %s",
+			ScalerStrategy,
+			ScalerStrategyThroughput,
+			ScalerStrategyQueueDepth,
+			ScalerStrategyUtilization,
+			ScalerStrategyScheduled,
+		))
+	}
+
+	if c.proxyQueueConfig.ScalerTargetQueueDepth <= 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than 0.0", ScalerTargetQueueDepth))
+	}
+
+	if c.proxyQueueConfig.ScalerTargetUtilization <= 0 || c.proxyQueueConfig.ScalerTargetUtilization > 1 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than 0.0 and at most 1.0", ScalerTargetUtilization))
+	}
+
+	if c.proxyQueueConfig.DefaultProxyTimeInSecs <= 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than 0.0", DefaultProxyTimeInSecs))
+	}
+
+	if c.proxyQueueConfig.MaxSessionPriority < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", MaxSessionPriority))
+	}
+
+	if c.proxyQueueConfig.DefaultMaxSessionPriority < 0 || c.proxyQueueConfig.DefaultMaxSessionPriority > c.proxyQueueConfig.MaxSessionPriority {
+		errs = append(errs, fmt.Sprintf("%s must be between 0 and %s", DefaultMaxSessionPriority, MaxSessionPriority))
+	}
+
+	if c.proxyQueueConfig.PriorityAgingPerSec < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", PriorityAgingPerSec))
+	}
+
+	if c.proxyQueueConfig.MaxQueueLength < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", MaxQueueLength))
+	}
+
+	if c.proxyQueueConfig.MaxQueueWaitInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", MaxQueueWaitInSecs))
+	}
+
+	if c.proxyQueueConfig.MaxSessionDurationInSecs < 0 ||
+		c.proxyQueueConfig.MaxSessionDurationCapInSecs < 0 ||
+		c.proxyQueueConfig.ClientIdleTimeoutInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s, %s and %s must be greater than or equal to 0", MaxSessionDurationInSecs, MaxSessionDurationCapInSecs, ClientIdleTimeoutInSecs))
+	}
+
+	if c.proxyQueueConfig.MaxSessionDurationCapInSecs > 0 &&
+		c.proxyQueueConfig.MaxSessionDurationInSecs > c.proxyQueueConfig.MaxSessionDurationCapInSecs {
+		errs = append(errs, fmt.Sprintf("%s must be less than or equal to %s", MaxSessionDurationInSecs, MaxSessionDurationCapInSecs))
+	}
+
+	if c.proxyQueueConfig.ResumeGracePeriodInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than or equal to 0", SessionResumeGracePeriodInSecs))
+	}
+
+	if c.rateLimitConfig.profileRateLimitsErr != nil {
+		errs = append(errs, fmt.Sprintf("%s is invalid: %s", ProfileRateLimits, c.rateLimitConfig.profileRateLimitsErr.Error()))
+	}
+	if c.rateLimitConfig.Default.Client.Validate() != nil {
+		errs = append(errs, fmt.Sprintf("%s, %s, %s and %s must be greater than or equal to 0", ClientMessagesPerSec, ClientMessageBurst, ClientBytesPerSec, ClientByteBurst))
+	}
+	if c.rateLimitConfig.Default.Chrome.Validate() != nil {
+		errs = append(errs, fmt.Sprintf("%s, %s, %s and %s must be greater than or equal to 0", ChromeMessagesPerSec, ChromeMessageBurst, ChromeBytesPerSec, ChromeByteBurst))
+	}
+
+	if c.proxyQueueConfig.ReadIdleTimeoutInSecs < 0 ||
+		c.proxyQueueConfig.WriteTimeoutInSecs < 0 ||
+		c.proxyQueueConfig.LimiterWaitTimeoutInSecs < 0 {
+		errs = append(errs, fmt.Sprintf("%s, %s and %s must be greater than or equal to 0", ProxyReadIdleTimeoutInSecs, ProxyWriteTimeoutInSecs, ProxyLimiterWaitTimeoutInSecs))
+	}
+
+	if c.proxyQueueConfig.ReadLimitInBytes <= 0 {
+		errs = append(errs, fmt.Sprintf("%s must be greater than 0", ProxyReadLimitInBytes))
+	}
+
+	for i := 1; i < len(c.metricsConfig.PrometheusSessionDurationBuckets); i++ {
+		if c.metricsConfig.PrometheusSessionDurationBuckets[i] <= c.metricsConfig.PrometheusSessionDurationBuckets[i-1] {
+			errs = append(errs, fmt.Sprintf("%s must be in increasing order", PrometheusSessionDurationBuckets))
+			break
+		}
+	}
+
+	if c.cdpPolicyConfig.Policy != CdpPolicyPermissive && c.cdpPolicyConfig.Policy != CdpPolicySharedBrowser {
+		errs = append(errs, fmt.Sprintf("%s must be one of %s, %s", CdpPolicy, CdpPolicyPermissive, CdpPolicySharedBrowser))
+	}
+
 	if len(errs) > 0 {
 		return errors.New(fmt.Sprintf("environment config failed validation with the following errors: \n%s", strings.Join(errs, ",\n")))
 	}
@@ -270,6 +731,89 @@ func getIntArrayFromEnv(envKey string, defaultVal []int) []int {
 	return evis
 }
 
+func getFloat64ArrayFromEnv(envKey string, defaultVal []float64) []float64 {
+	var evfs []float64
+	ev, exists := getEnvValByKey(envKey)
+	if !exists {
+		return defaultVal
+	}
+	evs := strings.Split(ev, ",")
+
+	for _, ev := range evs {
+		evf, err := strconv.ParseFloat(strings.TrimSpace(ev), 64)
+		if err != nil {
+			// TODO: log warning
+			return defaultVal
+		}
+		evfs = append(evfs, evf)
+	}
+	return evfs
+}
+
+func getStringArrayFromEnv(envKey string, defaultVal []string) []string {
+	var evs []string
+	ev, exists := getEnvValByKey(envKey)
+	if !exists {
+		return defaultVal
+	}
+
+	for _, ev := range strings.Split(ev, ",") {
+		ev = strings.TrimSpace(ev)
+		if len(ev) > 0 {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
+// getFloat64MapFromEnv parses comma separated key:value pairs, e.g. "a:1,b:2.5". Keys may contain colons
+func getFloat64MapFromEnv(envKey string, defaultVal map[string]float64) map[string]float64 {
+	ev, exists := getEnvValByKey(envKey)
+	if !exists {
+		return defaultVal
+	}
+
+	evm := make(map[string]float64)
+	for _, pair := range strings.Split(ev, ",") {
+		pair = strings.TrimSpace(pair)
+		i := strings.LastIndex(pair, ":")
+		if i < 1 {
+			// TODO: log warning
+			return defaultVal
+		}
+		evf, err := strconv.ParseFloat(strings.TrimSpace(pair[i+1:]), 64)
+		if err != nil {
+			return defaultVal
+		}
+		evm[pair[:i]] = evf
+	}
+	return evm
+}
+
+// getIntMapFromEnv parses comma separated key:value pairs, e.g. "a:1,b:2". Keys may contain colons
+func getIntMapFromEnv(envKey string, defaultVal map[string]int) map[string]int {
+	ev, exists := getEnvValByKey(envKey)
+	if !exists {
+		return defaultVal
+	}
+
+	evm := make(map[string]int)
+	for _, pair := range strings.Split(ev, ",") {
+		pair = strings.TrimSpace(pair)
+		i := strings.LastIndex(pair, ":")
+		if i < 1 {
+			// TODO: log warning
+			return defaultVal
+		}
+		evi, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return defaultVal
+		}
+		evm[pair[:i]] = evi
+	}
+	return evm
+}
+
 func getIntFromEnv(envKey string, defaultVal int) int {
 	ev, exists := getEnvValByKey(envKey)
 	if !exists {
@@ -319,6 +863,93 @@ func getSecTimeDurationFromEnv(envKey string, defaultVal int) time.Duration {
 	return time.Duration(getIntFromEnv(envKey, defaultVal)) * time.Second
 }
 
+// ParseProfileRateLimits parses a JSON object of RateLimits by profile, e.g. {"checkout":{"chrome":{"bytesPerSec":1e6}}}
+func ParseProfileRateLimits(profileRateLimits string) (map[string]RateLimits, error) {
+	limits := make(map[string]RateLimits)
+	if len(strings.TrimSpace(profileRateLimits)) == 0 {
+		return limits, nil
+	}
+	if err := json.Unmarshal([]byte(profileRateLimits), &limits); err != nil {
+		return make(map[string]RateLimits), err
+	}
+	for profile, rl := range limits {
+		if err := rl.Validate(); err != nil {
+			return make(map[string]RateLimits), errors.New(fmt.Sprintf("profile '%s': %s", profile, err.Error()))
+		}
+	}
+	return limits, nil
+}
+
+// Validate returns an error if a direction of rl has a negative rate or burst
+func (rl RateLimits) Validate() error {
+	if err := rl.Client.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("client %s", err.Error()))
+	}
+	if err := rl.Chrome.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("chrome %s", err.Error()))
+	}
+	return nil
+}
+
+// Validate returns an error if rl has a negative rate or burst
+func (rl *RateLimit) Validate() error {
+	if rl == nil {
+		return nil
+	}
+	if rl.MessagesPerSec < 0 || rl.MessageBurst < 0 || rl.BytesPerSec < 0 || rl.ByteBurst < 0 {
+		return errors.New("rates and bursts must be greater than or equal to 0")
+	}
+	return nil
+}
+
+// ParseScalerSchedule parses comma separated windows of "HH:MM-HH:MM=instances", e.g. "09:00-17:00=20".
+// A window that ends before it starts wraps around midnight
+func ParseScalerSchedule(schedule string) ([]ScalerScheduleWindow, error) {
+	var windows []ScalerScheduleWindow
+	if len(strings.TrimSpace(schedule)) == 0 {
+		return nil, errors.New("at least one window is required")
+	}
+
+	for _, window := range strings.Split(schedule, ",") {
+		span, instances, found := strings.Cut(strings.TrimSpace(window), "=")
+		if !found {
+			return nil, errors.New(fmt.Sprintf("window %s must be formatted as HH:MM-HH:MM=instances", window))
+		}
+		startStr, endStr, found := strings.Cut(span, "-")
+		if !found {
+			return nil, errors.New(fmt.Sprintf("window %s must be formatted as HH:MM-HH:MM=instances", window))
+		}
+
+		start, err := parseTimeOfDay(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endStr)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(instances))
+		if err != nil || n < 0 {
+			return nil, errors.New(fmt.Sprintf("window %s must have a non-negative number of instances", window))
+		}
+
+		windows = append(windows, ScalerScheduleWindow{
+			Start:     start,
+			End:       end,
+			Instances: n,
+		})
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("%s is not a time of day formatted as HH:MM", s))
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
 func NewCreateOptions(payload *ChromeConfigOptionsPayload) (co ChromeConfigOptions, err error) {
 	hash, err := hashstructure.Hash(&payload, hashstructure.FormatV2, nil)
 	if err != nil {
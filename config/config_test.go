@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 const TestLogFile = "tmp/config-logs.txt"
@@ -89,6 +90,168 @@ func (suite *ConfigTestSuite) TestDefaultLogFilePath() {
 	assert.Empty(suite.T(), c.GetLoggerConfig().LogFilePath)
 }
 
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithUnknownCdpPolicy() {
+	suite.T().Setenv(CdpPolicy, "unknown")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, CdpPolicy)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithAdminApiWithoutToken() {
+	suite.T().Setenv(AdminApiEnabled, "true")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, AdminAccessToken)
+}
+
+func (suite *ConfigTestSuite) TestAccessTokenTiers() {
+	suite.T().Setenv(ServerAccessTokenTiers, "interactive:10, batch:-2.5,with:colon:1")
+
+	c := Get()
+	assert.Equal(suite.T(), map[string]float64{
+		"interactive": 10,
+		"batch":       -2.5,
+		"with:colon":  1,
+	}, c.GetServerConfig().AccessTokenTiers)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithoutScaleHysteresis() {
+	suite.T().Setenv(ThroughputScaleUpThreshold, "0.5")
+	suite.T().Setenv(ThroughputScaleDownThreshold, "0.5")
+
+	// the thresholds only need hysteresis once scale-down is enabled
+	assert.False(suite.T(), Get().GetProxyQueueConfig().EnableScaleDown)
+	assert.Nil(suite.T(), Get().Validate())
+
+	Once = sync.Once{}
+	suite.T().Setenv(EnableScaleDown, "true")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, ThroughputScaleDownThreshold)
+}
+
+func (suite *ConfigTestSuite) TestScalerSchedule() {
+	windows, err := ParseScalerSchedule("09:00-17:30=20, 22:00-02:00=5")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), []ScalerScheduleWindow{
+		{Start: 9 * time.Hour, End: 17*time.Hour + 30*time.Minute, Instances: 20},
+		{Start: 22 * time.Hour, End: 2 * time.Hour, Instances: 5},
+	}, windows)
+
+	_, err = ParseScalerSchedule("09:00-25:00=20")
+	assert.NotNil(suite.T(), err)
+	_, err = ParseScalerSchedule("09:00-17:00")
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithScheduledStrategyWithoutSchedule() {
+	suite.T().Setenv(ScalerStrategy, ScalerStrategyScheduled)
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, ScalerSchedule)
+}
+
+func (suite *ConfigTestSuite) TestProfileMinBrowserInstances() {
+	suite.T().Setenv(MaxBrowserInstances, strconv.FormatInt(3, 10))
+	suite.T().Setenv(MinBrowserInstances, strconv.FormatInt(1, 10))
+	suite.T().Setenv(ProfileMinBrowserInstances, "a:1,b:2")
+
+	c := Get()
+	assert.Equal(suite.T(), map[string]int{"a": 1, "b": 2}, c.GetChromePoolConfig().ProfileMinBrowserInstances)
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, ProfileMinBrowserInstances)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithMaxSessionDurationAboveCap() {
+	suite.T().Setenv(MaxSessionDurationInSecs, "600")
+	suite.T().Setenv(MaxSessionDurationCapInSecs, "300")
+
+	c := Get()
+	assert.Equal(suite.T(), 10*time.Minute, c.GetProxyQueueConfig().MaxSessionDurationInSecs)
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, MaxSessionDurationCapInSecs)
+}
+
+func (suite *ConfigTestSuite) TestProfileRateLimits() {
+	suite.T().Setenv(ProfileRateLimits, `{"checkout":{"chrome":{"bytesPerSec":1000000}}}`)
+
+	c := Get()
+	assert.Nil(suite.T(), c.Validate())
+	limits := c.GetRateLimitConfig()
+	assert.Equal(suite.T(), float64(ClientMessagesPerSecDefault), limits.Default.Client.MessagesPerSec)
+
+	// the profile only replaces the chrome direction
+	overridden := limits.Default.Override(&RateLimits{Chrome: limits.Profiles["checkout"].Chrome})
+	assert.Same(suite.T(), limits.Default.Client, overridden.Client)
+	assert.Equal(suite.T(), &RateLimit{BytesPerSec: 1000000}, overridden.Chrome)
+	assert.Equal(suite.T(), limits.Default, limits.Default.Override(nil))
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithInvalidRateLimits() {
+	_, err := ParseProfileRateLimits(`{"checkout":{"client":{"messagesPerSec":-1}}}`)
+	assert.ErrorContains(suite.T(), err, "checkout")
+	_, err = ParseProfileRateLimits(`not json`)
+	assert.NotNil(suite.T(), err)
+
+	suite.T().Setenv(ProfileRateLimits, `not json`)
+	suite.T().Setenv(ChromeByteBurst, "-1")
+
+	c := Get()
+	err = c.Validate()
+	assert.ErrorContains(suite.T(), err, ProfileRateLimits)
+	assert.ErrorContains(suite.T(), err, ChromeByteBurst)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithNegativeResumeGracePeriod() {
+	suite.T().Setenv(SessionResumeGracePeriodInSecs, "-30")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, SessionResumeGracePeriodInSecs)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithoutReadLimit() {
+	assert.Equal(suite.T(), ProxyReadLimitInBytesDefault, Get().GetProxyQueueConfig().ReadLimitInBytes)
+
+	Once = sync.Once{}
+	suite.T().Setenv(ProxyReadLimitInBytes, "0")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, ProxyReadLimitInBytes)
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithNegativeStickySessionLimits() {
+	suite.T().Setenv(StickySessionIdleTtlInSecs, "-1")
+	suite.T().Setenv(MaxPinnedBrowsersPerTenant, "-1")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, StickySessionIdleTtlInSecs)
+	assert.ErrorContains(suite.T(), err, MaxPinnedBrowsersPerTenant)
+}
+
+func (suite *ConfigTestSuite) TestDefaultMaxSessionPriorityDefaultsToMax() {
+	suite.T().Setenv(MaxSessionPriority, "5")
+
+	c := Get()
+	assert.Equal(suite.T(), float64(5), c.GetProxyQueueConfig().DefaultMaxSessionPriority)
+	assert.Nil(suite.T(), c.Validate())
+}
+
+func (suite *ConfigTestSuite) TestConfigFailsValidationWithDefaultMaxSessionPriorityAboveMax() {
+	suite.T().Setenv(MaxSessionPriority, "5")
+	suite.T().Setenv(DefaultMaxSessionPriority, "8")
+
+	c := Get()
+	err := c.Validate()
+	assert.ErrorContains(suite.T(), err, DefaultMaxSessionPriority)
+}
+
 func TestConfigSuite(t *testing.T) {
 	suite.Run(t, new(ConfigTestSuite))
 }
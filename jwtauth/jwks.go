@@ -0,0 +1,139 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type key struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// KeySet holds the RSA and P-256 public keys of a JWKS
+type KeySet struct {
+	keys []key
+}
+
+// LoadKeySet reads a JWKS file formatted as {"keys": [...]}
+func LoadKeySet(path string) (*KeySet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeySet(b)
+}
+
+// ParseKeySet parses the RSA keys as RS256 keys and the P-256 EC keys as ES256 keys of a JWKS.
+// Keys not used for signatures are skipped
+func ParseKeySet(b []byte) (*KeySet, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse JWKS: %s", err.Error()))
+	}
+
+	ks := &KeySet{}
+	for _, k := range jwks.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		parsed, err := k.parse()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to parse JWKS key '%s': %s", k.Kid, err.Error()))
+		}
+		ks.keys = append(ks.keys, parsed)
+	}
+	if len(ks.keys) == 0 {
+		return nil, errors.New("JWKS contains no signing keys")
+	}
+	return ks, nil
+}
+
+func (k *jwk) parse() (key, error) {
+	switch k.Kty {
+	case "RSA":
+		if len(k.Alg) > 0 && k.Alg != "RS256" {
+			return key{}, errors.New(fmt.Sprintf("unsupported alg %s", k.Alg))
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return key{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return key{}, err
+		}
+		return key{kid: k.Kid, alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" || (len(k.Alg) > 0 && k.Alg != "ES256") {
+			return key{}, errors.New(fmt.Sprintf("unsupported curve %s", k.Crv))
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return key{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return key{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return key{}, errors.New("point is not on P-256")
+		}
+		return key{kid: k.Kid, alg: "ES256", key: pub}, nil
+	}
+	return key{}, errors.New(fmt.Sprintf("unsupported kty %s", k.Kty))
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Get returns the key with kid for alg. Without a kid the key set must hold exactly one key for alg
+func (ks *KeySet) Get(kid string, alg string) (interface{}, bool) {
+	if ks == nil {
+		return nil, false
+	}
+	var found []interface{}
+	for _, k := range ks.keys {
+		if k.alg != alg {
+			continue
+		}
+		if k.kid == kid {
+			return k.key, true
+		}
+		if len(kid) == 0 {
+			found = append(found, k.key)
+		}
+	}
+	if len(found) == 1 {
+		return found[0], true
+	}
+	return nil, false
+}
@@ -0,0 +1,151 @@
+package jwtauth
+
+import (
+	"chromium-websocket-proxy/config"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Claims restrict what a session connecting with the token may do. Unset claims do not restrict the session
+type Claims struct {
+	jwt.RegisteredClaims
+	Profiles                 []string `json:"profiles,omitempty"`
+	MaxSessionDurationInSecs int      `json:"maxSessionDurationInSecs,omitempty"`
+	MaxPriority              *float64 `json:"maxPriority,omitempty"`
+}
+
+// Validator validates JWTs signed with HS256 and a shared secret, or with RS256/ES256 and a key from a JWKS
+type Validator struct {
+	secret   []byte
+	keys     *KeySet
+	issuer   string
+	audience string
+}
+
+var once sync.Once
+
+var v *Validator
+
+// Init creates the validator from JWT_HS256_SECRET and JWT_JWKS_FILE. Get returns nil if neither is set
+func Init() error {
+	var err error
+	once.Do(func() {
+		conf := config.Get().GetServerConfig()
+		if len(conf.JwtHs256Secret) == 0 && len(conf.JwtJwksFile) == 0 {
+			return
+		}
+
+		var keys *KeySet
+		if len(conf.JwtJwksFile) > 0 {
+			keys, err = LoadKeySet(conf.JwtJwksFile)
+			if err != nil {
+				return
+			}
+		}
+		v = NewValidator([]byte(conf.JwtHs256Secret), keys, conf.JwtIssuer, conf.JwtAudience)
+	})
+	return err
+}
+
+func Get() *Validator {
+	return v
+}
+
+// NewValidator accepts HS256 tokens if secret is not empty, and RS256/ES256 tokens if keys is not nil.
+// issuer and audience are only checked if they are set
+func NewValidator(secret []byte, keys *KeySet, issuer string, audience string) *Validator {
+	return &Validator{
+		secret:   secret,
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// IsJwt returns true if token is formatted like a JWT, as opposed to a static access token
+func IsJwt(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Validate checks the signature, expiry, issuer and audience of token and returns its claims
+func (val *Validator) Validate(token string) (*Claims, error) {
+	var methods []string
+	if len(val.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if val.keys != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+	}
+	if len(val.issuer) > 0 {
+		opts = append(opts, jwt.WithIssuer(val.issuer))
+	}
+	if len(val.audience) > 0 {
+		opts = append(opts, jwt.WithAudience(val.audience))
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, val.keyFunc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.MaxSessionDurationInSecs < 0 {
+		return nil, errors.New("maxSessionDurationInSecs must be greater than or equal to 0")
+	}
+	return claims, nil
+}
+
+func (val *Validator) keyFunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		return val.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, exists := val.keys.Get(kid, token.Method.Alg())
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("no %s key with kid '%s' in the JWKS", token.Method.Alg(), kid))
+	}
+	return key, nil
+}
+
+// IsProfileAllowed returns true if the claims allow sessions with profile
+func (c *Claims) IsProfileAllowed(profile string) bool {
+	if c == nil || len(c.Profiles) == 0 {
+		return true
+	}
+	for _, p := range c.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxSessionDuration returns the longest a session may be proxied, or 0 if it is unlimited
+func (c *Claims) MaxSessionDuration() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return time.Duration(c.MaxSessionDurationInSecs) * time.Second
+}
+
+type contextKey struct{}
+
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the claims added to ctx with WithClaims, or nil
+func FromContext(ctx context.Context) *Claims {
+	c, _ := ctx.Value(contextKey{}).(*Claims)
+	return c
+}
@@ -0,0 +1,156 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type JwtAuthTestSuite struct {
+	suite.Suite
+}
+
+func encode(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "backend",
+			Audience:  jwt.ClaimStrings{"proxy"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func (suite *JwtAuthTestSuite) TestHs256() {
+	v := NewValidator([]byte("secret"), nil, "backend", "proxy")
+
+	maxPriority := 2.0
+	claims := validClaims()
+	claims.Profiles = []string{"checkout"}
+	claims.MaxSessionDurationInSecs = 30
+	claims.MaxPriority = &maxPriority
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), IsJwt(token))
+
+	parsed, err := v.Validate(token)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), parsed.IsProfileAllowed("checkout"))
+	assert.False(suite.T(), parsed.IsProfileAllowed(""))
+	assert.Equal(suite.T(), 30*time.Second, parsed.MaxSessionDuration())
+	assert.Equal(suite.T(), 2.0, *parsed.MaxPriority)
+
+	// wrong secret
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("other"))
+	_, err = v.Validate(token)
+	assert.NotNil(suite.T(), err)
+
+	// expired
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, expired).SignedString([]byte("secret"))
+	_, err = v.Validate(token)
+	assert.ErrorIs(suite.T(), err, jwt.ErrTokenExpired)
+
+	// missing expiry
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = nil
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, noExpiry).SignedString([]byte("secret"))
+	_, err = v.Validate(token)
+	assert.NotNil(suite.T(), err)
+
+	// wrong audience
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, wrongAudience).SignedString([]byte("secret"))
+	_, err = v.Validate(token)
+	assert.ErrorIs(suite.T(), err, jwt.ErrTokenInvalidAudience)
+
+	// unsigned
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = v.Validate(token)
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *JwtAuthTestSuite) TestJwks() {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(suite.T(), err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(suite.T(), err)
+
+	path := filepath.Join(suite.T().TempDir(), "jwks.json")
+	err = os.WriteFile(path, []byte(fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa-1","use":"sig","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec-1","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc-1","use":"enc","n":"%s","e":"%s"}
+	]}`,
+		encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))),
+		encode(ecKey.X), encode(ecKey.Y),
+		encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))),
+	)), os.ModePerm)
+	assert.Nil(suite.T(), err)
+
+	keys, err := LoadKeySet(path)
+	assert.Nil(suite.T(), err)
+	v := NewValidator(nil, keys, "", "")
+
+	rs := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+	rs.Header["kid"] = "rsa-1"
+	token, err := rs.SignedString(rsaKey)
+	assert.Nil(suite.T(), err)
+	_, err = v.Validate(token)
+	assert.Nil(suite.T(), err)
+
+	// the only ES256 key is used without a kid
+	token, err = jwt.NewWithClaims(jwt.SigningMethodES256, validClaims()).SignedString(ecKey)
+	assert.Nil(suite.T(), err)
+	_, err = v.Validate(token)
+	assert.Nil(suite.T(), err)
+
+	// unknown kid
+	rs.Header["kid"] = "enc-1"
+	token, _ = rs.SignedString(rsaKey)
+	_, err = v.Validate(token)
+	assert.NotNil(suite.T(), err)
+
+	// HS256 is not accepted without a secret
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte(""))
+	_, err = v.Validate(token)
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *JwtAuthTestSuite) TestParseKeySetErrors() {
+	_, err := ParseKeySet([]byte(`{"keys":[]}`))
+	assert.NotNil(suite.T(), err)
+
+	_, err = ParseKeySet([]byte(`{"keys":[{"kty":"EC","crv":"P-384","x":"AQ","y":"AQ"}]}`))
+	assert.NotNil(suite.T(), err)
+
+	_, err = ParseKeySet([]byte(`not json`))
+	assert.NotNil(suite.T(), err)
+}
+
+func (suite *JwtAuthTestSuite) TestNilClaimsDoNotRestrict() {
+	var c *Claims
+	assert.True(suite.T(), c.IsProfileAllowed("any"))
+	assert.Equal(suite.T(), time.Duration(0), c.MaxSessionDuration())
+	assert.False(suite.T(), IsJwt("static-token"))
+}
+
+func TestJwtAuthTestSuite(t *testing.T) {
+	suite.Run(t, new(JwtAuthTestSuite))
+}
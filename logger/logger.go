@@ -20,17 +20,19 @@ const (
 	BrowserIdTrackingKey      = "browserId"
 	BrowserProfileTrackingKey = "browserProfile"
 	SessionIdTrackingKey      = "sessionId"
+	TenantTrackingKey         = "tenant"
 )
 
 type TracingHook struct{}
 
-// Run adds specific context keys to the log event if they exist in ctx: sessionId, browserId, and browserProfile.
+// Run adds specific context keys to the log event if they exist in ctx: sessionId, browserId, browserProfile and tenant.
 func (h TracingHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
 	ctx := e.GetCtx()
 
 	h.addKeyToEventIfExists(e, ctx, SessionIdTrackingKey)
 	h.addKeyToEventIfExists(e, ctx, BrowserIdTrackingKey)
 	h.addKeyToEventIfExists(e, ctx, BrowserProfileTrackingKey)
+	h.addKeyToEventIfExists(e, ctx, TenantTrackingKey)
 }
 
 func (h TracingHook) addKeyToEventIfExists(e *zerolog.Event, eCtx context.Context, ctxKey string) {
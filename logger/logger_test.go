@@ -22,6 +22,7 @@ type ExpectedLog struct {
 	Message   string `json:"message,omitempty"`
 	SessionId string `json:"sessionId,omitempty"`
 	BrowserId string `json:"browserId,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
 }
 
 type LoggerTestSuite struct {
@@ -217,6 +218,18 @@ func (suite *LoggerTestSuite) TestMultipleContexts() {
 	assert.Equal(suite.T(), browserIdEl.BrowserId, expectedBrowserIdVal.String())
 }
 
+func (suite *LoggerTestSuite) TestTenantContext() {
+	suite.T().Setenv(config.LogFilePath, TestLogFile)
+
+	logger := Get()
+	logger.Info().Ctx(context.WithValue(context.Background(), TenantTrackingKey, "acme")).Msg("message")
+
+	els, err := getExpectedLogsFromLogFile()
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), els, 1)
+	assert.Equal(suite.T(), "acme", els[0].Tenant)
+}
+
 func TestLoggerSuite(t *testing.T) {
 	suite.Run(t, new(LoggerTestSuite))
 }
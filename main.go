@@ -4,16 +4,20 @@ import (
 	"chromium-websocket-proxy/chromepool"
 	"chromium-websocket-proxy/chromeprofile"
 	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
 	"chromium-websocket-proxy/logger"
 	"chromium-websocket-proxy/metrics"
 	"chromium-websocket-proxy/proxyqueue"
 	"chromium-websocket-proxy/servemux"
+	"chromium-websocket-proxy/tenant"
 	"context"
 	"fmt"
 	"net"
 	"net/http"
+	"nhooyr.io/websocket"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -31,6 +35,16 @@ func main() {
 		log.Fatal().Err(err).Msg("unable to start metrics client")
 	}
 
+	err = tenant.Init()
+	if err != nil {
+		log.Fatal().Err(err).Msg(fmt.Sprintf("unable to load %s", config.TenantsFile))
+	}
+
+	err = jwtauth.Init()
+	if err != nil {
+		log.Fatal().Err(err).Msg(fmt.Sprintf("unable to load %s", config.JwtJwksFile))
+	}
+
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", c.GetServerConfig().Port))
 	if err != nil {
 		log.Fatal().Err(err).Msg(fmt.Sprintf("unable to listen on port %d", c.GetServerConfig().Port))
@@ -56,7 +70,7 @@ func main() {
 	}()
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	select {
 	case err := <-errc:
 		log.Fatal().Err(err).Msg("failed to serve")
@@ -64,6 +78,8 @@ func main() {
 		log.Info().Msg(fmt.Sprintf("terminating with %v", sig))
 	}
 
+	drain(c.GetServerConfig().DrainTimeoutInSecs, sigs)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
@@ -74,3 +90,35 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to gracefully terminate server")
 	}
 }
+
+// drain stops accepting sessions and waits up to timeout for active sessions to finish before closing them with
+// GoingAway. A second signal stops waiting
+func drain(timeout time.Duration, sigs chan os.Signal) {
+	log := logger.Get()
+	pq := proxyqueue.Get()
+	pq.StartDraining()
+	log.Info().Msg(fmt.Sprintf("draining %d active session(s) for up to %v", pq.ActiveSessionCount(), timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if pq.WaitForActiveSessions(ctx) {
+		log.Info().Msg("drained every active session")
+		return
+	}
+
+	closed := pq.CloseActiveSessions(websocket.StatusGoingAway, "server is shutting down")
+	log.Warn().Msg(fmt.Sprintf("closed %d active session(s) still running after %s", closed, config.DrainTimeoutInSecs))
+
+	// give the closed sessions a moment to release their browsers
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer closeCancel()
+	pq.WaitForActiveSessions(closeCtx)
+}
@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"github.com/hashicorp/go-metrics/datadog"
+)
+
+// newDogStatsdSink creates a DogStatsD sink that sends metric labels as Datadog tags.
+// hostName is spliced out of metric keys and sent as a host tag instead
+func newDogStatsdSink(address string, hostName string) (*datadog.DogStatsdSink, error) {
+	sink, err := datadog.NewDogStatsdSink(address, hostName)
+	if err != nil {
+		return nil, err
+	}
+	sink.EnableHostNamePropagation()
+	return sink, nil
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"chromium-websocket-proxy/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+// readPackets reads from conn until a packet containing expected arrives or timeout elapses
+func readPackets(t *testing.T, conn *net.UDPConn, expected string, timeout time.Duration) string {
+	var received []string
+	buf := make([]byte, 65536)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		_ = conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		received = append(received, string(buf[:n]))
+		if strings.Contains(string(buf[:n]), expected) {
+			break
+		}
+	}
+	return strings.Join(received, "\n")
+}
+
+func TestDogStatsdSinkSendsTaggedMetrics(t *testing.T) {
+	once = sync.Once{}
+	config.Once = sync.Once{}
+
+	conn := listenUDP(t)
+	t.Setenv(config.DataDogAddress, conn.LocalAddr().String())
+	t.Setenv(config.DataDogHostName, "test-host")
+
+	err := Init()
+	assert.Nil(t, err)
+
+	Get().Remote.IncCounterWithLabels(ProxyResults, 1, []Label{
+		{Name: ProfileLabel, Value: "profile-a"},
+		{Name: ResultLabel, Value: "Succeeded"},
+	})
+
+	expected := "chromium-websocket-proxy.proxy-result:1|c|#browser_profile:profile-a,result:Succeeded,host:test-host"
+	assert.Contains(t, readPackets(t, conn, expected, 3*time.Second), expected)
+}
+
+func TestDogStatsdSinkSplicesHostNameOutOfGauges(t *testing.T) {
+	once = sync.Once{}
+	config.Once = sync.Once{}
+
+	conn := listenUDP(t)
+	t.Setenv(config.DataDogAddress, conn.LocalAddr().String())
+	t.Setenv(config.DataDogHostName, "test-host")
+
+	err := Init()
+	assert.Nil(t, err)
+
+	Get().Remote.SetGauge(ChromeInstances, 2)
+
+	expected := "chromium-websocket-proxy.chrome-instances:2|g|#host:test-host"
+	assert.Contains(t, readPackets(t, conn, expected, 3*time.Second), expected)
+}
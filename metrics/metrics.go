@@ -8,18 +8,38 @@ import (
 )
 
 type Metrics struct {
-	InMemory InMemory
-	Remote   Remote
+	InMemory   InMemory
+	Remote     Remote
+	Prometheus *Prometheus
 }
 
 type MetricKey string
 
+type Label = metrics.Label
+
 const (
 	ProxyQueue      MetricKey = "proxy-queue"
 	ProxyTimeSecs   MetricKey = "proxy-time-secs"
 	ChromeInstances MetricKey = "chrome-instances"
+	ProxyResults    MetricKey = "proxy-result"
+	ProxyShed       MetricKey = "proxy-shed"
+	ThrottleWait    MetricKey = "throttle-wait-secs"
 )
 
+const (
+	ResultLabel     = "result"
+	ProfileLabel    = "browser_profile"
+	ShedReasonLabel = "reason"
+	TenantLabel     = "tenant"
+	DirectionLabel  = "direction"
+)
+
+// DefaultProfileLabelValue is used as the ProfileLabel value for sessions without a browser profile
+const DefaultProfileLabelValue = "default"
+
+// DefaultTenantLabelValue is used as the TenantLabel value for sessions without a tenant
+const DefaultTenantLabelValue = "none"
+
 var once = sync.Once{}
 
 var m *Metrics
@@ -34,10 +54,20 @@ func Init() (err error) {
 
 		var rs metrics.MetricSink
 		var rsm *metrics.Metrics
+		rsConf := metrics.DefaultConfig("chromium-websocket-proxy")
+
+		// only sinks with tag support receive labels. statsd and statsite would otherwise flatten them into the key
+		tagged := false
 		if isStringPopulated(conf.StatsiteSink) {
 			rs, err = metrics.NewStatsiteSink(conf.StatsiteSink)
 		} else if isStringPopulated(conf.StatsDSink) {
 			rs, err = metrics.NewStatsdSink(conf.StatsDSink)
+		} else if isStringPopulated(conf.DataDogAddress) {
+			if isStringPopulated(conf.DataDogHostName) {
+				rsConf.HostName = conf.DataDogHostName
+			}
+			rs, err = newDogStatsdSink(conf.DataDogAddress, rsConf.HostName)
+			tagged = true
 		}
 		if err != nil {
 			return
@@ -55,24 +85,29 @@ func Init() (err error) {
 		}
 
 		if rs != nil {
-			rsm, err = metrics.New(
-				metrics.DefaultConfig("chromium-websocket-proxy"),
-				rs,
-			)
+			rsm, err = metrics.New(rsConf, rs)
 			if err != nil {
 				return
 			}
 		}
 
+		var pm *Prometheus
+		if conf.PrometheusEnabled {
+			pm = NewPrometheus(conf.PrometheusSessionDurationBuckets)
+		}
+
 		m = &Metrics{
 			InMemory: InMemory{
 				sink:    ims,
 				metrics: imm,
 			},
 			Remote: Remote{
-				sink:    rs,
-				metrics: rsm,
+				sink:       rs,
+				metrics:    rsm,
+				tagged:     tagged,
+				prometheus: pm,
 			},
+			Prometheus: pm,
 		}
 	})
 	return err
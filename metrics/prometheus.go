@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const prometheusNamespace = "chromium_websocket_proxy"
+
+// upDownCounters are incremented and decremented, so they are exposed as prometheus gauges instead of counters
+var upDownCounters = map[MetricKey]bool{
+	ProxyQueue: true,
+}
+
+type Prometheus struct {
+	registry   *prometheus.Registry
+	handler    http.Handler
+	collectors map[MetricKey]prometheus.Collector
+	mutex      sync.Mutex
+	buckets    map[MetricKey][]float64
+}
+
+func NewPrometheus(sessionDurationBuckets []float64) *Prometheus {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return &Prometheus{
+		registry:   registry,
+		handler:    promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		collectors: make(map[MetricKey]prometheus.Collector),
+		buckets: map[MetricKey][]float64{
+			ProxyTimeSecs: sessionDurationBuckets,
+		},
+	}
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+func (p *Prometheus) IncCounter(key MetricKey, val float32, labels []Label) {
+	names, values := splitLabels(labels)
+	if upDownCounters[key] {
+		p.getCollector(key, names, p.newGaugeVec).(*prometheus.GaugeVec).WithLabelValues(values...).Add(float64(val))
+		return
+	}
+	p.getCollector(key, names, p.newCounterVec).(*prometheus.CounterVec).WithLabelValues(values...).Add(float64(val))
+}
+
+func (p *Prometheus) SetGauge(key MetricKey, val float32, labels []Label) {
+	names, values := splitLabels(labels)
+	p.getCollector(key, names, p.newGaugeVec).(*prometheus.GaugeVec).WithLabelValues(values...).Set(float64(val))
+}
+
+func (p *Prometheus) AddSample(key MetricKey, val float32, labels []Label) {
+	names, values := splitLabels(labels)
+	p.getCollector(key, names, p.newHistogramVec).(*prometheus.HistogramVec).WithLabelValues(values...).Observe(float64(val))
+}
+
+// getCollector returns the collector registered for key, creating and registering it with newCollector if needed.
+// Label names must be consistent for every use of the same key
+func (p *Prometheus) getCollector(
+	key MetricKey,
+	labelNames []string,
+	newCollector func(MetricKey, []string) prometheus.Collector,
+) prometheus.Collector {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	c, exists := p.collectors[key]
+	if !exists {
+		c = newCollector(key, labelNames)
+		p.registry.MustRegister(c)
+		p.collectors[key] = c
+	}
+	return c
+}
+
+func (p *Prometheus) newCounterVec(key MetricKey, labelNames []string) prometheus.Collector {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      sanitizePrometheusName(string(key)) + "_total",
+		Help:      string(key),
+	}, labelNames)
+}
+
+func (p *Prometheus) newGaugeVec(key MetricKey, labelNames []string) prometheus.Collector {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      sanitizePrometheusName(string(key)),
+		Help:      string(key),
+	}, labelNames)
+}
+
+func (p *Prometheus) newHistogramVec(key MetricKey, labelNames []string) prometheus.Collector {
+	buckets, exists := p.buckets[key]
+	if !exists {
+		buckets = prometheus.DefBuckets
+	}
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prometheusNamespace,
+		Name:      sanitizePrometheusName(string(key)),
+		Help:      string(key),
+		Buckets:   buckets,
+	}, labelNames)
+}
+
+// splitLabels returns label names and values sorted by name so that the order of labels passed in does not matter
+func splitLabels(labels []Label) ([]string, []string) {
+	sorted := make([]Label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	names := make([]string, len(sorted))
+	values := make([]string, len(sorted))
+	for i, l := range sorted {
+		names[i] = sanitizePrometheusName(l.Name)
+		values[i] = l.Value
+	}
+	return names, values
+}
+
+func sanitizePrometheusName(name string) string {
+	return strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(name)
+}
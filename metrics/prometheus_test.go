@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, p *Prometheus) string {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	assert.Nil(t, err)
+	return string(body)
+}
+
+func TestPrometheusExposesCountersGaugesAndHistograms(t *testing.T) {
+	p := NewPrometheus([]float64{1, 10, 100})
+
+	p.IncCounter(ProxyResults, 1, []Label{{Name: ResultLabel, Value: "Succeeded"}})
+	p.IncCounter(ProxyResults, 1, []Label{{Name: ResultLabel, Value: "Succeeded"}})
+	p.SetGauge(ChromeInstances, 3, nil)
+	p.AddSample(ProxyTimeSecs, 5, nil)
+
+	body := scrape(t, p)
+
+	assert.Contains(t, body, `chromium_websocket_proxy_proxy_result_total{result="Succeeded"} 2`)
+	assert.Contains(t, body, `chromium_websocket_proxy_chrome_instances 3`)
+	assert.Contains(t, body, `chromium_websocket_proxy_proxy_time_secs_bucket{le="1"} 0`)
+	assert.Contains(t, body, `chromium_websocket_proxy_proxy_time_secs_bucket{le="10"} 1`)
+	assert.Contains(t, body, `chromium_websocket_proxy_proxy_time_secs_count 1`)
+}
+
+func TestPrometheusUpDownCounterIsGauge(t *testing.T) {
+	p := NewPrometheus([]float64{1})
+
+	p.IncCounter(ProxyQueue, 1, nil)
+	p.IncCounter(ProxyQueue, 1, nil)
+	p.IncCounter(ProxyQueue, -1, nil)
+
+	body := scrape(t, p)
+
+	assert.Contains(t, body, "# TYPE chromium_websocket_proxy_proxy_queue gauge")
+	assert.Contains(t, body, "chromium_websocket_proxy_proxy_queue 1")
+}
@@ -5,24 +5,54 @@ import (
 )
 
 type Remote struct {
-	sink    metrics.MetricSink
-	metrics *metrics.Metrics
+	sink       metrics.MetricSink
+	metrics    *metrics.Metrics
+	tagged     bool
+	prometheus *Prometheus
+}
+
+func (r *Remote) sinkLabels(labels []Label) []Label {
+	if !r.tagged {
+		return nil
+	}
+	return labels
 }
 
 func (r *Remote) AddSample(key MetricKey, val float32) {
+	r.AddSampleWithLabels(key, val, nil)
+}
+
+func (r *Remote) AddSampleWithLabels(key MetricKey, val float32, labels []Label) {
 	if r.metrics != nil {
-		r.metrics.AddSample([]string{string(key)}, val)
+		r.metrics.AddSampleWithLabels([]string{string(key)}, val, r.sinkLabels(labels))
+	}
+	if r.prometheus != nil {
+		r.prometheus.AddSample(key, val, labels)
 	}
 }
 
 func (r *Remote) IncCounter(key MetricKey, val float32) {
+	r.IncCounterWithLabels(key, val, nil)
+}
+
+func (r *Remote) IncCounterWithLabels(key MetricKey, val float32, labels []Label) {
 	if r.metrics != nil {
-		r.metrics.IncrCounter([]string{string(key)}, val)
+		r.metrics.IncrCounterWithLabels([]string{string(key)}, val, r.sinkLabels(labels))
+	}
+	if r.prometheus != nil {
+		r.prometheus.IncCounter(key, val, labels)
 	}
 }
 
 func (r *Remote) SetGauge(key MetricKey, val float32) {
+	r.SetGaugeWithLabels(key, val, nil)
+}
+
+func (r *Remote) SetGaugeWithLabels(key MetricKey, val float32, labels []Label) {
 	if r.metrics != nil {
-		r.metrics.SetGauge([]string{string(key)}, val)
+		r.metrics.SetGaugeWithLabels([]string{string(key)}, val, r.sinkLabels(labels))
+	}
+	if r.prometheus != nil {
+		r.prometheus.SetGauge(key, val, labels)
 	}
 }
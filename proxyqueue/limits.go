@@ -0,0 +1,98 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"nhooyr.io/websocket"
+	"strconv"
+	"time"
+)
+
+// Close codes sent to clients whose session is ended by the proxy. 4000-4999 are reserved for applications
+const (
+	StatusMaxSessionDuration websocket.StatusCode = 4001
+	StatusClientIdle         websocket.StatusCode = 4002
+)
+
+// MaxSessionDurationParam lets clients ask for a max session duration up to MAX_SESSION_DURATION_CAP_IN_SECS, or up
+// to MAX_SESSION_DURATION_IN_SECS if there is no cap
+const MaxSessionDurationParam = "maxSessionDurationInSecs"
+
+var ErrMaxSessionDuration = errors.New("maximum session duration reached")
+
+var ErrClientIdle = errors.New("client sent no messages within the idle timeout")
+
+// getMaxSessionDuration returns the max session duration requested with the maxSessionDurationInSecs connect param,
+// or MAX_SESSION_DURATION_IN_SECS. Both are clamped to MAX_SESSION_DURATION_CAP_IN_SECS, which defaults to
+// MAX_SESSION_DURATION_IN_SECS so that clients can only shorten their sessions. 0 is unlimited
+func getMaxSessionDuration(r *http.Request) (time.Duration, error) {
+	conf := config.Get().GetProxyQueueConfig()
+	duration := conf.MaxSessionDurationInSecs
+
+	if d := r.URL.Query().Get(MaxSessionDurationParam); len(d) > 0 {
+		secs, err := strconv.Atoi(d)
+		if err != nil || secs <= 0 {
+			return 0, errors.New(fmt.Sprintf("req.query['%s'] must be a number greater than 0, received %s", MaxSessionDurationParam, d))
+		}
+		duration = time.Duration(secs) * time.Second
+	}
+
+	limit := conf.MaxSessionDurationCapInSecs
+	if limit == 0 {
+		limit = conf.MaxSessionDurationInSecs
+	}
+	if limit > 0 && (duration == 0 || duration > limit) {
+		duration = limit
+	}
+	return duration, nil
+}
+
+// waitForSessionEnd blocks until errC receives, the session has lasted maxDuration, or no client message has been
+// read for idleTimeout. A maxDuration or idleTimeout of 0 never ends the session
+func waitForSessionEnd(errC <-chan error, maxDuration time.Duration, idleTimeout time.Duration, lastClientRead func() time.Time) error {
+	// a nil channel never receives
+	var maxDurationC <-chan time.Time
+	if maxDuration > 0 {
+		timer := time.NewTimer(maxDuration)
+		defer timer.Stop()
+		maxDurationC = timer.C
+	}
+
+	var idleTimer *time.Timer
+	var idleC <-chan time.Time
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+	}
+
+	for {
+		select {
+		case err := <-errC:
+			return err
+		case <-maxDurationC:
+			return ErrMaxSessionDuration
+		case <-idleC:
+			// the client sent messages since the timer was set, wait for the rest of the timeout
+			idle := time.Since(lastClientRead())
+			if idle < idleTimeout {
+				idleTimer.Reset(idleTimeout - idle)
+				continue
+			}
+			return ErrClientIdle
+		}
+	}
+}
+
+// closeStatusForErr returns the close code to end the client connection with if err is a session limit
+func closeStatusForErr(err error) (websocket.StatusCode, bool) {
+	if errors.Is(err, ErrMaxSessionDuration) {
+		return StatusMaxSessionDuration, true
+	}
+	if errors.Is(err, ErrClientIdle) {
+		return StatusClientIdle, true
+	}
+	return -1, false
+}
@@ -0,0 +1,128 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/websocketproxy"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxSessionDurationIsClampedToCap(t *testing.T) {
+	t.Setenv(config.MaxSessionDurationInSecs, "60")
+	t.Setenv(config.MaxSessionDurationCapInSecs, "300")
+	config.Once = sync.Once{}
+
+	duration := func(target string) time.Duration {
+		d, err := getMaxSessionDuration(httptest.NewRequest("GET", target, nil))
+		assert.Nil(t, err)
+		return d
+	}
+
+	assert.Equal(t, time.Minute, duration("/connect"))
+	assert.Equal(t, 10*time.Second, duration("/connect?maxSessionDurationInSecs=10"))
+	assert.Equal(t, 5*time.Minute, duration("/connect?maxSessionDurationInSecs=1000"))
+
+	for _, invalid := range []string{"0", "-1", "forever"} {
+		_, err := getMaxSessionDuration(httptest.NewRequest("GET", "/connect?maxSessionDurationInSecs="+invalid, nil))
+		assert.Error(t, err)
+	}
+}
+
+func TestMaxSessionDurationParamIsClampedToMaxWithoutCap(t *testing.T) {
+	t.Setenv(config.MaxSessionDurationInSecs, "60")
+	config.Once = sync.Once{}
+
+	for target, expected := range map[string]time.Duration{
+		"/connect?maxSessionDurationInSecs=1000": time.Minute,
+		"/connect?maxSessionDurationInSecs=10":   10 * time.Second,
+	} {
+		d, err := getMaxSessionDuration(httptest.NewRequest("GET", target, nil))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, d, target)
+	}
+}
+
+func TestMaxSessionDurationCapAppliesWithoutDefault(t *testing.T) {
+	t.Setenv(config.MaxSessionDurationCapInSecs, "300")
+	config.Once = sync.Once{}
+
+	d, err := getMaxSessionDuration(httptest.NewRequest("GET", "/connect", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Minute, d)
+}
+
+func TestWaitForSessionEndReturnsProxyErrors(t *testing.T) {
+	expectedErr := errors.New("read error")
+	errC := make(chan error, 1)
+	errC <- expectedErr
+
+	err := waitForSessionEnd(errC, time.Hour, time.Hour, time.Now)
+	assert.Equal(t, expectedErr, err)
+	_, isLimit := closeStatusForErr(err)
+	assert.False(t, isLimit)
+}
+
+func TestWaitForSessionEndEnforcesMaxDuration(t *testing.T) {
+	err := waitForSessionEnd(make(chan error), 10*time.Millisecond, 0, time.Now)
+	assert.ErrorIs(t, err, ErrMaxSessionDuration)
+
+	code, isLimit := closeStatusForErr(err)
+	assert.True(t, isLimit)
+	assert.Equal(t, StatusMaxSessionDuration, code)
+	assert.Equal(t, MaxDurationReached, (&ElementData{}).proxyResultFromErr(err))
+}
+
+func TestWaitForSessionEndEnforcesClientIdleTimeout(t *testing.T) {
+	var lastRead atomic.Int64
+	lastRead.Store(time.Now().UnixNano())
+	lastClientRead := func() time.Time {
+		return time.Unix(0, lastRead.Load())
+	}
+
+	// the client keeps sending messages for a while before going quiet
+	stop := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lastRead.Store(time.Now().UnixNano())
+			case <-stop:
+				return
+			}
+		}
+	}()
+	time.AfterFunc(60*time.Millisecond, func() {
+		close(stop)
+	})
+
+	start := time.Now()
+	err := waitForSessionEnd(make(chan error), 0, 30*time.Millisecond, lastClientRead)
+	assert.ErrorIs(t, err, ErrClientIdle)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	code, isLimit := closeStatusForErr(err)
+	assert.True(t, isLimit)
+	assert.Equal(t, StatusClientIdle, code)
+	assert.Equal(t, ClientIdleTimeout, (&ElementData{}).proxyResultFromErr(err))
+}
+
+func TestProxyResultFromTimeoutErrors(t *testing.T) {
+	pqe := &ElementData{R: httptest.NewRequest("GET", "/connect", nil)}
+	wrap := func(err error) error {
+		return fmt.Errorf("%w of 1s on chrome connection: %w", err, context.DeadlineExceeded)
+	}
+
+	assert.Equal(t, ReadIdleTimeout, pqe.proxyResultFromErr(wrap(websocketproxy.ErrReadIdleTimeout)))
+	assert.Equal(t, WriteTimeout, pqe.proxyResultFromErr(wrap(websocketproxy.ErrWriteTimeout)))
+	assert.Equal(t, Throttled, pqe.proxyResultFromErr(wrap(websocketproxy.ErrLimiterWaitTimeout)))
+	assert.Equal(t, SessionTimedOut, pqe.proxyResultFromErr(context.DeadlineExceeded))
+}
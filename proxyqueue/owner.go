@@ -0,0 +1,22 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/jwtauth"
+	"chromium-websocket-proxy/tenant"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// getOwner identifies the caller of r by its tenant, or by the JWT subject or the access token of callers without a
+// tenant. Access tokens are hashed so that they are never kept in sessions or pins
+func getOwner(r *http.Request) string {
+	if t := tenant.FromContext(r.Context()); t != nil {
+		return t.Name
+	}
+	if claims := jwtauth.FromContext(r.Context()); claims != nil && len(claims.Subject) > 0 {
+		return "jwt:" + claims.Subject
+	}
+	sum := sha256.Sum256([]byte(tenant.GetRequestToken(r)))
+	return "token:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,124 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/cdpmessage"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/websocketproxy"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/target"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// SessionIdParam is the connect param page connections use to present the id of the session owning the page
+const SessionIdParam = "sessionId"
+
+// ErrSessionNotFound is returned by ProxyPage when no session with the id is running, or it belongs to another tenant,
+// JWT subject or access token
+var ErrSessionNotFound = errors.New("no running session with the given id")
+
+// ErrTargetNotOwned is returned by ProxyPage when the target is not in the browser, or browser context, of the session
+var ErrTargetNotOwned = errors.New("target does not belong to the session")
+
+// ErrPageConnectionFailed is returned by ProxyPage when the target could not be connected to
+var ErrPageConnectionFailed = errors.New("unable to connect to the target")
+
+// ProxyPage proxies a connection to the target with targetID, in the browser used by the running session with
+// sessionId. The connection ends with its session. Errors are only returned before the websocket is accepted
+func (pq *ProxyQueue) ProxyPage(w http.ResponseWriter, r *http.Request, sessionId uuid.UUID, targetID target.ID) error {
+	log := logger.Get()
+
+	session, exists := pq.sessions.get(sessionId)
+	if !exists || getOwner(r) != session.eld.Owner {
+		return ErrSessionNotFound
+	}
+
+	crm, err := chromePoolGet().GetInstanceBySessionId(sessionId)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+
+	info, err := crm.GetTargetInfo(targetID)
+	if err != nil || !ownsTarget(info, session.browserContextID) {
+		return ErrTargetNotOwned
+	}
+
+	// page connections end with their session
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-session.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	chromeConn, _, err := websocketDial(ctx, crm.PageDebugUrl(targetID), nil)
+	if err != nil {
+		log.Error().Err(err).Ctx(r.Context()).Msg("unable to connect to page target")
+		return errors.Join(ErrPageConnectionFailed, err)
+	}
+	readLimit := int64(config.Get().GetProxyQueueConfig().ReadLimitInBytes)
+	chromeConn.SetReadLimit(readLimit)
+	defer chromeConn.CloseNow()
+
+	clientConn, err := websocketAccept(w, r, nil)
+	if err != nil {
+		log.Error().Ctx(r.Context()).Msg("unable to accept page connection")
+		return nil
+	}
+	clientConn.SetReadLimit(readLimit)
+	defer clientConn.CloseNow()
+
+	var isolation *cdpmessage.BrowserContextIsolation
+	if len(session.browserContextID) > 0 {
+		isolation = cdpmessage.NewBrowserContextIsolation(session.browserContextID, crm)
+	}
+	clientWs, chromeWs := session.eld.newWebsocketProxies(clientConn, ctx, chromeConn, ctx, isolation)
+
+	// buffered so that the second loop to fail does not block once the connection has ended
+	errC := make(chan error, 2)
+	proxyLoop := func(wp *websocketproxy.WebsocketProxy) {
+		for {
+			if err := wp.Proxy(); err != nil {
+				errC <- err
+				return
+			}
+		}
+	}
+	go proxyLoop(clientWs)
+	go proxyLoop(chromeWs)
+
+	log.Info().Ctx(r.Context()).Str("targetId", string(targetID)).Msg("proxy page connection initialized")
+	err = <-errC
+	log.Info().Ctx(r.Context()).Err(err).Str("targetId", string(targetID)).Msg("page connection finished")
+	return nil
+}
+
+// ownsTarget returns true if info is a target a session can connect to. Sessions isolated in browserContextID may
+// only connect to the targets of their browser context
+func ownsTarget(info *target.Info, browserContextID cdp.BrowserContextID) bool {
+	if info == nil {
+		return false
+	}
+	return len(browserContextID) == 0 || info.BrowserContextID == browserContextID
+}
+
+// GetSessionId returns the session id presented by a page connection in the sessionId connect param or the
+// X-Session-Id header
+func GetSessionId(r *http.Request) (uuid.UUID, error) {
+	id := r.URL.Query().Get(SessionIdParam)
+	if len(id) == 0 {
+		id = r.Header.Get(SessionIdHeader)
+	}
+	sessionId, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, errors.New(fmt.Sprintf("req.query['%s'] must be the %s of a running session", SessionIdParam, SessionIdHeader))
+	}
+	return sessionId, nil
+}
@@ -0,0 +1,183 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/tenant"
+	"chromium-websocket-proxy/test/mocks/chromemock"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"context"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/target"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nhooyr.io/websocket"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPageTestSession registers a running session using crm, as proxy does once the client is accepted
+func newPageTestSession(t *testing.T, pq *ProxyQueue, crm *chromemock.MockChrome, browserContextID cdp.BrowserContextID) *ElementData {
+	r := httptest.NewRequest("GET", "/connect", nil)
+	r = r.WithContext(context.WithValue(r.Context(), logger.SessionIdTrackingKey, uuid.New()))
+	eld, err := NewElementData(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+
+	crm.SetSessionId(eld.SessionId)
+	pq.sessions.add(eld.SessionId)
+	pq.sessions.setPageOwner(eld, browserContextID)
+	return eld
+}
+
+func usePool(t *testing.T, instances ...chrome.IChrome) {
+	pool := chromepoolmock.NewMock()
+	pool.SetInstances(instances)
+	chromePoolGet = func() chromepool.IChromePool {
+		return pool
+	}
+	t.Cleanup(func() {
+		chromePoolGet = chromepool.Get
+	})
+}
+
+func TestProxyPageRejectsTargetsOfOtherSessions(t *testing.T) {
+	config.Once = sync.Once{}
+	pq := &ProxyQueue{}
+
+	crm := chromemock.NewMock()
+	crm.AddTarget(&target.Info{TargetID: "own", BrowserContextID: "session-context"})
+	crm.AddTarget(&target.Info{TargetID: "other", BrowserContextID: "other-context"})
+	usePool(t, crm)
+	eld := newPageTestSession(t, pq, crm, "session-context")
+
+	proxyPage := func(r *http.Request, sessionId uuid.UUID, targetID target.ID) error {
+		return pq.ProxyPage(httptest.NewRecorder(), r, sessionId, targetID)
+	}
+	r := httptest.NewRequest("GET", "/devtools/page/other", nil)
+
+	assert.ErrorIs(t, proxyPage(r, uuid.New(), "own"), ErrSessionNotFound)
+	assert.ErrorIs(t, proxyPage(r, eld.SessionId, "other"), ErrTargetNotOwned)
+	assert.ErrorIs(t, proxyPage(r, eld.SessionId, "unknown"), ErrTargetNotOwned)
+
+	// sessions of a tenant can only be joined by the tenant
+	tenantR := r.WithContext(tenant.WithTenant(r.Context(), &tenant.Tenant{Name: "acme"}))
+	assert.ErrorIs(t, proxyPage(tenantR, eld.SessionId, "own"), ErrSessionNotFound)
+
+	pq.sessions.remove(eld.SessionId)
+	assert.ErrorIs(t, proxyPage(r, eld.SessionId, "own"), ErrSessionNotFound)
+}
+
+func TestProxyPageRejectsSessionsOfOtherJwtSubjects(t *testing.T) {
+	config.Once = sync.Once{}
+	pq := &ProxyQueue{}
+
+	crm := chromemock.NewMock()
+	crm.AddTarget(&target.Info{TargetID: "own", BrowserContextID: "session-context"})
+	usePool(t, crm)
+
+	withSubject := func(r *http.Request, sub string) *http.Request {
+		return r.WithContext(jwtauth.WithClaims(r.Context(), &jwtauth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: sub}}))
+	}
+	eld := newPageTestSession(t, pq, crm, "session-context")
+	eld.Owner = getOwner(withSubject(eld.R, "alice"))
+
+	r := httptest.NewRequest("GET", "/devtools/page/own", nil)
+	for _, other := range []*http.Request{withSubject(r, "bob"), r, httptest.NewRequest("GET", "/devtools/page/own?accessToken=server-token", nil)} {
+		assert.ErrorIs(t, pq.ProxyPage(httptest.NewRecorder(), other, eld.SessionId, "own"), ErrSessionNotFound)
+	}
+
+	// the owner passes the ownership checks and only fails to dial the mock target
+	err := pq.ProxyPage(httptest.NewRecorder(), withSubject(r, "alice"), eld.SessionId, "own")
+	assert.ErrorIs(t, err, ErrPageConnectionFailed)
+}
+
+func TestOwnsTarget(t *testing.T) {
+	assert.False(t, ownsTarget(nil, ""))
+	assert.True(t, ownsTarget(&target.Info{BrowserContextID: "default"}, ""))
+	assert.True(t, ownsTarget(&target.Info{BrowserContextID: "session"}, "session"))
+	assert.False(t, ownsTarget(&target.Info{BrowserContextID: "default"}, "session"))
+}
+
+func TestGetSessionId(t *testing.T) {
+	sessionId := uuid.New()
+
+	id, err := GetSessionId(httptest.NewRequest("GET", "/devtools/page/a?sessionId="+sessionId.String(), nil))
+	assert.Nil(t, err)
+	assert.Equal(t, sessionId, id)
+
+	r := httptest.NewRequest("GET", "/devtools/page/a", nil)
+	r.Header.Set(SessionIdHeader, sessionId.String())
+	id, err = GetSessionId(r)
+	assert.Nil(t, err)
+	assert.Equal(t, sessionId, id)
+
+	_, err = GetSessionId(httptest.NewRequest("GET", "/devtools/page/a?sessionId=abc", nil))
+	assert.ErrorContains(t, err, SessionIdParam)
+}
+
+func TestProxyPageEndsWithSession(t *testing.T) {
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+	pq := &ProxyQueue{}
+
+	// the page target echoes every message
+	pageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/devtools/page/page-1", r.URL.Path)
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		for {
+			msgT, msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			_ = conn.Write(r.Context(), msgT, msg)
+		}
+	}))
+	defer pageServer.Close()
+	pageUrl, _ := url.Parse(pageServer.URL)
+	port, _ := strconv.Atoi(pageUrl.Port())
+
+	crm := chromemock.NewMock()
+	crm.SetPort(port)
+	crm.AddTarget(&target.Info{TargetID: "page-1"})
+	usePool(t, crm)
+	eld := newPageTestSession(t, pq, crm, "")
+
+	proxyDone := make(chan error, 1)
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyDone <- pq.ProxyPage(w, r, eld.SessionId, "page-1")
+	}))
+	defer proxyServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+proxyServer.URL[len("http"):]+"/devtools/page/page-1", nil)
+	assert.Nil(t, err)
+	defer conn.CloseNow()
+
+	assert.Nil(t, conn.Write(ctx, websocket.MessageText, []byte(`{"id":1,"method":"Page.enable"}`)))
+	_, msg, err := conn.Read(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1,"method":"Page.enable"}`, string(msg))
+
+	pq.sessions.remove(eld.SessionId)
+	select {
+	case err := <-proxyDone:
+		assert.Nil(t, err)
+	case <-ctx.Done():
+		t.Fatal("page connection did not end with its session")
+	}
+}
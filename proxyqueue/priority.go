@@ -0,0 +1,111 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
+	"chromium-websocket-proxy/tenant"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// queueEpoch keeps priority keys small so they do not lose precision
+var queueEpoch = time.Now()
+
+// elementHeap is a container/heap ordered so that the session to proxy next is at index 0.
+// Sessions are ordered by priority, with every session gaining PRIORITY_AGING_PER_SEC priority for each second it
+// waits so that low priority sessions are never starved. Because every session ages at the same rate, the order only
+// depends on the priority and enqueue time, which is precomputed as priorityKey
+type elementHeap []*ElementData
+
+func (h elementHeap) Len() int {
+	return len(h)
+}
+
+func (h elementHeap) Less(i, j int) bool {
+	if h[i].priorityKey == h[j].priorityKey {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].priorityKey > h[j].priorityKey
+}
+
+func (h elementHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *elementHeap) Push(x any) {
+	pqe := x.(*ElementData)
+	pqe.index = len(*h)
+	*h = append(*h, pqe)
+}
+
+func (h *elementHeap) Pop() any {
+	old := *h
+	n := len(old)
+	pqe := old[n-1]
+	old[n-1] = nil
+	pqe.index = -1
+	*h = old[:n-1]
+	return pqe
+}
+
+// position returns the 1-based position pqe will be proxied at
+func (h elementHeap) position(pqe *ElementData) int {
+	position := 1
+	for i := range h {
+		if h[i] != pqe && h.Less(i, pqe.index) {
+			position++
+		}
+	}
+	return position
+}
+
+func priorityKey(priority float32, enqueuedAt time.Time) float64 {
+	aging := config.Get().GetProxyQueueConfig().PriorityAgingPerSec
+	return float64(priority) - aging*enqueuedAt.Sub(queueEpoch).Seconds()
+}
+
+// getSessionPriority returns the priority requested with the priority connect param. Requests with an access token
+// from SERVER_ACCESS_TOKEN_TIERS default to the tier's priority and cannot request more. The maxPriority claim of a
+// JWT lets the session request up to the claim, but no more than its tier. Everything else defaults to 0 and cannot
+// request more than DEFAULT_MAX_SESSION_PRIORITY, which is MAX_SESSION_PRIORITY unless it is configured. Priorities are clamped to MAX_SESSION_PRIORITY
+func getSessionPriority(r *http.Request) (float32, error) {
+	conf := config.Get()
+	pqConf := conf.GetProxyQueueConfig()
+	maxPriority := pqConf.MaxSessionPriority
+	upper := math.Min(pqConf.DefaultMaxSessionPriority, maxPriority)
+	priority := float64(0)
+
+	tierPriority, isTier := conf.GetServerConfig().AccessTokenTiers[tenant.GetRequestToken(r)]
+	if isTier {
+		upper = tierPriority
+		priority = tierPriority
+	}
+
+	if claims := jwtauth.FromContext(r.Context()); claims != nil && claims.MaxPriority != nil {
+		if isTier {
+			upper = math.Min(upper, *claims.MaxPriority)
+		} else {
+			upper = math.Min(maxPriority, *claims.MaxPriority)
+		}
+		priority = math.Min(priority, upper)
+	}
+
+	p := r.URL.Query().Get("priority")
+	if len(p) == 0 {
+		return float32(priority), nil
+	}
+
+	requested, err := strconv.ParseFloat(p, 64)
+	if err != nil || math.IsNaN(requested) {
+		return 0, errors.New(fmt.Sprintf("req.query['priority'] must be a number, received %s", p))
+	}
+
+	lower := math.Min(-maxPriority, upper)
+	return float32(math.Max(lower, math.Min(requested, upper))), nil
+}
@@ -1,18 +1,27 @@
 package proxyqueue
 
 import (
+	"chromium-websocket-proxy/autoscaler"
+	"chromium-websocket-proxy/cdpmessage"
 	"chromium-websocket-proxy/chromepool"
 	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
 	"chromium-websocket-proxy/logger"
 	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/recorder"
+	"chromium-websocket-proxy/tenant"
 	"chromium-websocket-proxy/websocketproxy"
-	"container/list"
+	"container/heap"
 	"context"
 	"errors"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
 	"github.com/google/uuid"
-	"golang.org/x/time/rate"
+	"math"
 	"net/http"
 	"nhooyr.io/websocket"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,9 +30,15 @@ import (
 type ProxyQueue struct {
 	queueTicker      *time.Ticker
 	throughputTicker *time.Ticker
-	list             *list.List
-	listMux          sync.RWMutex
+	queue            elementHeap
+	queueMux         sync.RWMutex
+	seq              uint64
 	tickStopC        chan bool
+	scaleDown        *scaleDownController
+	scaler           autoscaler.IScaler
+	sessions         activeSessions
+	parked           parkedSessions
+	draining         bool
 }
 
 type ElementData struct {
@@ -31,19 +46,105 @@ type ElementData struct {
 	R                *http.Request
 	C                chan ProxyResult
 	ChromeOptions    config.ChromeConfigOptions
+	Policy           *cdpmessage.Policy
 	PriorityModifier float32
+	SessionId        uuid.UUID
+	Tenant           *tenant.Tenant
+	Claims           *jwtauth.Claims
+	Owner            string
+	SessionTimeLimit time.Duration
+	Target           ConnectTarget
+	PageUrl          string
+	StickySession    string
+	EnqueuedAt       time.Time
+	Retries          int
+	QueuePosition    int
+	accepted         bool
+	recorder         *recorder.Recorder
+	index            int
+	seq              uint64
+	priorityKey      float64
 }
 
+// QueuedSession describes a session waiting in the queue
+type QueuedSession struct {
+	SessionId         string    `json:"sessionId"`
+	Profile           string    `json:"profile"`
+	EnqueuedAt        time.Time `json:"enqueuedAt"`
+	Retries           int       `json:"retries"`
+	Position          int       `json:"position"`
+	EstimatedWaitSecs float64   `json:"estimatedWaitSecs"`
+}
+
+// QueuePositionHeader is set on the upgrade response to the position the session had when it was queued
+const QueuePositionHeader = "X-Queue-Position"
+
+// SessionIdHeader is set on the upgrade response to the id of the session, which page connections must present
+const SessionIdHeader = "X-Session-Id"
+
 type ProxyResult string
 
 const (
-	Succeeded         ProxyResult = "Succeeded"
-	ConnectionError   ProxyResult = "ConnectionError"
-	SessionTimedOut   ProxyResult = "SessionTimedOut"
-	UnableToGetChrome ProxyResult = "UnableToGetChrome" // retryable status
-	Failed            ProxyResult = "Failed"
+	Succeeded          ProxyResult = "Succeeded"
+	ConnectionError    ProxyResult = "ConnectionError"
+	SessionTimedOut    ProxyResult = "SessionTimedOut"
+	UnableToGetChrome  ProxyResult = "UnableToGetChrome" // retryable status
+	Failed             ProxyResult = "Failed"
+	Rejected           ProxyResult = "Rejected" // the session was shed from the queue before it was proxied
+	MaxDurationReached ProxyResult = "MaxDurationReached"
+	ClientIdleTimeout  ProxyResult = "ClientIdleTimeout"
+	ReadIdleTimeout    ProxyResult = "ReadIdleTimeout"
+	WriteTimeout       ProxyResult = "WriteTimeout"
+	Throttled          ProxyResult = "Throttled"          // a message waited longer than PROXY_LIMITER_WAIT_TIMEOUT_IN_SECS
+	ResumeExpired      ProxyResult = "ResumeExpired"      // the client disconnected and did not resume the session in time
+	ClientDetached     ProxyResult = "ClientDetached"     // the resumed client disconnected, the session is parked again
+	PinLimitReached    ProxyResult = "PinLimitReached"    // the tenant has its maximum number of pinned browsers
+	PinProfileMismatch ProxyResult = "PinProfileMismatch" // the sticky session is pinned to a browser with another profile
+	QueueWaitExceeded  ProxyResult = "QueueWaitExceeded"  // the session was requeued after MAX_QUEUE_WAIT_IN_SECS
+)
+
+// ShedReason is why a session was rejected before it could be proxied
+type ShedReason string
+
+const (
+	QueueFull        ShedReason = "QueueFull"
+	QueueWaitTimeout ShedReason = "QueueWaitTimeout"
+	ServerDraining   ShedReason = "ServerDraining"
+	TenantQueueQuota ShedReason = "TenantQueueQuota"
 )
 
+var shedReasons = []ShedReason{
+	QueueFull,
+	QueueWaitTimeout,
+	ServerDraining,
+	TenantQueueQuota,
+}
+
+// ErrQueueFull is returned by AddToList when the queue already holds MAX_QUEUE_LENGTH sessions
+var ErrQueueFull = errors.New(fmt.Sprintf("queue is full, %s has been reached", config.MaxQueueLength))
+
+// ErrServerDraining is returned by AddToList once the server has started shutting down
+var ErrServerDraining = errors.New("server is shutting down")
+
+var proxyResults = []ProxyResult{
+	Succeeded,
+	ConnectionError,
+	SessionTimedOut,
+	UnableToGetChrome,
+	Failed,
+	Rejected,
+	MaxDurationReached,
+	ClientIdleTimeout,
+	ReadIdleTimeout,
+	WriteTimeout,
+	Throttled,
+	ResumeExpired,
+	ClientDetached,
+	PinLimitReached,
+	PinProfileMismatch,
+	QueueWaitExceeded,
+}
+
 var once = sync.Once{}
 var websocketAccept = websocket.Accept
 var websocketDial = websocket.Dial
@@ -54,11 +155,41 @@ var pq *ProxyQueue
 
 func Get() *ProxyQueue {
 	once.Do(func() {
+		conf := config.Get().GetProxyQueueConfig()
 		pq = &ProxyQueue{
-			list:             list.New(),
 			tickStopC:        make(chan bool),
 			queueTicker:      time.NewTicker(250 * time.Millisecond),
 			throughputTicker: time.NewTicker(1000 * time.Millisecond),
+			scaleDown: newScaleDownController(
+				conf.ScaleDownWindowInSecs,
+				conf.ScaleDownCooldownInSecs,
+			),
+		}
+
+		// the strategy and schedule are checked by config.Validate, fall back to throughput if they are not
+		scaler, err := autoscaler.New(conf, &metrics.Get().InMemory)
+		if err != nil {
+			log := logger.Get()
+			log.Err(err).Msg("unable to create scaler, falling back to throughput")
+			conf.ScalerStrategy = config.ScalerStrategyThroughput
+			scaler, _ = autoscaler.New(conf, &metrics.Get().InMemory)
+		}
+		pq.scaler = scaler
+
+		// initialize a counter for every result so that each series exists before its first increment
+		for _, res := range proxyResults {
+			metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyResults, float32(0), []metrics.Label{
+				{Name: metrics.ProfileLabel, Value: metrics.DefaultProfileLabelValue},
+				{Name: metrics.TenantLabel, Value: metrics.DefaultTenantLabelValue},
+				{Name: metrics.ResultLabel, Value: string(res)},
+			})
+		}
+		for _, reason := range shedReasons {
+			metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyShed, float32(0), []metrics.Label{
+				{Name: metrics.ProfileLabel, Value: metrics.DefaultProfileLabelValue},
+				{Name: metrics.TenantLabel, Value: metrics.DefaultTenantLabelValue},
+				{Name: metrics.ShedReasonLabel, Value: string(reason)},
+			})
 		}
 		go pq.onTick()
 	})
@@ -84,220 +215,785 @@ func NewElementData(
 		return nil, errors.New("unable to create options for chrome startup")
 	}
 
+	policy, err := cdpmessage.GetPolicyForConnection(r.URL.Query().Get("policy"))
+	if err != nil {
+		return nil, err
+	}
+
+	priority, err := getSessionPriority(r)
+	if err != nil {
+		return nil, err
+	}
+
+	maxDuration, err := getMaxSessionDuration(r)
+	if err != nil {
+		return nil, err
+	}
+
+	connectTarget, pageUrl, err := getConnectTarget(r)
+	if err != nil {
+		return nil, err
+	}
+
+	stickySession, err := getStickySessionKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t := tenant.FromContext(r.Context())
+	claims := jwtauth.FromContext(r.Context())
+
 	return &ElementData{
-		W:             w,
-		R:             r,
-		C:             make(chan ProxyResult, 0),
-		ChromeOptions: co,
+		W:                w,
+		R:                r,
+		C:                make(chan ProxyResult, 1),
+		ChromeOptions:    co,
+		Policy:           policy,
+		PriorityModifier: priority,
+		SessionId:        r.Context().Value(logger.SessionIdTrackingKey).(uuid.UUID),
+		Tenant:           t,
+		Claims:           claims,
+		Owner:            getOwner(r),
+		SessionTimeLimit: sessionTimeLimit(maxDuration, t.SessionTimeLimit(), claims.MaxSessionDuration()),
+		Target:           connectTarget,
+		PageUrl:          pageUrl,
+		StickySession:    stickySession,
 	}, nil
 }
 
-func (pq *ProxyQueue) AddToList(el *ElementData) *list.Element {
-	metrics.Get().InMemory.IncCounter(metrics.ProxyQueue, float32(1))
-	metrics.Get().Remote.IncCounter(metrics.ProxyQueue, float32(1))
+// sessionTimeLimit returns the shortest of the limits that are set, or 0 if none are
+func sessionTimeLimit(limits ...time.Duration) time.Duration {
+	shortest := time.Duration(0)
+	for _, limit := range limits {
+		if limit > 0 && (shortest == 0 || limit < shortest) {
+			shortest = limit
+		}
+	}
+	return shortest
+}
+
+// Accepted returns true once the client websocket has been accepted. Only read it after receiving from C
+func (pqe *ElementData) Accepted() bool {
+	return pqe.accepted
+}
+
+// metricLabels returns the labels used to tag remote metrics for this session
+func (pqe *ElementData) metricLabels() []metrics.Label {
+	profile := pqe.ChromeOptions.Profile
+	if len(profile) == 0 {
+		profile = metrics.DefaultProfileLabelValue
+	}
+	tenantName := metrics.DefaultTenantLabelValue
+	if pqe.Tenant != nil {
+		tenantName = pqe.Tenant.Name
+	}
+	return []metrics.Label{
+		{Name: metrics.ProfileLabel, Value: profile},
+		{Name: metrics.TenantLabel, Value: tenantName},
+	}
+}
+
+// resultMetricLabels returns metricLabels with the result of the proxy session
+func (pqe *ElementData) resultMetricLabels(res ProxyResult) []metrics.Label {
+	return append(pqe.metricLabels(), metrics.Label{Name: metrics.ResultLabel, Value: string(res)})
+}
 
-	pq.listMux.Lock()
-	defer pq.listMux.Unlock()
-	return pq.list.PushBack(el)
+// AddToList queues el, or sheds it with ErrQueueFull if the queue already holds MAX_QUEUE_LENGTH sessions
+func (pq *ProxyQueue) AddToList(el *ElementData) error {
+	maxQueueLength := config.Get().GetProxyQueueConfig().MaxQueueLength
+
+	pq.queueMux.Lock()
+	if pq.draining {
+		pq.queueMux.Unlock()
+		el.recordShed(ServerDraining)
+		return ErrServerDraining
+	}
+	if maxQueueLength > 0 && pq.queue.Len() >= maxQueueLength {
+		pq.queueMux.Unlock()
+		el.recordShed(QueueFull)
+		return ErrQueueFull
+	}
+	if !el.Tenant.Queue() {
+		pq.queueMux.Unlock()
+		el.recordShed(TenantQueueQuota)
+		return tenant.ErrQueueQuota
+	}
+	el.EnqueuedAt = time.Now()
+	el.priorityKey = priorityKey(el.PriorityModifier, el.EnqueuedAt)
+	pq.pushWLocked(el)
+	el.QueuePosition = pq.queue.position(el)
+	pq.queueMux.Unlock()
+
+	metrics.Get().InMemory.IncCounter(metrics.ProxyQueue, float32(1))
+	metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyQueue, float32(1), el.metricLabels())
+	return nil
 }
 
-func (pq *ProxyQueue) RemoveFromList(el *list.Element) {
+// RemoveFromList removes el from the queue. Returns false if el is not queued, e.g. because it is being proxied
+func (pq *ProxyQueue) RemoveFromList(el *ElementData) bool {
+	pq.queueMux.Lock()
+	if el.index < 0 || el.index >= pq.queue.Len() || pq.queue[el.index] != el {
+		pq.queueMux.Unlock()
+		return false
+	}
+	heap.Remove(&pq.queue, el.index)
+	el.Tenant.Unqueue()
+	pq.queueMux.Unlock()
+
 	metrics.Get().InMemory.IncCounter(metrics.ProxyQueue, float32(-1))
-	metrics.Get().Remote.IncCounter(metrics.ProxyQueue, float32(-1))
-	pq.listMux.Lock()
-	defer pq.listMux.Unlock()
-	pq.list.Remove(el)
+	metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyQueue, float32(-1), el.metricLabels())
+	return true
+}
+
+// Shed removes el from the queue and records it as shed for reason. Returns false if el is no longer queued
+func (pq *ProxyQueue) Shed(el *ElementData, reason ShedReason) bool {
+	if !pq.RemoveFromList(el) {
+		return false
+	}
+	el.recordShed(reason)
+	return true
+}
+
+func (pqe *ElementData) recordShed(reason ShedReason) {
+	log := logger.Get()
+	log.Warn().Ctx(pqe.R.Context()).Msg(fmt.Sprintf("shedding session: %s", reason))
+	metrics.Get().Remote.IncCounterWithLabels(
+		metrics.ProxyShed,
+		float32(1),
+		append(pqe.metricLabels(), metrics.Label{Name: metrics.ShedReasonLabel, Value: string(reason)}),
+	)
+}
+
+// StartDraining stops queuing new sessions and rejects every queued session. Sessions being proxied keep running
+func (pq *ProxyQueue) StartDraining() {
+	pq.queueMux.Lock()
+	pq.draining = true
+	pq.parked.expireAll()
+	var shed []*ElementData
+	for el := pq.popWLocked(); el != nil; el = pq.popWLocked() {
+		el.Tenant.Unqueue()
+		shed = append(shed, el)
+	}
+	pq.queueMux.Unlock()
+
+	for _, el := range shed {
+		el.recordShed(ServerDraining)
+		metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyResults, float32(1), el.resultMetricLabels(Rejected))
+		el.C <- Rejected
+	}
+}
+
+// IsDraining returns true once StartDraining has been called
+func (pq *ProxyQueue) IsDraining() bool {
+	pq.queueMux.RLock()
+	defer pq.queueMux.RUnlock()
+	return pq.draining
+}
+
+// QueueLength returns the number of sessions waiting in the queue
+func (pq *ProxyQueue) QueueLength() int {
+	pq.queueMux.RLock()
+	defer pq.queueMux.RUnlock()
+	return pq.queue.Len()
+}
+
+// ActiveSessionCount returns the number of sessions taken off the queue that have not finished
+func (pq *ProxyQueue) ActiveSessionCount() int {
+	return pq.sessions.len()
+}
+
+// WaitForActiveSessions blocks until every active session has finished or ctx is done.
+// Returns false if sessions are still running
+func (pq *ProxyQueue) WaitForActiveSessions(ctx context.Context) bool {
+	return pq.sessions.wait(ctx)
+}
+
+// CloseActiveSessions closes the client connection of every active session with code.
+// Returns the number of connections closed
+func (pq *ProxyQueue) CloseActiveSessions(code websocket.StatusCode, reason string) int {
+	return pq.sessions.closeAll(code, reason)
+}
+
+// RetryAfterSecs returns how long a shed client should wait before retrying, based on the mean proxy session time
+func (pq *ProxyQueue) RetryAfterSecs() int {
+	return int(math.Max(1, math.Ceil(averageProxyTimeSecs())))
+}
+
+// pushWLocked must be called with queueMux locked
+func (pq *ProxyQueue) pushWLocked(el *ElementData) {
+	pq.seq++
+	el.seq = pq.seq
+	heap.Push(&pq.queue, el)
+}
+
+// popWLocked returns the session to proxy next, or nil if the queue is empty. Must be called with queueMux locked
+func (pq *ProxyQueue) popWLocked() *ElementData {
+	if pq.queue.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(&pq.queue).(*ElementData)
+}
+
+// popStartableWLocked returns the session to proxy next whose tenant has less than MaxConcurrentSessions proxied,
+// counting it as started, or nil if there is none. Sessions of other tenants keep their place in the queue.
+// Must be called with queueMux locked
+func (pq *ProxyQueue) popStartableWLocked() *ElementData {
+	if pq.queue.Len() == 0 {
+		return nil
+	}
+	next := 0
+	if !pq.queue[0].Tenant.CanStart() {
+		next = -1
+		for i := 1; i < pq.queue.Len(); i++ {
+			if (next < 0 || pq.queue.Less(i, next)) && pq.queue[i].Tenant.CanStart() {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+	}
+
+	// tenants are only started with queueMux locked, so the tenant can still start the session
+	pqe := heap.Remove(&pq.queue, next).(*ElementData)
+	pqe.Tenant.Start()
+	return pqe
+}
+
+// requeue adds a session that could not get a browser back to the queue behind the next session,
+// so that it does not block sessions that can be proxied. Sessions queued longer than MAX_QUEUE_WAIT_IN_SECS are shed
+// instead, since they were being proxied when their wait timed out
+func (pq *ProxyQueue) requeue(el *ElementData) {
+	if maxQueueWait := config.Get().GetProxyQueueConfig().MaxQueueWaitInSecs; maxQueueWait > 0 && time.Since(el.EnqueuedAt) >= maxQueueWait {
+		el.Tenant.Unqueue()
+		metrics.Get().InMemory.IncCounter(metrics.ProxyQueue, float32(-1))
+		metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyQueue, float32(-1), el.metricLabels())
+		el.recordShed(QueueWaitTimeout)
+		metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyResults, float32(1), el.resultMetricLabels(QueueWaitExceeded))
+		el.C <- QueueWaitExceeded
+		return
+	}
+
+	pq.queueMux.Lock()
+	defer pq.queueMux.Unlock()
+	el.Retries++
+	if pq.queue.Len() > 0 {
+		el.priorityKey = math.Min(el.priorityKey, pq.queue[0].priorityKey)
+	}
+	pq.pushWLocked(el)
+}
+
+// GetQueuedSessions returns every queued session in the order they will be proxied
+func (pq *ProxyQueue) GetQueuedSessions() []QueuedSession {
+	apt := averageProxyTimeSecs()
+	poolLen := chromePoolGet().GetInstancePoolLen()
+
+	pq.queueMux.RLock()
+	defer pq.queueMux.RUnlock()
+
+	queue := make(elementHeap, pq.queue.Len())
+	copy(queue, pq.queue)
+
+	sort.Slice(queue, func(i, j int) bool {
+		return queue.Less(i, j)
+	})
+
+	sessions := make([]QueuedSession, 0, len(queue))
+	position := 1
+	for _, pqe := range queue {
+		sessions = append(sessions, QueuedSession{
+			SessionId:         pqe.SessionId.String(),
+			Profile:           pqe.ChromeOptions.Profile,
+			EnqueuedAt:        pqe.EnqueuedAt,
+			Retries:           pqe.Retries,
+			Position:          position,
+			EstimatedWaitSecs: estimateWaitSecs(position, poolLen, apt),
+		})
+		position++
+	}
+	return sessions
+}
+
+// estimateWaitSecs assumes every browser in the pool works through the queue in parallel,
+// each finishing a session every apt seconds
+func estimateWaitSecs(position int, poolLen int, apt float64) float64 {
+	if poolLen < 1 {
+		poolLen = 1
+	}
+	rounds := (position + poolLen - 1) / poolLen
+	return float64(rounds) * apt
+}
+
+// averageProxyTimeSecs returns the mean proxy session time, or DEFAULT_PROXY_TIME_IN_SECS if no session has
+// finished recently
+func averageProxyTimeSecs() float64 {
+	return autoscaler.AverageProxyTimeSecs(
+		&metrics.Get().InMemory,
+		config.Get().GetProxyQueueConfig().DefaultProxyTimeInSecs,
+	)
 }
 
 func (pq *ProxyQueue) onTick() {
 	log := logger.Get()
 	cp := chromePoolGet()
-	conf := config.Get()
 	m := metrics.Get()
 
 	for {
 		select {
 		case <-pq.queueTicker.C:
-			pq.listMux.RLock()
-			lLen := pq.list.Len()
-			pq.listMux.RUnlock()
+			pq.queueMux.RLock()
+			lLen := pq.queue.Len()
+			pq.queueMux.RUnlock()
 
-			if lLen == 0 || !cp.HasIdleChromeInstance() {
+			// sessions pinned to a browser do not need an idle one
+			if lLen == 0 || (!cp.HasIdleChromeInstance() && !cp.HasAvailablePin()) {
 				continue
 			}
 
 			go func() {
-				pq.listMux.Lock()
-				el := pq.list.Front()
-				if el == nil {
-					pq.listMux.Unlock()
-					return
-				}
-				pqe := pq.list.Remove(el).(*ElementData)
-				pq.listMux.Unlock()
+				// sessions of tenants with MaxConcurrentSessions proxied already are skipped
+				pq.queueMux.Lock()
+				pqe := pq.popStartableWLocked()
+				pq.queueMux.Unlock()
 				if pqe == nil {
 					return
 				}
 
 				log.Info().Ctx(pqe.R.Context()).Msg("attempting proxy session")
-				res := pqe.proxy()
-
-				// add back to list
-				if res == UnableToGetChrome {
-					pq.listMux.Lock()
-
-					front := pq.list.Front()
-					if front != nil {
-						pq.list.InsertAfter(pqe, front)
-						log.Info().Ctx(pqe.R.Context()).Msg("pushing session to after")
-					} else {
-						pq.list.PushFront(pqe)
-					}
-					pq.listMux.Unlock()
+				res := pqe.proxy(&pq.sessions, &pq.parked)
+
+				// add back to queue, unless the server is shutting down
+				if res == UnableToGetChrome && pq.IsDraining() {
+					pqe.Tenant.Finish()
+					pqe.recordShed(ServerDraining)
+					m.Remote.IncCounterWithLabels(metrics.ProxyResults, float32(1), pqe.resultMetricLabels(Rejected))
+					pqe.C <- Rejected
+				} else if res == UnableToGetChrome {
+					pqe.Tenant.Requeue()
+					pq.requeue(pqe)
+					log.Info().Ctx(pqe.R.Context()).Msg("pushing session to after")
 				} else {
+					pqe.Tenant.Finish()
+					m.Remote.IncCounterWithLabels(metrics.ProxyResults, float32(1), pqe.resultMetricLabels(res))
 					pqe.C <- res
 				}
 			}()
 		case <-pq.throughputTicker.C:
-			// do not calculate throughput for scale-up if at capacity
-			go func() {
-				if cp.IsPoolAtCapacity() {
-					return
-				}
+			go pq.onThroughputTick()
+		case <-pq.tickStopC:
+			return
+		}
+	}
+}
 
-				if pq.list.Len() == 0 {
-					return
-				}
+// onThroughputTick asks the SCALER_STRATEGY scaler whether to scale the pool. Scale-ups happen immediately, idle
+// browsers are only retired once the scaler has asked for a scale-down for SCALE_DOWN_WINDOW_IN_SECS.
+// Browsers for profiles in demand are kept warm on every tick
+func (pq *ProxyQueue) onThroughputTick() {
+	log := logger.Get()
+	cp := chromePoolGet()
+	conf := config.Get()
+	now := time.Now()
 
-				// Formula and Calculation of Throughput
-				// can be calculated using the following formula:
-				//
-				// tp = qp/apt
-				// where:
-				// qp = queued proxy session / chrome instances
-				// apt = average proxy session time to complete
-				// tp = throughput
-
-				// Get current mean count of in queue & working
-				cag, err := m.InMemory.GetLastCounterAggregate(metrics.ProxyQueue)
-				if err != nil {
-					log.Err(err).Msg("GetLastCounterAggregate")
-				}
-				if cag == nil || cag.Count == 0 {
-					return
-				}
+	cp.MaintainWarmInstances()
+	cp.ReleaseIdlePins()
 
-				// Get current mean time to complete a proxy
-				sag, _ := m.InMemory.GetLastSampleAggregate(metrics.ProxyTimeSecs)
+	pq.queueMux.RLock()
+	lLen := pq.queue.Len()
+	pq.queueMux.RUnlock()
 
-				// sag may be nil if we just received a load after a period of inactivity.
-				// In this case, set apt equal to a config value
-				var apt float64
-				if sag == nil {
-					apt = float64(25)
-				} else {
-					apt = sag.Mean()
-				}
+	instances := cp.GetInstances()
+	busy := 0
+	for _, c := range instances {
+		if !c.IsIdle() {
+			busy++
+		}
+	}
 
-				l := float64(cp.GetInstancePoolLen())
-				qp := float64(cag.Count) / l
-				tp := qp / apt
+	decision := pq.scaler.Evaluate(autoscaler.State{
+		QueueLength:   lLen,
+		PoolLen:       len(instances),
+		BusyInstances: busy,
+		Now:           now,
+	})
 
-				if tp <= conf.GetProxyQueueConfig().ThroughputScaleUpThreshold {
-					return
-				}
-				log.Info().Float64("throughput", tp).Msg("scaling up chrome pool")
+	// do not scale up if at capacity
+	if decision == autoscaler.ScaleUp && !cp.IsPoolAtCapacity() {
+		log.Info().Str("strategy", conf.GetProxyQueueConfig().ScalerStrategy).Msg("scaling up chrome pool")
 
-				err = cp.CreateNewInstance(conf.GetChromeConfig().DefaultOptions)
-				if err != nil {
-					log.Err(err).Msg("error scaling up")
-				}
-			}()
-		case <-pq.tickStopC:
+		err := cp.CreateNewInstance(conf.GetChromeConfig().DefaultOptions)
+		if err != nil {
+			log.Err(err).Msg("error scaling up")
 			return
 		}
+		pq.scaleDown.scaled(now)
+		return
+	}
+
+	isLow := decision == autoscaler.ScaleDown
+	if !conf.GetProxyQueueConfig().EnableScaleDown || !pq.scaleDown.observe(isLow, lLen == 0, now) {
+		return
+	}
+
+	if cp.RetireIdleInstance() {
+		log.Info().Str("strategy", conf.GetProxyQueueConfig().ScalerStrategy).Msg("scaling down chrome pool")
+		pq.scaleDown.scaled(now)
 	}
 }
 
-func (pqe *ElementData) proxy() ProxyResult {
+func (pqe *ElementData) proxy(sessions *activeSessions, parked *parkedSessions) ProxyResult {
 	log := logger.Get()
 
-	crm, err := chromePoolGet().GetAvailableChrome(
-		pqe.R.Context().Value(logger.SessionIdTrackingKey).(uuid.UUID),
-		pqe.ChromeOptions,
-	)
-	if err != nil {
+	sessions.add(pqe.SessionId)
+	defer sessions.remove(pqe.SessionId)
+
+	crm, release, err := pqe.getChrome()
+	if errors.Is(err, chromepool.ErrPinLimitReached) {
+		log.Warn().Err(err).Ctx(pqe.R.Context()).Msg("unable to pin chrome for sticky session")
+		return PinLimitReached
+	} else if errors.Is(err, chromepool.ErrPinProfileMismatch) {
+		log.Warn().Err(err).Ctx(pqe.R.Context()).Msg("unable to use pinned chrome for sticky session")
+		return PinProfileMismatch
+	} else if err != nil {
 		log.Warn().Err(err).Ctx(pqe.R.Context()).Msg("unable to GetAvailableChrome")
 		return UnableToGetChrome
 	}
-	defer (*crm).SetIdleOrStop()
+	defer release()
+
+	// isolate reused browsers so that nothing carries over between sessions. Sticky sessions keep their state in
+	// the pinned browser, which is not reused once it is released
+	var isolation *cdpmessage.BrowserContextIsolation
+	var browserContextID cdp.BrowserContextID
+	chromeConf := (*crm).Config()
+	if chromeConf.EnableBrowserReuse && chromeConf.EnableSessionBrowserContexts && len(pqe.StickySession) == 0 {
+		browserContextID, err = (*crm).CreateBrowserContext()
+		if err != nil {
+			log.Error().Err(err).Ctx(pqe.R.Context()).Msg("unable to create browser context for session")
+			return ConnectionError
+		}
+		isolation = cdpmessage.NewBrowserContextIsolation(browserContextID, *crm)
+	}
 
-	chromeCtx, cancel := context.WithCancel(pqe.R.Context())
+	debugUrl := (*crm).DebugUrl()
+	if pqe.Target == PageTarget {
+		targetID, err := (*crm).CreatePageTarget(pqe.PageUrl, browserContextID)
+		if err != nil {
+			log.Error().Err(err).Ctx(pqe.R.Context()).Msg("unable to create page target for session")
+			return ConnectionError
+		}
+		debugUrl = (*crm).PageDebugUrl(targetID)
+	}
+
+	pqConf := config.Get().GetProxyQueueConfig()
+	sessionCtx := pqe.R.Context()
+	chromeCtx, cancel := context.WithCancel(sessionCtx)
 	defer cancel()
 
 	// dial chrome after getting instance
-	chromeConn, _, err := websocketDial(chromeCtx, (*crm).DebugUrl(), nil)
+	chromeConn, _, err := websocketDial(chromeCtx, debugUrl, nil)
 	if err != nil {
 		log.Error().Err(err).Ctx(pqe.R.Context()).Msg("unable to connect to chrome ws port")
 		return ConnectionError
 	}
-	chromeConn.SetReadLimit(-1)
+	chromeConn.SetReadLimit(int64(pqConf.ReadLimitInBytes))
 	defer chromeConn.CloseNow()
 
-	// accept websocket after chrome is ready
-	clientConn, err := websocketAccept(pqe.W, pqe.R, nil)
-	if err != nil {
-		log.Error().Ctx(pqe.R.Context()).Msg("unable to accept client connection")
-		return ConnectionError
+	if recConf := config.Get().GetRecorderConfig(); recConf.Enabled {
+		rec, err := recorder.New(recConf.Dir, pqe.R.Context().Value(logger.SessionIdTrackingKey).(uuid.UUID))
+		if err != nil {
+			log.Error().Err(err).Ctx(pqe.R.Context()).Msg("unable to create session recording")
+		} else {
+			defer rec.Close()
+			pqe.recorder = rec
+		}
+	}
+
+	resumable := pqConf.ResumeGracePeriodInSecs > 0
+
+	// chrome messages are proxied for the whole session, to whichever client is attached
+	var chromeWs *websocketproxy.WebsocketProxy
+	var chromeErr error
+	chromeDone := make(chan struct{})
+	link := &clientLink{}
+
+	start := time.Now()
+	attached := pqe
+	var token string
+
+	for {
+		// accept websocket after chrome is ready
+		attachToken := token
+		if resumable {
+			attachToken = newResumeToken()
+			attached.W.Header().Set(ResumeTokenHeader, attachToken)
+		}
+		attached.W.Header().Set(QueuePositionHeader, strconv.Itoa(pqe.QueuePosition))
+		attached.W.Header().Set(SessionIdHeader, pqe.SessionId.String())
+		clientConn, acceptErr := websocketAccept(attached.W, attached.R, nil)
+		if acceptErr != nil && attached == pqe {
+			log.Error().Ctx(pqe.R.Context()).Msg("unable to accept client connection")
+			return ConnectionError
+		}
+
+		if acceptErr != nil {
+			// the client can retry with the token it resumed with
+			log.Error().Ctx(attached.R.Context()).Msg("unable to accept resumed client connection")
+			attached.C <- ConnectionError
+			err = acceptErr
+		} else {
+			attached.accepted = true
+			token = attachToken
+			clientConn.SetReadLimit(int64(pqConf.ReadLimitInBytes))
+			sessions.setConn(pqe.SessionId, clientConn)
+			if attached == pqe {
+				sessions.setPageOwner(pqe, browserContextID)
+			}
+
+			if chromeWs == nil {
+				var clientW websocketproxy.IWebsocketProxyConnection = clientConn
+				if resumable {
+					clientW = link
+				}
+				chromeWs = pqe.newChromeProxy(chromeConn, chromeCtx, clientW, sessionCtx, isolation)
+				if pqe.recorder != nil {
+					chromeWs.SetRecorder(pqe.recorder)
+				}
+				go func() {
+					for {
+						if err := chromeWs.Proxy(); err != nil {
+							chromeErr = &chromeError{err: err}
+							close(chromeDone)
+							return
+						}
+					}
+				}()
+				log.Info().Ctx(pqe.R.Context()).Msg("proxy client and chrome connections initialized")
+			}
+
+			err = pqe.serveClient(attached, clientConn, chromeConn, chromeCtx, isolation, link, chromeDone, &chromeErr, start)
+			if code, isLimit := closeStatusForErr(err); isLimit {
+				log.Info().Ctx(attached.R.Context()).Err(err).Msg("closing session")
+				_ = clientConn.Close(code, err.Error())
+			}
+			clientConn.CloseNow()
+			sessions.setConn(pqe.SessionId, nil)
+		}
+
+		if !resumable || !isClientDrop(err) {
+			break
+		}
+		if attached != pqe && acceptErr == nil {
+			metrics.Get().Remote.IncCounterWithLabels(metrics.ProxyResults, float32(1), attached.resultMetricLabels(ClientDetached))
+			attached.C <- ClientDetached
+		}
+
+		// nothing reads the browser's events while the session is parked, so its idle timeout must not recycle the
+		// browser. Pinned browsers have their ticker paused already
+		if len(pqe.StickySession) == 0 {
+			(*crm).PauseTicker()
+		}
+		next := pqe.park(parked, token, start, chromeDone)
+		if len(pqe.StickySession) == 0 {
+			(*crm).StartTicker()
+		}
+		if next == nil {
+			select {
+			case <-chromeDone:
+				err = chromeErr
+			default:
+				err = ErrResumeExpired
+			}
+			break
+		}
+		log.Info().Ctx(next.R.Context()).Msg("resuming session")
+		attached = next
+	}
+
+	diff := time.Now().Sub(start)
+	res := pqe.proxyResultFromErr(err)
+	if attached != pqe {
+		attached.C <- res
+	}
+	metrics.Get().InMemory.AddSample(metrics.ProxyTimeSecs, float32(diff.Seconds()))
+	metrics.Get().Remote.AddSampleWithLabels(metrics.ProxyTimeSecs, float32(diff.Seconds()), pqe.resultMetricLabels(res))
+	return res
+}
+
+// serveClient proxies the messages of the client attached to the session until it disconnects, chrome disconnects,
+// or a session limit is reached
+func (pqe *ElementData) serveClient(
+	attached *ElementData,
+	clientConn *websocket.Conn,
+	chromeConn *websocket.Conn,
+	chromeCtx context.Context,
+	isolation *cdpmessage.BrowserContextIsolation,
+	link *clientLink,
+	chromeDone <-chan struct{},
+	chromeErr *error,
+	start time.Time,
+) error {
+	pqConf := config.Get().GetProxyQueueConfig()
+	maxDuration := pqe.SessionTimeLimit
+	if maxDuration > 0 {
+		// the limit is for the whole session, however many times it has been resumed
+		if maxDuration -= time.Since(start); maxDuration <= 0 {
+			return ErrMaxSessionDuration
+		}
+	}
+
+	clientWs := pqe.newClientProxy(clientConn, attached.R.Context(), chromeConn, chromeCtx, isolation)
+	if pqe.recorder != nil {
+		clientWs.SetRecorder(pqe.recorder)
+	}
+
+	// buffered so that the loops do not block once the client has been detached
+	errC := make(chan error, 3)
+	done := make(chan struct{})
+	defer close(done)
+
+	linkFailed := link.attach(clientConn)
+	defer link.detach()
+
+	go func() {
+		for {
+			if err := clientWs.Proxy(); err != nil {
+				errC <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		select {
+		case err := <-linkFailed:
+			errC <- err
+		case <-chromeDone:
+			errC <- *chromeErr
+		case <-done:
+		}
+	}()
+
+	// block until error channel is received or a session limit is reached
+	return waitForSessionEnd(errC, maxDuration, pqConf.ClientIdleTimeoutInSecs, clientWs.LastReadAt)
+}
+
+// park waits up to SESSION_RESUME_GRACE_PERIOD_IN_SECS, or until the session duration limit, for a client to resume
+// the session with token. Returns the element of the resuming client, or nil
+func (pqe *ElementData) park(parked *parkedSessions, token string, start time.Time, chromeDone <-chan struct{}) *ElementData {
+	log := logger.Get()
+
+	timeout := config.Get().GetProxyQueueConfig().ResumeGracePeriodInSecs
+	if pqe.SessionTimeLimit > 0 {
+		timeout = min(timeout, pqe.SessionTimeLimit-time.Since(start))
+	}
+	if timeout <= 0 {
+		return nil
 	}
-	clientConn.SetReadLimit(-1)
-	defer clientConn.CloseNow()
 
-	limiter := rate.NewLimiter(rate.Every(time.Millisecond*10), 10)
+	p := parked.park(token, pqe)
+	if p == nil {
+		return nil
+	}
+	log.Info().Ctx(pqe.R.Context()).Dur("gracePeriod", timeout).Msg("client disconnected, parking session")
+	return parked.wait(token, p, timeout, chromeDone)
+}
 
+// newWebsocketProxies creates the proxies of both directions of a connection between the client and chrome
+func (pqe *ElementData) newWebsocketProxies(
+	clientConn *websocket.Conn,
+	clientCtx context.Context,
+	chromeConn *websocket.Conn,
+	chromeCtx context.Context,
+	isolation *cdpmessage.BrowserContextIsolation,
+) (*websocketproxy.WebsocketProxy, *websocketproxy.WebsocketProxy) {
+	clientWs := pqe.newClientProxy(clientConn, clientCtx, chromeConn, chromeCtx, isolation)
+	chromeWs := pqe.newChromeProxy(chromeConn, chromeCtx, clientConn, clientCtx, isolation)
+	return clientWs, chromeWs
+}
+
+// newClientProxy creates the proxy of messages from the client to chrome, with the session's rate limits, policy and
+// isolation
+func (pqe *ElementData) newClientProxy(
+	clientConn *websocket.Conn,
+	clientCtx context.Context,
+	chromeConn websocketproxy.IWebsocketProxyConnection,
+	chromeCtx context.Context,
+	isolation *cdpmessage.BrowserContextIsolation,
+) *websocketproxy.WebsocketProxy {
 	clientWs := newWebsocketProxy(
 		clientConn,
-		pqe.R.Context(),
+		clientCtx,
 		websocketproxy.Client,
-		limiter,
-		10,
+		pqe.newShaper(pqe.rateLimits().Client, websocketproxy.Client),
+		proxyTimeouts(),
 	)
+	clientWs.SetWriteConnection(chromeConn, chromeCtx)
+
+	if !pqe.Policy.IsEmpty() {
+		clientWs.AddInterceptor(pqe.Policy)
+	}
+	if isolation != nil {
+		clientWs.AddInterceptor(isolation.ClientInterceptor())
+	}
+	return clientWs
+}
 
+// newChromeProxy creates the proxy of messages from chrome to the client, with the session's rate limits and isolation
+func (pqe *ElementData) newChromeProxy(
+	chromeConn *websocket.Conn,
+	chromeCtx context.Context,
+	clientConn websocketproxy.IWebsocketProxyConnection,
+	clientCtx context.Context,
+	isolation *cdpmessage.BrowserContextIsolation,
+) *websocketproxy.WebsocketProxy {
+	// each direction has its own budget so that chrome event floods do not hold back client commands
 	chromeWs := newWebsocketProxy(
 		chromeConn,
 		chromeCtx,
 		websocketproxy.Chrome,
-		limiter,
-		10,
+		pqe.newShaper(pqe.rateLimits().Chrome, websocketproxy.Chrome),
+		proxyTimeouts(),
 	)
+	chromeWs.SetWriteConnection(clientConn, clientCtx)
 
-	chromeWs.SetWriteConnection(clientConn, pqe.R.Context())
-	clientWs.SetWriteConnection(chromeConn, chromeCtx)
-
-	errC := make(chan error, 0)
-
-	start := time.Now()
-
-	proxyLoop := func(wp *websocketproxy.WebsocketProxy) {
-		for {
-			err = wp.Proxy()
-			if err != nil {
-				errC <- err
-			}
-		}
+	if isolation != nil {
+		chromeWs.AddInterceptor(isolation.ChromeInterceptor())
 	}
+	return chromeWs
+}
 
-	go proxyLoop(clientWs)
-	go proxyLoop(chromeWs)
-
-	log.Info().Ctx(pqe.R.Context()).Msg("proxy client and chrome connections initialized")
+func proxyTimeouts() websocketproxy.Timeouts {
+	pqConf := config.Get().GetProxyQueueConfig()
+	return websocketproxy.Timeouts{
+		ReadIdle:    pqConf.ReadIdleTimeoutInSecs,
+		Write:       pqConf.WriteTimeoutInSecs,
+		LimiterWait: pqConf.LimiterWaitTimeoutInSecs,
+	}
+}
 
-	// block until error channel is received
-	err = <-errC
+func (pqe *ElementData) proxyResultFromErr(err error) ProxyResult {
+	log := logger.Get()
 
-	diff := time.Now().Sub(start)
-	metrics.Get().InMemory.AddSample(metrics.ProxyTimeSecs, float32(diff.Seconds()))
-	metrics.Get().Remote.AddSample(metrics.ProxyTimeSecs, float32(diff.Seconds()))
+	if errors.Is(err, ErrMaxSessionDuration) {
+		return MaxDurationReached
+	}
+	if errors.Is(err, ErrClientIdle) {
+		return ClientIdleTimeout
+	}
+	if errors.Is(err, ErrResumeExpired) {
+		return ResumeExpired
+	}
+	if errors.Is(err, websocketproxy.ErrReadIdleTimeout) {
+		log.Warn().Ctx(pqe.R.Context()).Err(err).Msg("session timed out waiting for a message")
+		return ReadIdleTimeout
+	}
+	if errors.Is(err, websocketproxy.ErrWriteTimeout) {
+		log.Warn().Ctx(pqe.R.Context()).Err(err).Msg("session timed out writing a message")
+		return WriteTimeout
+	}
+	if errors.Is(err, websocketproxy.ErrLimiterWaitTimeout) {
+		log.Warn().Ctx(pqe.R.Context()).Err(err).Msg("session was throttled for too long")
+		return Throttled
+	}
 
 	if err == nil ||
 		websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
@@ -310,7 +1006,7 @@ func (pqe *ElementData) proxy() ProxyResult {
 		return Succeeded
 	}
 
-	if errors.Is(err, context.Canceled) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		log.Error().Ctx(pqe.R.Context()).Msg("session timed out")
 		return SessionTimedOut
 	}
@@ -0,0 +1,508 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/tenant"
+	"chromium-websocket-proxy/test/mocks/chromemock"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"container/heap"
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ProxyQueueTestSuite struct {
+	suite.Suite
+	pq *ProxyQueue
+}
+
+// run before each test
+func (suite *ProxyQueueTestSuite) SetupTest() {
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+
+	chromePoolGet = func() chromepool.IChromePool {
+		return chromepoolmock.NewMock()
+	}
+	suite.pq = &ProxyQueue{}
+}
+
+func (suite *ProxyQueueTestSuite) TearDownTest() {
+	chromePoolGet = chromepool.Get
+}
+
+func (suite *ProxyQueueTestSuite) newElementData(target string) *ElementData {
+	r := httptest.NewRequest("GET", target, nil)
+	r = r.WithContext(context.WithValue(r.Context(), logger.SessionIdTrackingKey, uuid.New()))
+	eld, err := NewElementData(httptest.NewRecorder(), r)
+	assert.Nil(suite.T(), err)
+	return eld
+}
+
+func (suite *ProxyQueueTestSuite) TestGetQueuedSessions() {
+	first := suite.newElementData("/connect")
+	second := suite.newElementData("/connect?profile=custom")
+	_ = suite.pq.AddToList(first)
+	_ = suite.pq.AddToList(second)
+	second.Retries = 2
+
+	assert.Equal(suite.T(), 1, first.QueuePosition)
+	assert.Equal(suite.T(), 2, second.QueuePosition)
+
+	sessions := suite.pq.GetQueuedSessions()
+	assert.Len(suite.T(), sessions, 2)
+
+	assert.Equal(suite.T(), first.SessionId.String(), sessions[0].SessionId)
+	assert.Equal(suite.T(), 1, sessions[0].Position)
+	assert.Equal(suite.T(), first.EnqueuedAt, sessions[0].EnqueuedAt)
+
+	assert.Equal(suite.T(), second.SessionId.String(), sessions[1].SessionId)
+	assert.Equal(suite.T(), "custom", sessions[1].Profile)
+	assert.Equal(suite.T(), 2, sessions[1].Position)
+	assert.Equal(suite.T(), 2, sessions[1].Retries)
+
+	suite.pq.RemoveFromList(second)
+	assert.Len(suite.T(), suite.pq.GetQueuedSessions(), 1)
+}
+
+func (suite *ProxyQueueTestSuite) TestEstimateWaitSecs() {
+	// a single browser works through the queue one session at a time
+	assert.Equal(suite.T(), float64(10), estimateWaitSecs(1, 1, 10))
+	assert.Equal(suite.T(), float64(30), estimateWaitSecs(3, 1, 10))
+
+	// two browsers work through two sessions at a time
+	assert.Equal(suite.T(), float64(10), estimateWaitSecs(2, 2, 10))
+	assert.Equal(suite.T(), float64(20), estimateWaitSecs(3, 2, 10))
+
+	// an empty pool still has to start a browser
+	assert.Equal(suite.T(), float64(10), estimateWaitSecs(1, 0, 10))
+}
+
+func (suite *ProxyQueueTestSuite) TestEstimatedWaitUsesProxyTimeSamples() {
+	_ = suite.pq.AddToList(suite.newElementData("/connect"))
+	assert.Equal(suite.T(), config.DefaultProxyTimeInSecsDefault, suite.pq.GetQueuedSessions()[0].EstimatedWaitSecs)
+
+	metrics.Get().InMemory.AddSample(metrics.ProxyTimeSecs, 4)
+	metrics.Get().InMemory.AddSample(metrics.ProxyTimeSecs, 6)
+	assert.Equal(suite.T(), float64(5), suite.pq.GetQueuedSessions()[0].EstimatedWaitSecs)
+}
+
+func (suite *ProxyQueueTestSuite) popAll() []*ElementData {
+	var popped []*ElementData
+	for {
+		pqe := suite.pq.popWLocked()
+		if pqe == nil {
+			return popped
+		}
+		popped = append(popped, pqe)
+	}
+}
+
+func (suite *ProxyQueueTestSuite) TestHigherPrioritySessionsArePoppedFirst() {
+	batch := suite.newElementData("/connect?priority=-1")
+	normal := suite.newElementData("/connect")
+	interactive := suite.newElementData("/connect?priority=5")
+	alsoNormal := suite.newElementData("/connect")
+
+	_ = suite.pq.AddToList(batch)
+	_ = suite.pq.AddToList(normal)
+	_ = suite.pq.AddToList(interactive)
+	_ = suite.pq.AddToList(alsoNormal)
+
+	assert.Equal(suite.T(), 1, interactive.QueuePosition)
+	assert.Equal(suite.T(), 3, alsoNormal.QueuePosition)
+
+	sessions := suite.pq.GetQueuedSessions()
+	assert.Equal(suite.T(), interactive.SessionId.String(), sessions[0].SessionId)
+	assert.Equal(suite.T(), batch.SessionId.String(), sessions[3].SessionId)
+
+	// sessions with the same priority are popped in the order they were queued
+	assert.Equal(suite.T(), []*ElementData{interactive, normal, alsoNormal, batch}, suite.popAll())
+}
+
+func (suite *ProxyQueueTestSuite) TestLowPrioritySessionsAge() {
+	suite.T().Setenv(config.PriorityAgingPerSec, "1")
+	config.Once = sync.Once{}
+
+	batch := suite.newElementData("/connect?priority=-2")
+	_ = suite.pq.AddToList(batch)
+
+	// pretend the batch session has been waiting for 3 seconds
+	batch.priorityKey = priorityKey(batch.PriorityModifier, batch.EnqueuedAt.Add(-3*time.Second))
+	heap.Fix(&suite.pq.queue, batch.index)
+
+	normal := suite.newElementData("/connect")
+	_ = suite.pq.AddToList(normal)
+
+	assert.Equal(suite.T(), []*ElementData{batch, normal}, suite.popAll())
+}
+
+func (suite *ProxyQueueTestSuite) TestRequeuedSessionIsPlacedBehindNextSession() {
+	first := suite.newElementData("/connect?priority=5")
+	second := suite.newElementData("/connect")
+	third := suite.newElementData("/connect")
+	_ = suite.pq.AddToList(first)
+	_ = suite.pq.AddToList(second)
+	_ = suite.pq.AddToList(third)
+
+	assert.Equal(suite.T(), first, suite.pq.popWLocked())
+	suite.pq.requeue(first)
+	assert.Equal(suite.T(), 1, first.Retries)
+
+	assert.Equal(suite.T(), []*ElementData{second, first, third}, suite.popAll())
+}
+
+func (suite *ProxyQueueTestSuite) TestRemoveFromListIgnoresPoppedSessions() {
+	first := suite.newElementData("/connect")
+	second := suite.newElementData("/connect")
+	_ = suite.pq.AddToList(first)
+	_ = suite.pq.AddToList(second)
+
+	queued := func() float64 {
+		c, err := metrics.Get().InMemory.GetLastCounterAggregate(metrics.ProxyQueue)
+		assert.Nil(suite.T(), err)
+		return c.Sum
+	}
+	before := queued()
+
+	assert.Equal(suite.T(), first, suite.pq.popWLocked())
+	assert.False(suite.T(), suite.pq.RemoveFromList(first))
+	assert.Equal(suite.T(), 1, suite.pq.queue.Len())
+
+	// only sessions removed from the queue are subtracted from the queue metric
+	assert.Equal(suite.T(), before, queued())
+	assert.True(suite.T(), suite.pq.RemoveFromList(second))
+	assert.Equal(suite.T(), before-1, queued())
+}
+
+func (suite *ProxyQueueTestSuite) TestRequeuedSessionIsShedAfterMaxQueueWait() {
+	suite.T().Setenv(config.MaxQueueWaitInSecs, "1")
+	config.Once = sync.Once{}
+
+	waiting := suite.newElementData("/connect")
+	timedOut := suite.newElementData("/connect")
+	_ = suite.pq.AddToList(waiting)
+	_ = suite.pq.AddToList(timedOut)
+	timedOut.EnqueuedAt = timedOut.EnqueuedAt.Add(-time.Second)
+
+	// both sessions were popped and could not get a browser. The handler of the timed out session could not shed it
+	// while it was being proxied
+	assert.Equal(suite.T(), []*ElementData{waiting, timedOut}, suite.popAll())
+	suite.pq.requeue(timedOut)
+	suite.pq.requeue(waiting)
+
+	assert.Equal(suite.T(), QueueWaitExceeded, <-timedOut.C)
+	assert.Equal(suite.T(), []*ElementData{waiting}, suite.popAll())
+}
+
+func (suite *ProxyQueueTestSuite) TestSessionPriority() {
+	suite.T().Setenv(config.MaxSessionPriority, "10")
+	suite.T().Setenv(config.DefaultMaxSessionPriority, "0")
+	suite.T().Setenv(config.ServerAccessTokenTiers, "interactive:8,batch:-5")
+	config.Once = sync.Once{}
+
+	priority := func(target string) float32 {
+		p, err := getSessionPriority(httptest.NewRequest("GET", target, nil))
+		assert.Nil(suite.T(), err)
+		return p
+	}
+
+	// only tiers and claims raise priorities above DEFAULT_MAX_SESSION_PRIORITY
+	assert.Equal(suite.T(), float32(0), priority("/connect"))
+	assert.Equal(suite.T(), float32(0), priority("/connect?priority=3"))
+	assert.Equal(suite.T(), float32(-3), priority("/connect?priority=-3"))
+	assert.Equal(suite.T(), float32(-10), priority("/connect?priority=-100"))
+
+	// tiers set the default priority and cap requested priorities
+	assert.Equal(suite.T(), float32(8), priority("/connect?accessToken=interactive"))
+	assert.Equal(suite.T(), float32(8), priority("/connect?accessToken=interactive&priority=10"))
+	assert.Equal(suite.T(), float32(2), priority("/connect?accessToken=interactive&priority=2"))
+	assert.Equal(suite.T(), float32(-5), priority("/connect?accessToken=batch&priority=10"))
+
+	_, err := getSessionPriority(httptest.NewRequest("GET", "/connect?priority=high", nil))
+	assert.Error(suite.T(), err)
+
+	suite.T().Setenv(config.DefaultMaxSessionPriority, "5")
+	config.Once = sync.Once{}
+	assert.Equal(suite.T(), float32(3), priority("/connect?priority=3"))
+	assert.Equal(suite.T(), float32(5), priority("/connect?priority=100"))
+}
+
+func (suite *ProxyQueueTestSuite) TestCallersWithoutTierCanRaisePriorityByDefault() {
+	suite.T().Setenv(config.MaxSessionPriority, "5")
+	config.Once = sync.Once{}
+
+	priority := func(target string) float32 {
+		p, err := getSessionPriority(httptest.NewRequest("GET", target, nil))
+		assert.Nil(suite.T(), err)
+		return p
+	}
+
+	// without DEFAULT_MAX_SESSION_PRIORITY, callers can request up to MAX_SESSION_PRIORITY
+	assert.Equal(suite.T(), float32(0), priority("/connect"))
+	assert.Equal(suite.T(), float32(3), priority("/connect?priority=3"))
+	assert.Equal(suite.T(), float32(5), priority("/connect?priority=100"))
+	assert.Equal(suite.T(), float32(-5), priority("/connect?priority=-100"))
+}
+
+func (suite *ProxyQueueTestSuite) TestJwtClaimsCapPriorityAndSessionTimeLimit() {
+	suite.T().Setenv(config.MaxSessionPriority, "10")
+	suite.T().Setenv(config.ServerAccessTokenTiers, "interactive:8")
+	config.Once = sync.Once{}
+
+	maxPriority := 4.0
+	claims := &jwtauth.Claims{MaxPriority: &maxPriority, MaxSessionDurationInSecs: 30}
+	priority := func(target string) float32 {
+		r := httptest.NewRequest("GET", target, nil)
+		p, err := getSessionPriority(r.WithContext(jwtauth.WithClaims(r.Context(), claims)))
+		assert.Nil(suite.T(), err)
+		return p
+	}
+
+	// the claim raises the ceiling of callers without a tier, and lowers the ceiling of tiers
+	assert.Equal(suite.T(), float32(0), priority("/connect"))
+	assert.Equal(suite.T(), float32(4), priority("/connect?priority=10"))
+	assert.Equal(suite.T(), float32(4), priority("/connect?accessToken=interactive"))
+
+	maxPriority = 100
+	assert.Equal(suite.T(), float32(10), priority("/connect?priority=100"))
+	assert.Equal(suite.T(), float32(8), priority("/connect?accessToken=interactive&priority=100"))
+
+	assert.Equal(suite.T(), 30*time.Second, sessionTimeLimit(0, claims.MaxSessionDuration()))
+	assert.Equal(suite.T(), 20*time.Second, sessionTimeLimit(20*time.Second, claims.MaxSessionDuration()))
+	assert.Equal(suite.T(), time.Duration(0), sessionTimeLimit(0, 0))
+}
+
+func (suite *ProxyQueueTestSuite) TestConnectTarget() {
+	eld := suite.newElementData("/connect")
+	assert.Equal(suite.T(), BrowserTarget, eld.Target)
+
+	eld = suite.newElementData("/connect?target=page")
+	assert.Equal(suite.T(), PageTarget, eld.Target)
+	assert.Equal(suite.T(), "about:blank", eld.PageUrl)
+
+	eld = suite.newElementData("/connect?target=page&url=https%3A%2F%2Fexample.com%2F%3Fq%3D1")
+	assert.Equal(suite.T(), "https://example.com/?q=1", eld.PageUrl)
+
+	_, _, err := getConnectTarget(httptest.NewRequest("GET", "/connect?target=worker", nil))
+	assert.ErrorContains(suite.T(), err, TargetParam)
+}
+
+func (suite *ProxyQueueTestSuite) TestStickySession() {
+	eld := suite.newElementData("/connect?session=login-1")
+	assert.Equal(suite.T(), "login-1", eld.StickySession)
+	assert.Empty(suite.T(), suite.newElementData("/connect").StickySession)
+
+	_, err := getStickySessionKey(httptest.NewRequest("GET", "/connect?session=a%2Fb", nil))
+	assert.ErrorContains(suite.T(), err, StickySessionParam)
+
+	// keys are scoped to the tenant
+	r := httptest.NewRequest("GET", "/connect?session=login", nil)
+	tenantR := r.WithContext(tenant.WithTenant(r.Context(), &tenant.Tenant{Name: "acme"}))
+	assert.Equal(suite.T(), chromepool.Pin{Tenant: "acme", Key: "login"}, StickySessionPin(tenantR, "login"))
+
+	// or to the JWT subject or the access token of callers without a tenant
+	alice := r.WithContext(jwtauth.WithClaims(r.Context(), &jwtauth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}}))
+	bob := r.WithContext(jwtauth.WithClaims(r.Context(), &jwtauth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "bob"}}))
+	assert.Equal(suite.T(), chromepool.Pin{Tenant: "jwt:alice", Key: "login"}, StickySessionPin(alice, "login"))
+	assert.NotEqual(suite.T(), StickySessionPin(alice, "login"), StickySessionPin(bob, "login"))
+
+	serverR := httptest.NewRequest("GET", "/connect?session=login&accessToken=server-token", nil)
+	tierR := httptest.NewRequest("GET", "/connect?session=login&accessToken=tier-token", nil)
+	serverPin := StickySessionPin(serverR, "login")
+	assert.NotEqual(suite.T(), serverPin, StickySessionPin(tierR, "login"))
+	assert.NotEqual(suite.T(), serverPin, StickySessionPin(tenantR, "login"))
+	assert.NotContains(suite.T(), serverPin.Tenant, "server-token")
+	assert.Equal(suite.T(), serverPin, StickySessionPin(serverR, "login"))
+}
+
+func (suite *ProxyQueueTestSuite) TestProxyStickySessionUsesPinnedChrome() {
+	pool := chromepoolmock.NewMock()
+	chromePoolGet = func() chromepool.IChromePool {
+		return pool
+	}
+
+	eld := suite.newElementData("/connect?session=login")
+	eld.Tenant = &tenant.Tenant{Name: "acme", MaxPinnedBrowsers: 2}
+	eld.R = eld.R.WithContext(tenant.WithTenant(eld.R.Context(), eld.Tenant))
+	pin := chromepool.Pin{Tenant: "acme", Key: "login"}
+
+	pool.SetGetPinnedChrome(func(p chromepool.Pin, maxPins int, _ uuid.UUID, _ config.ChromeConfigOptions) (chrome.IChrome, error) {
+		assert.Equal(suite.T(), pin, p)
+		assert.Equal(suite.T(), 2, maxPins)
+		return nil, chromepool.ErrPinLimitReached
+	})
+	assert.Equal(suite.T(), PinLimitReached, eld.proxy(&suite.pq.sessions, &suite.pq.parked))
+
+	pool.SetGetPinnedChrome(func(chromepool.Pin, int, uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error) {
+		return nil, chromepool.ErrPinProfileMismatch
+	})
+	assert.Equal(suite.T(), PinProfileMismatch, eld.proxy(&suite.pq.sessions, &suite.pq.parked))
+
+	// a session using the pinned browser is queued again until the browser is handed back
+	pool.SetGetPinnedChrome(func(chromepool.Pin, int, uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error) {
+		return nil, chromepool.ErrPinInUse
+	})
+	assert.Equal(suite.T(), UnableToGetChrome, eld.proxy(&suite.pq.sessions, &suite.pq.parked))
+	assert.Empty(suite.T(), pool.GetReturnedPins())
+
+	// the pinned browser is handed back instead of being set idle
+	crm := chromemock.NewMock()
+	pool.SetGetPinnedChrome(func(chromepool.Pin, int, uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error) {
+		return crm, nil
+	})
+	assert.Equal(suite.T(), ConnectionError, eld.proxy(&suite.pq.sessions, &suite.pq.parked))
+	assert.Equal(suite.T(), []chromepool.Pin{pin}, pool.GetReturnedPins())
+	assert.False(suite.T(), crm.IsIdle())
+}
+
+func (suite *ProxyQueueTestSuite) TestAddToListShedsWhenQueueIsFull() {
+	suite.T().Setenv(config.MaxQueueLength, "2")
+	config.Once = sync.Once{}
+
+	first := suite.newElementData("/connect")
+	assert.Nil(suite.T(), suite.pq.AddToList(first))
+	assert.Nil(suite.T(), suite.pq.AddToList(suite.newElementData("/connect")))
+	assert.ErrorIs(suite.T(), suite.pq.AddToList(suite.newElementData("/connect")), ErrQueueFull)
+	assert.Equal(suite.T(), 2, suite.pq.queue.Len())
+
+	// room is made once a session leaves the queue
+	suite.pq.popWLocked()
+	assert.Nil(suite.T(), suite.pq.AddToList(suite.newElementData("/connect")))
+}
+
+func (suite *ProxyQueueTestSuite) TestShedOnlyRemovesQueuedSessions() {
+	first := suite.newElementData("/connect")
+	second := suite.newElementData("/connect")
+	_ = suite.pq.AddToList(first)
+	_ = suite.pq.AddToList(second)
+
+	// first is being proxied
+	suite.pq.popWLocked()
+	assert.False(suite.T(), suite.pq.Shed(first, QueueWaitTimeout))
+	assert.True(suite.T(), suite.pq.Shed(second, QueueWaitTimeout))
+	assert.Equal(suite.T(), 0, suite.pq.queue.Len())
+}
+
+func (suite *ProxyQueueTestSuite) TestRetryAfterSecs() {
+	assert.GreaterOrEqual(suite.T(), suite.pq.RetryAfterSecs(), 1)
+}
+
+func (suite *ProxyQueueTestSuite) TestStartDrainingRejectsQueuedAndNewSessions() {
+	queued := suite.newElementData("/connect")
+	assert.Nil(suite.T(), suite.pq.AddToList(queued))
+	assert.False(suite.T(), suite.pq.IsDraining())
+
+	suite.pq.StartDraining()
+	assert.True(suite.T(), suite.pq.IsDraining())
+	assert.Equal(suite.T(), Rejected, <-queued.C)
+	assert.Equal(suite.T(), 0, suite.pq.queue.Len())
+	assert.ErrorIs(suite.T(), suite.pq.AddToList(suite.newElementData("/connect")), ErrServerDraining)
+}
+
+type mockClosableConnection struct {
+	code websocket.StatusCode
+}
+
+func (c *mockClosableConnection) Close(code websocket.StatusCode, _ string) error {
+	c.code = code
+	return nil
+}
+
+func (suite *ProxyQueueTestSuite) TestWaitForAndCloseActiveSessions() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(suite.T(), suite.pq.WaitForActiveSessions(ctx))
+
+	accepted, notAccepted := uuid.New(), uuid.New()
+	conn := &mockClosableConnection{}
+	suite.pq.sessions.add(accepted)
+	suite.pq.sessions.setConn(accepted, conn)
+	suite.pq.sessions.add(notAccepted)
+	assert.Equal(suite.T(), 2, suite.pq.ActiveSessionCount())
+	assert.False(suite.T(), suite.pq.WaitForActiveSessions(ctx))
+
+	assert.Equal(suite.T(), 1, suite.pq.CloseActiveSessions(websocket.StatusGoingAway, "shutting down"))
+	assert.Equal(suite.T(), websocket.StatusGoingAway, conn.code)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		suite.pq.sessions.remove(accepted)
+		suite.pq.sessions.remove(notAccepted)
+	}()
+	assert.True(suite.T(), suite.pq.WaitForActiveSessions(context.Background()))
+}
+
+func (suite *ProxyQueueTestSuite) TestTenantQueueQuota() {
+	t := &tenant.Tenant{Name: "acme", MaxQueuedSessions: 1}
+	newTenantElementData := func() *ElementData {
+		eld := suite.newElementData("/connect")
+		eld.Tenant = t
+		return eld
+	}
+
+	first := newTenantElementData()
+	assert.Nil(suite.T(), suite.pq.AddToList(first))
+	assert.ErrorIs(suite.T(), suite.pq.AddToList(newTenantElementData()), tenant.ErrQueueQuota)
+
+	// sessions without the tenant are not limited
+	assert.Nil(suite.T(), suite.pq.AddToList(suite.newElementData("/connect")))
+
+	// leaving the queue frees the quota
+	assert.True(suite.T(), suite.pq.RemoveFromList(first))
+	assert.Nil(suite.T(), suite.pq.AddToList(newTenantElementData()))
+}
+
+func (suite *ProxyQueueTestSuite) TestThrottledTenantDoesNotStarveOtherTenants() {
+	throttled := &tenant.Tenant{Name: "acme", MaxConcurrentSessions: 1}
+	other := &tenant.Tenant{Name: "globex", MaxConcurrentSessions: 1}
+	newTenantElementData := func(t *tenant.Tenant) *ElementData {
+		eld := suite.newElementData("/connect")
+		eld.Tenant = t
+		_ = suite.pq.AddToList(eld)
+		return eld
+	}
+
+	active := newTenantElementData(throttled)
+	first := newTenantElementData(throttled)
+	second := newTenantElementData(throttled)
+	otherFirst := newTenantElementData(other)
+	otherSecond := newTenantElementData(other)
+
+	assert.Equal(suite.T(), active, suite.pq.popStartableWLocked())
+	firstKey := first.priorityKey
+
+	// the throttled tenant's sessions are skipped without losing their place in the queue
+	assert.Equal(suite.T(), otherFirst, suite.pq.popStartableWLocked())
+	assert.Nil(suite.T(), suite.pq.popStartableWLocked())
+	assert.Equal(suite.T(), firstKey, first.priorityKey)
+	assert.Equal(suite.T(), 0, first.Retries)
+	assert.Equal(suite.T(), 3, suite.pq.QueueLength())
+
+	throttled.Finish()
+	assert.Equal(suite.T(), first, suite.pq.popStartableWLocked())
+	other.Finish()
+	assert.Equal(suite.T(), otherSecond, suite.pq.popStartableWLocked())
+	throttled.Finish()
+	assert.Equal(suite.T(), second, suite.pq.popStartableWLocked())
+
+	queued, proxied := throttled.Usage()
+	assert.Equal(suite.T(), 0, queued)
+	assert.Equal(suite.T(), 1, proxied)
+}
+
+func TestProxyQueueSuite(t *testing.T) {
+	suite.Run(t, new(ProxyQueueTestSuite))
+}
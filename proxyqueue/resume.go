@@ -0,0 +1,246 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/websocketproxy"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"nhooyr.io/websocket"
+	"sync"
+	"time"
+)
+
+// ResumeParam lets clients reattach to a session parked after their connection dropped, e.g. /connect?resume={token}
+const ResumeParam = "resume"
+
+// ResumeTokenHeader is set on the upgrade response to the token that resumes the session. Every attachment of the
+// client gets a new token
+const ResumeTokenHeader = "X-Resume-Token"
+
+// ErrResumeTokenNotFound is returned by Resume when no session is parked with the token, or it belongs to another tenant
+var ErrResumeTokenNotFound = errors.New("no session is waiting to be resumed with the given token")
+
+// ErrResumeExpired ends sessions whose client did not reconnect within SESSION_RESUME_GRACE_PERIOD_IN_SECS
+var ErrResumeExpired = errors.New("client did not resume the session within the grace period")
+
+// chromeError wraps errors of the chrome connection, which end the session even if it could be resumed
+type chromeError struct {
+	err error
+}
+
+func (ce *chromeError) Error() string {
+	return ce.err.Error()
+}
+
+func (ce *chromeError) Unwrap() error {
+	return ce.err
+}
+
+// isClientDrop returns true if err ended the client connection without the client closing it or a session limit
+// being reached, so that the session is worth parking until the client resumes it
+func isClientDrop(err error) bool {
+	var ce *chromeError
+	if err == nil || errors.As(err, &ce) {
+		return false
+	}
+	if errors.Is(err, ErrMaxSessionDuration) ||
+		errors.Is(err, ErrClientIdle) ||
+		errors.Is(err, websocketproxy.ErrReadIdleTimeout) ||
+		errors.Is(err, websocketproxy.ErrLimiterWaitTimeout) {
+		return false
+	}
+	status := websocket.CloseStatus(err)
+	return status == -1 || status == websocket.StatusAbnormalClosure
+}
+
+func newResumeToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientLink is the connection chrome messages are written to in resumable sessions. Messages written while no
+// client is attached are dropped, and write errors detach the client instead of ending the session
+type clientLink struct {
+	mutex  sync.Mutex
+	conn   websocketproxy.IWebsocketProxyConnection
+	failed chan error
+}
+
+// attach sends chrome messages to conn until detach. The returned channel receives the first write error of conn
+func (cl *clientLink) attach(conn websocketproxy.IWebsocketProxyConnection) <-chan error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.conn = conn
+	cl.failed = make(chan error, 1)
+	return cl.failed
+}
+
+func (cl *clientLink) detach() {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.conn = nil
+	cl.failed = nil
+}
+
+func (cl *clientLink) Writer(ctx context.Context, msgT websocket.MessageType) (io.WriteCloser, error) {
+	cl.mutex.Lock()
+	conn, failed := cl.conn, cl.failed
+	cl.mutex.Unlock()
+
+	if conn == nil {
+		return discardWriteCloser{}, nil
+	}
+	w, err := conn.Writer(ctx, msgT)
+	if err != nil {
+		reportFailure(failed, err)
+		return discardWriteCloser{}, nil
+	}
+	return &linkWriteCloser{w: w, failed: failed}, nil
+}
+
+func (cl *clientLink) Reader(_ context.Context) (websocket.MessageType, io.Reader, error) {
+	return 0, nil, errors.New("client link is write only")
+}
+
+func reportFailure(failed chan error, err error) {
+	select {
+	case failed <- err:
+	default:
+	}
+}
+
+// linkWriteCloser reports write errors to its client link and discards the rest of the message
+type linkWriteCloser struct {
+	w      io.WriteCloser
+	failed chan error
+	err    error
+}
+
+func (lw *linkWriteCloser) Write(p []byte) (int, error) {
+	if lw.err == nil {
+		if _, lw.err = lw.w.Write(p); lw.err != nil {
+			reportFailure(lw.failed, lw.err)
+		}
+	}
+	return len(p), nil
+}
+
+func (lw *linkWriteCloser) Close() error {
+	if err := lw.w.Close(); err != nil && lw.err == nil {
+		reportFailure(lw.failed, err)
+	}
+	return nil
+}
+
+type discardWriteCloser struct{}
+
+func (discardWriteCloser) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardWriteCloser) Close() error {
+	return nil
+}
+
+// parkedSessions holds the sessions waiting for their client to resume them. The zero value is ready to use
+type parkedSessions struct {
+	mutex    sync.Mutex
+	sessions map[string]*parkedSession
+	closed   bool
+}
+
+type parkedSession struct {
+	owner   *ElementData
+	resumeC chan *ElementData
+	expired chan struct{}
+}
+
+// park registers owner's session under token. Returns nil once expireAll has been called
+func (ps *parkedSessions) park(token string, owner *ElementData) *parkedSession {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.closed {
+		return nil
+	}
+	if ps.sessions == nil {
+		ps.sessions = make(map[string]*parkedSession)
+	}
+	p := &parkedSession{
+		owner:   owner,
+		resumeC: make(chan *ElementData, 1),
+		expired: make(chan struct{}),
+	}
+	ps.sessions[token] = p
+	return p
+}
+
+// resume hands eld to the session parked under token
+func (ps *parkedSessions) resume(token string, eld *ElementData) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	p, exists := ps.sessions[token]
+	if !exists || p.owner.Tenant != eld.Tenant {
+		return ErrResumeTokenNotFound
+	}
+	delete(ps.sessions, token)
+	p.resumeC <- eld
+	return nil
+}
+
+// remove unregisters the session parked under token. Returns false if it has been resumed already
+func (ps *parkedSessions) remove(token string, p *parkedSession) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.sessions[token] != p {
+		return false
+	}
+	delete(ps.sessions, token)
+	return true
+}
+
+// expireAll ends the wait of every parked session and stops sessions from being parked
+func (ps *parkedSessions) expireAll() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.closed = true
+	for token, p := range ps.sessions {
+		close(p.expired)
+		delete(ps.sessions, token)
+	}
+}
+
+func (ps *parkedSessions) len() int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return len(ps.sessions)
+}
+
+// wait blocks until the parked session is resumed and returns the element of the resuming client. Returns nil if
+// the session was not resumed within timeout, chromeDone was closed, or the session was expired
+func (ps *parkedSessions) wait(token string, p *parkedSession, timeout time.Duration, chromeDone <-chan struct{}) *ElementData {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case eld := <-p.resumeC:
+		return eld
+	case <-p.expired:
+		return nil
+	case <-timer.C:
+	case <-chromeDone:
+	}
+
+	if ps.remove(token, p) {
+		return nil
+	}
+	// resumed while timing out, the client made it in time
+	return <-p.resumeC
+}
+
+// Resume reattaches the client of eld to the session parked under token. The result of the attachment is sent to eld.C
+func (pq *ProxyQueue) Resume(token string, eld *ElementData) error {
+	return pq.parked.resume(token, eld)
+}
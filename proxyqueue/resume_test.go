@@ -0,0 +1,252 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/tenant"
+	"chromium-websocket-proxy/test/mocks/chromemock"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"chromium-websocket-proxy/websocketproxy"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsClientDrop(t *testing.T) {
+	assert.False(t, isClientDrop(nil))
+	assert.True(t, isClientDrop(errors.New("failed to get reader: EOF")))
+	assert.True(t, isClientDrop(websocket.CloseError{Code: websocket.StatusAbnormalClosure}))
+
+	// the client closed the session, or the session ended it
+	assert.False(t, isClientDrop(websocket.CloseError{Code: websocket.StatusNormalClosure}))
+	assert.False(t, isClientDrop(ErrMaxSessionDuration))
+	assert.False(t, isClientDrop(ErrClientIdle))
+	assert.False(t, isClientDrop(websocketproxy.ErrReadIdleTimeout))
+	assert.False(t, isClientDrop(websocketproxy.ErrLimiterWaitTimeout))
+	assert.False(t, isClientDrop(&chromeError{err: errors.New("failed to get reader: EOF")}))
+}
+
+func TestParkedSessionsResume(t *testing.T) {
+	ps := parkedSessions{}
+	owner := &ElementData{Tenant: &tenant.Tenant{Name: "acme"}}
+	p := ps.park("token", owner)
+	assert.Equal(t, 1, ps.len())
+
+	// tokens can only be used by the tenant of the session
+	assert.ErrorIs(t, ps.resume("token", &ElementData{}), ErrResumeTokenNotFound)
+	assert.ErrorIs(t, ps.resume("other", &ElementData{Tenant: owner.Tenant}), ErrResumeTokenNotFound)
+
+	resumer := &ElementData{Tenant: owner.Tenant}
+	assert.Nil(t, ps.resume("token", resumer))
+	assert.Same(t, resumer, ps.wait("token", p, time.Second, nil))
+
+	// tokens are single use
+	assert.ErrorIs(t, ps.resume("token", resumer), ErrResumeTokenNotFound)
+	assert.Equal(t, 0, ps.len())
+}
+
+func TestParkedSessionsExpire(t *testing.T) {
+	ps := parkedSessions{}
+	p := ps.park("token", &ElementData{})
+	assert.Nil(t, ps.wait("token", p, time.Millisecond, nil))
+	assert.ErrorIs(t, ps.resume("token", &ElementData{}), ErrResumeTokenNotFound)
+
+	chromeDone := make(chan struct{})
+	close(chromeDone)
+	p = ps.park("token", &ElementData{})
+	assert.Nil(t, ps.wait("token", p, time.Minute, chromeDone))
+
+	p = ps.park("token", &ElementData{})
+	ps.expireAll()
+	assert.Nil(t, ps.wait("token", p, time.Minute, nil))
+	assert.Equal(t, 0, ps.len())
+
+	// draining servers do not park sessions
+	assert.Nil(t, ps.park("token", &ElementData{}))
+}
+
+// resumeTestServer proxies sessions to an echo server in place of chrome. Clients resume sessions with ?resume={token}
+type resumeTestServer struct {
+	pq           *ProxyQueue
+	crm          *chromemock.MockChrome
+	proxy        *httptest.Server
+	chromeDials  atomic.Int32
+	results      chan ProxyResult
+	resumeResult chan ProxyResult
+}
+
+func newResumeTestServer(t *testing.T, gracePeriod string) *resumeTestServer {
+	t.Setenv(config.SessionResumeGracePeriodInSecs, gracePeriod)
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+
+	ts := &resumeTestServer{
+		pq:           &ProxyQueue{},
+		results:      make(chan ProxyResult, 1),
+		resumeResult: make(chan ProxyResult, 1),
+	}
+
+	chromeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.chromeDials.Add(1)
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		for {
+			msgT, msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			_ = conn.Write(r.Context(), msgT, msg)
+		}
+	}))
+	t.Cleanup(chromeServer.Close)
+
+	ts.crm = chromemock.NewMock()
+	ts.crm.SetDebugUrl("ws" + chromeServer.URL[len("http"):])
+	pool := chromepoolmock.NewMock()
+	pool.SetGetAvailableChrome(func(uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error) {
+		return ts.crm, nil
+	})
+	chromePoolGet = func() chromepool.IChromePool {
+		return pool
+	}
+	t.Cleanup(func() {
+		chromePoolGet = chromepool.Get
+	})
+
+	ts.proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), logger.SessionIdTrackingKey, uuid.New()))
+		eld, err := NewElementData(w, r)
+		assert.Nil(t, err)
+
+		if token := r.URL.Query().Get(ResumeParam); len(token) > 0 {
+			if err := ts.pq.Resume(token, eld); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			ts.resumeResult <- <-eld.C
+			return
+		}
+		ts.results <- eld.proxy(&ts.pq.sessions, &ts.pq.parked)
+	}))
+	t.Cleanup(ts.proxy.Close)
+	return ts
+}
+
+func (ts *resumeTestServer) dial(t *testing.T, ctx context.Context, query string) (*websocket.Conn, string) {
+	conn, resp, err := websocket.Dial(ctx, "ws"+ts.proxy.URL[len("http"):]+"/connect"+query, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	token := resp.Header.Get(ResumeTokenHeader)
+	assert.NotEmpty(t, token)
+	return conn, token
+}
+
+func echo(t *testing.T, ctx context.Context, conn *websocket.Conn, msg string) {
+	assert.Nil(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+	_, echoed, err := conn.Read(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, string(echoed))
+}
+
+func TestProxyResumesDroppedClient(t *testing.T) {
+	ts := newResumeTestServer(t, "5")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, token := ts.dial(t, ctx, "")
+	echo(t, ctx, conn, `{"id":1,"method":"Target.createTarget"}`)
+
+	// drop the connection without a close frame
+	conn.CloseNow()
+	assert.Eventually(t, func() bool { return ts.pq.parked.len() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	resumed, resumedToken := ts.dial(t, ctx, "?"+ResumeParam+"="+token)
+	assert.NotEqual(t, token, resumedToken)
+	echo(t, ctx, resumed, `{"id":2,"method":"Target.getTargets"}`)
+	assert.Equal(t, int32(1), ts.chromeDials.Load(), "the resumed client reuses the chrome connection")
+
+	assert.Nil(t, resumed.Close(websocket.StatusNormalClosure, ""))
+	select {
+	case res := <-ts.results:
+		assert.Equal(t, Succeeded, res)
+		assert.Equal(t, Succeeded, <-ts.resumeResult)
+	case <-ctx.Done():
+		t.Fatal("resumed session did not end")
+	}
+}
+
+func TestProxyPausesBrowserIdleTimeoutWhileParked(t *testing.T) {
+	// the grace period outlasts the browser's idle timeout
+	t.Setenv(config.ChromeBrowserAutoIdleTimeoutInSecs, "1")
+	ts := newResumeTestServer(t, "5")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, token := ts.dial(t, ctx, "")
+	assert.False(t, ts.crm.IsTickerPaused())
+	conn.CloseNow()
+	assert.Eventually(t, func() bool { return ts.pq.parked.len() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, ts.crm.IsTickerPaused())
+
+	resumed, _ := ts.dial(t, ctx, "?"+ResumeParam+"="+token)
+	echo(t, ctx, resumed, `{"id":1,"method":"Target.getTargets"}`)
+	assert.False(t, ts.crm.IsTickerPaused())
+	assert.False(t, ts.crm.IsIdle())
+
+	assert.Nil(t, resumed.Close(websocket.StatusNormalClosure, ""))
+	select {
+	case res := <-ts.results:
+		assert.Equal(t, Succeeded, res)
+	case <-ctx.Done():
+		t.Fatal("resumed session did not end")
+	}
+}
+
+func TestProxyEndsSessionsNotResumedInGracePeriod(t *testing.T) {
+	ts := newResumeTestServer(t, "1")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, token := ts.dial(t, ctx, "")
+	conn.CloseNow()
+
+	select {
+	case res := <-ts.results:
+		assert.Equal(t, ResumeExpired, res)
+	case <-ctx.Done():
+		t.Fatal("parked session did not expire")
+	}
+	assert.ErrorIs(t, ts.pq.Resume(token, &ElementData{}), ErrResumeTokenNotFound)
+}
+
+func TestProxyDoesNotParkClosedSessions(t *testing.T) {
+	ts := newResumeTestServer(t, "5")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _ := ts.dial(t, ctx, "")
+	assert.Nil(t, conn.Close(websocket.StatusNormalClosure, ""))
+
+	select {
+	case res := <-ts.results:
+		assert.Equal(t, Succeeded, res)
+	case <-ctx.Done():
+		t.Fatal("closed session was parked")
+	}
+	assert.Equal(t, 0, ts.pq.parked.len())
+}
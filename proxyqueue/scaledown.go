@@ -0,0 +1,51 @@
+package proxyqueue
+
+import (
+	"sync"
+	"time"
+)
+
+// scaleDownController decides when the pool should retire an idle browser. The scaler has to ask for a scale-down
+// for the whole window before a browser is retired. The window restarts after every scale-up
+// or scale-down, and scale-down waits out a cooldown after any scaling so that the pool does not flap
+type scaleDownController struct {
+	window        time.Duration
+	cooldown      time.Duration
+	mutex         sync.Mutex
+	lowSince      time.Time
+	lastScaledAt  time.Time
+	isLowWaterSet bool
+}
+
+func newScaleDownController(window time.Duration, cooldown time.Duration) *scaleDownController {
+	return &scaleDownController{
+		window:   window,
+		cooldown: cooldown,
+	}
+}
+
+// observe records whether the scaler asked for a scale-down at now and returns true if a browser should be retired
+func (sdc *scaleDownController) observe(isLow bool, isQueueEmpty bool, now time.Time) bool {
+	sdc.mutex.Lock()
+	defer sdc.mutex.Unlock()
+
+	if !isLow || !isQueueEmpty {
+		sdc.isLowWaterSet = false
+		return false
+	}
+
+	if !sdc.isLowWaterSet {
+		sdc.isLowWaterSet = true
+		sdc.lowSince = now
+	}
+
+	return now.Sub(sdc.lowSince) >= sdc.window && now.Sub(sdc.lastScaledAt) >= sdc.cooldown
+}
+
+// scaled restarts the window and cooldown after the pool has been scaled up or down
+func (sdc *scaleDownController) scaled(now time.Time) {
+	sdc.mutex.Lock()
+	defer sdc.mutex.Unlock()
+	sdc.isLowWaterSet = false
+	sdc.lastScaledAt = now
+}
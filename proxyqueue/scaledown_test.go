@@ -0,0 +1,50 @@
+package proxyqueue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScaleDownRequiresSustainedScaleDownDecision(t *testing.T) {
+	sdc := newScaleDownController(10*time.Second, 0)
+	start := time.Now()
+
+	assert.False(t, sdc.observe(true, true, start))
+	assert.False(t, sdc.observe(true, true, start.Add(5*time.Second)))
+	assert.True(t, sdc.observe(true, true, start.Add(10*time.Second)))
+}
+
+func TestScaleDownWindowRestartsWhenScalerHolds(t *testing.T) {
+	sdc := newScaleDownController(10*time.Second, 0)
+	start := time.Now()
+
+	assert.False(t, sdc.observe(true, true, start))
+	assert.False(t, sdc.observe(false, true, start.Add(5*time.Second)))
+	assert.False(t, sdc.observe(true, true, start.Add(6*time.Second)))
+	assert.False(t, sdc.observe(true, true, start.Add(15*time.Second)))
+	assert.True(t, sdc.observe(true, true, start.Add(16*time.Second)))
+}
+
+func TestScaleDownWaitsForEmptyQueue(t *testing.T) {
+	sdc := newScaleDownController(0, 0)
+	start := time.Now()
+
+	assert.False(t, sdc.observe(true, false, start))
+	assert.True(t, sdc.observe(true, true, start))
+}
+
+func TestScaleDownCooldownAfterScaling(t *testing.T) {
+	sdc := newScaleDownController(5*time.Second, 30*time.Second)
+	start := time.Now()
+
+	sdc.scaled(start)
+	assert.False(t, sdc.observe(true, true, start))
+	assert.False(t, sdc.observe(true, true, start.Add(10*time.Second)))
+	assert.True(t, sdc.observe(true, true, start.Add(30*time.Second)))
+
+	// scaling down restarts the window and cooldown
+	sdc.scaled(start.Add(30 * time.Second))
+	assert.False(t, sdc.observe(true, true, start.Add(40*time.Second)))
+	assert.True(t, sdc.observe(true, true, start.Add(60*time.Second)))
+}
@@ -0,0 +1,124 @@
+package proxyqueue
+
+import (
+	"context"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+	"sync"
+	"time"
+)
+
+// IClosableConnection - only expose the connection function needed to end a session
+type IClosableConnection interface {
+	Close(code websocket.StatusCode, reason string) error
+}
+
+// activeSessions tracks every session taken off the queue until it finishes, so that shutdown can wait for
+// sessions and close the ones still running, and page connections can be routed to their session. The zero value is
+// ready to use
+type activeSessions struct {
+	mutex    sync.Mutex
+	sessions map[uuid.UUID]*activeSession
+}
+
+type activeSession struct {
+	done             chan struct{} // closed once the session has finished
+	conn             IClosableConnection
+	eld              *ElementData
+	browserContextID cdp.BrowserContextID
+}
+
+// add registers a session before it has a client connection
+func (as *activeSessions) add(sessionId uuid.UUID) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if as.sessions == nil {
+		as.sessions = make(map[uuid.UUID]*activeSession)
+	}
+	as.sessions[sessionId] = &activeSession{done: make(chan struct{})}
+}
+
+// setConn sets the client connection of a registered session
+func (as *activeSessions) setConn(sessionId uuid.UUID, conn IClosableConnection) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if session, exists := as.sessions[sessionId]; exists {
+		session.conn = conn
+	}
+}
+
+// setPageOwner allows page connections to a registered session once it has a browser. Page targets must belong to
+// browserContextID if it is set
+func (as *activeSessions) setPageOwner(eld *ElementData, browserContextID cdp.BrowserContextID) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if session, exists := as.sessions[eld.SessionId]; exists {
+		session.eld = eld
+		session.browserContextID = browserContextID
+	}
+}
+
+// get returns a copy of the session with sessionId, or false if it is not running or has no browser yet
+func (as *activeSessions) get(sessionId uuid.UUID) (activeSession, bool) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	session, exists := as.sessions[sessionId]
+	if !exists || session.eld == nil {
+		return activeSession{}, false
+	}
+	return *session, true
+}
+
+func (as *activeSessions) remove(sessionId uuid.UUID) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if session, exists := as.sessions[sessionId]; exists {
+		close(session.done)
+		delete(as.sessions, sessionId)
+	}
+}
+
+func (as *activeSessions) len() int {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	return len(as.sessions)
+}
+
+// closeAll closes every client connection with code and returns the number of connections closed
+func (as *activeSessions) closeAll(code websocket.StatusCode, reason string) int {
+	as.mutex.Lock()
+	conns := make([]IClosableConnection, 0, len(as.sessions))
+	for _, session := range as.sessions {
+		if session.conn != nil {
+			conns = append(conns, session.conn)
+		}
+	}
+	as.mutex.Unlock()
+
+	// Close waits for the client to acknowledge, close every connection at once
+	wg := sync.WaitGroup{}
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn IClosableConnection) {
+			defer wg.Done()
+			_ = conn.Close(code, reason)
+		}(conn)
+	}
+	wg.Wait()
+	return len(conns)
+}
+
+// wait blocks until every session has finished or ctx is done. Returns false if sessions are still running
+func (as *activeSessions) wait(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for as.len() > 0 {
+		select {
+		case <-ctx.Done():
+			return as.len() == 0
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/websocketproxy"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// rateLimits returns the default rate limits, overridden by those of the session's profile and then its tenant
+func (pqe *ElementData) rateLimits() config.RateLimits {
+	conf := config.Get().GetRateLimitConfig()
+	limits := conf.Default
+	if profileLimits, exists := conf.Profiles[pqe.ChromeOptions.Profile]; exists {
+		limits = limits.Override(&profileLimits)
+	}
+	return limits.Override(pqe.Tenant.GetRateLimits())
+}
+
+// newShaper creates the shaper for messages read from direction. Time spent throttled is counted in ThrottleWait
+func (pqe *ElementData) newShaper(limit *config.RateLimit, direction websocketproxy.Types) *websocketproxy.Shaper {
+	var messages, bytes *rate.Limiter
+	if limit != nil && limit.MessagesPerSec > 0 {
+		messages = rate.NewLimiter(rate.Limit(limit.MessagesPerSec), burstOrRate(limit.MessageBurst, limit.MessagesPerSec))
+	}
+	if limit != nil && limit.BytesPerSec > 0 {
+		bytes = rate.NewLimiter(rate.Limit(limit.BytesPerSec), burstOrRate(limit.ByteBurst, limit.BytesPerSec))
+	}
+
+	shaper := websocketproxy.NewShaper(messages, bytes)
+	labels := append(pqe.metricLabels(), metrics.Label{Name: metrics.DirectionLabel, Value: string(direction)})
+	shaper.OnWait(func(waited time.Duration) {
+		metrics.Get().Remote.IncCounterWithLabels(metrics.ThrottleWait, float32(waited.Seconds()), labels)
+	})
+	return shaper
+}
+
+// burstOrRate returns burst, or one second of perSec if burst is not set
+func burstOrRate(burst int, perSec float64) int {
+	if burst > 0 {
+		return burst
+	}
+	return max(int(perSec), 1)
+}
@@ -0,0 +1,34 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/tenant"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestRateLimitsAreOverriddenByProfileThenTenant(t *testing.T) {
+	t.Setenv(config.ClientMessagesPerSec, "50")
+	t.Setenv(config.ProfileRateLimits, `{"checkout":{"client":{"messagesPerSec":20},"chrome":{"bytesPerSec":1000}}}`)
+	config.Once = sync.Once{}
+
+	pqe := &ElementData{}
+	assert.Equal(t, float64(50), pqe.rateLimits().Client.MessagesPerSec)
+
+	pqe.ChromeOptions.Profile = "checkout"
+	limits := pqe.rateLimits()
+	assert.Equal(t, float64(20), limits.Client.MessagesPerSec)
+	assert.Equal(t, float64(1000), limits.Chrome.BytesPerSec)
+
+	pqe.Tenant = &tenant.Tenant{Name: "acme", RateLimits: &config.RateLimits{Client: &config.RateLimit{MessagesPerSec: 5}}}
+	limits = pqe.rateLimits()
+	assert.Equal(t, float64(5), limits.Client.MessagesPerSec)
+	assert.Equal(t, float64(1000), limits.Chrome.BytesPerSec)
+}
+
+func TestBurstOrRate(t *testing.T) {
+	assert.Equal(t, 7, burstOrRate(7, 100))
+	assert.Equal(t, 100, burstOrRate(0, 100))
+	assert.Equal(t, 1, burstOrRate(0, 0.5))
+}
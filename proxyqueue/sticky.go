@@ -0,0 +1,58 @@
+package proxyqueue
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/logger"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"regexp"
+)
+
+// StickySessionParam pins a browser to a key chosen by the client, e.g. /connect?session={key}. Every session of the
+// tenant connecting with the key gets the same browser until the pin is released
+const StickySessionParam = "session"
+
+// stickySessionKeyPattern keeps keys usable in the path of the release endpoint
+var stickySessionKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// getStickySessionKey returns the key of the session connect param, or "" if the session is not sticky
+func getStickySessionKey(r *http.Request) (string, error) {
+	key := r.URL.Query().Get(StickySessionParam)
+	if len(key) > 0 && !stickySessionKeyPattern.MatchString(key) {
+		return "", errors.New(fmt.Sprintf("req.query['%s'] must be 1 to 128 letters, digits, '.', '_' or '-', received %s", StickySessionParam, key))
+	}
+	return key, nil
+}
+
+// StickySessionPin returns the pin of the sticky session key of the caller of r. Keys are scoped to the owner of the
+// request, so that callers never share a browser
+func StickySessionPin(r *http.Request, key string) chromepool.Pin {
+	return chromepool.Pin{Tenant: getOwner(r), Key: key}
+}
+
+// getChrome returns the browser pinned to the session's sticky session key, or an available browser if the session is
+// not sticky. release hands the browser back once the session has ended
+func (pqe *ElementData) getChrome() (crm *chrome.IChrome, release func(), err error) {
+	cp := chromePoolGet()
+	sessionId := pqe.R.Context().Value(logger.SessionIdTrackingKey).(uuid.UUID)
+
+	if len(pqe.StickySession) == 0 {
+		crm, err = cp.GetAvailableChrome(sessionId, pqe.ChromeOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		return crm, (*crm).SetIdleOrStop, nil
+	}
+
+	pin := StickySessionPin(pqe.R, pqe.StickySession)
+	crm, err = cp.GetPinnedChrome(pin, pqe.Tenant.PinnedBrowserLimit(), sessionId, pqe.ChromeOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return crm, func() {
+		cp.ReturnPinnedChrome(pin)
+	}, nil
+}
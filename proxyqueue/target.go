@@ -0,0 +1,40 @@
+package proxyqueue
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ConnectTarget is what the client websocket of a session is connected to
+type ConnectTarget string
+
+const (
+	BrowserTarget ConnectTarget = "browser" // the browser endpoint, like the webSocketDebuggerUrl of /json/version
+	PageTarget    ConnectTarget = "page"    // a page opened for the session, like the targets of /json/list
+)
+
+const (
+	TargetParam  = "target"
+	PageUrlParam = "url"
+)
+
+// defaultPageUrl is the url page targets are opened at if the url connect param is not set
+const defaultPageUrl = "about:blank"
+
+// getConnectTarget returns the target requested with the target connect param and the url to open page targets at
+func getConnectTarget(r *http.Request) (ConnectTarget, string, error) {
+	query := r.URL.Query()
+	switch t := ConnectTarget(query.Get(TargetParam)); t {
+	case "", BrowserTarget:
+		return BrowserTarget, "", nil
+	case PageTarget:
+		pageUrl := query.Get(PageUrlParam)
+		if len(pageUrl) == 0 {
+			pageUrl = defaultPageUrl
+		}
+		return PageTarget, pageUrl, nil
+	default:
+		return "", "", errors.New(fmt.Sprintf("req.query['%s'] must be %s or %s, received %s", TargetParam, BrowserTarget, PageTarget, t))
+	}
+}
@@ -0,0 +1,143 @@
+package recorder
+
+import (
+	"bufio"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/websocketproxy"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	TextMessage   = "text"
+	BinaryMessage = "binary"
+)
+
+// Entry is a single line of a session recording. Message holds valid JSON messages as is,
+// anything else is base64 encoded in Data
+type Entry struct {
+	Time    time.Time            `json:"time"`
+	From    websocketproxy.Types `json:"from"`
+	Type    string               `json:"type"`
+	Message json.RawMessage      `json:"message,omitempty"`
+	Data    []byte               `json:"data,omitempty"`
+}
+
+// Recorder writes the full bidirectional message stream of a session to <dir>/<sessionId>.jsonl
+type Recorder struct {
+	sessionId uuid.UUID
+	file      *os.File
+	writer    *bufio.Writer
+	encoder   *json.Encoder
+	mutex     sync.Mutex
+	closed    bool
+}
+
+func New(dir string, sessionId uuid.UUID) (*Recorder, error) {
+	// recordings hold page content and cookies, so only the proxy's user may read them
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(GetRecordingPath(dir, sessionId), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	return &Recorder{
+		sessionId: sessionId,
+		file:      file,
+		writer:    writer,
+		encoder:   json.NewEncoder(writer),
+	}, nil
+}
+
+func GetRecordingPath(dir string, sessionId uuid.UUID) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.jsonl", sessionId.String()))
+}
+
+func (r *Recorder) Record(from websocketproxy.Types, msgT websocket.MessageType, msg []byte) {
+	e := Entry{
+		Time: time.Now(),
+		From: from,
+	}
+
+	if msgT == websocket.MessageText && json.Valid(msg) {
+		e.Type = TextMessage
+		e.Message = append(json.RawMessage{}, msg...)
+	} else {
+		e.Type = BinaryMessage
+		if msgT == websocket.MessageText {
+			e.Type = TextMessage
+		}
+		e.Data = append([]byte{}, msg...)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// the proxy loop of one direction can still be reading after the session has ended
+	if r.closed {
+		return
+	}
+	if err := r.encoder.Encode(e); err != nil {
+		log := logger.Get()
+		log.Err(err).Str("sessionId", r.sessionId.String()).Msg("unable to record message")
+	}
+}
+
+// Close flushes buffered entries and closes the recording file. Messages recorded afterwards are dropped
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.writer.Flush(); err != nil {
+		_ = r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// ReadRecording reads every entry of a recording file
+func ReadRecording(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var e Entry
+		if err = decoder.Decode(&e); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Bytes returns the message as it was sent over the websocket
+func (e *Entry) Bytes() []byte {
+	if len(e.Message) > 0 {
+		return e.Message
+	}
+	return e.Data
+}
+
+func (e *Entry) MessageType() websocket.MessageType {
+	if e.Type == BinaryMessage {
+		return websocket.MessageBinary
+	}
+	return websocket.MessageText
+}
@@ -0,0 +1,76 @@
+package recorder
+
+import (
+	"chromium-websocket-proxy/websocketproxy"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderWritesReadableRecording(t *testing.T) {
+	dir := t.TempDir()
+	sessionId := uuid.New()
+
+	r, err := New(dir, sessionId)
+	assert.Nil(t, err)
+
+	r.Record(websocketproxy.Client, websocket.MessageText, []byte(`{"id":1,"method":"Target.getTargets"}`))
+	r.Record(websocketproxy.Chrome, websocket.MessageText, []byte(`{"id":1,"result":{"targetInfos":[]}}`))
+	r.Record(websocketproxy.Client, websocket.MessageText, []byte(`not json`))
+	r.Record(websocketproxy.Chrome, websocket.MessageBinary, []byte{0x00, 0x01})
+	assert.Nil(t, r.Close())
+
+	entries, err := ReadRecording(GetRecordingPath(dir, sessionId))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 4)
+
+	assert.Equal(t, websocketproxy.Client, entries[0].From)
+	assert.JSONEq(t, `{"id":1,"method":"Target.getTargets"}`, string(entries[0].Bytes()))
+	assert.Equal(t, websocket.MessageText, entries[0].MessageType())
+	assert.False(t, entries[0].Time.IsZero())
+
+	assert.Equal(t, websocketproxy.Chrome, entries[1].From)
+	assert.JSONEq(t, `{"id":1,"result":{"targetInfos":[]}}`, string(entries[1].Bytes()))
+
+	assert.Equal(t, []byte("not json"), entries[2].Bytes())
+	assert.Equal(t, websocket.MessageText, entries[2].MessageType())
+
+	assert.Equal(t, []byte{0x00, 0x01}, entries[3].Bytes())
+	assert.Equal(t, websocket.MessageBinary, entries[3].MessageType())
+}
+
+func TestRecordingIsOnlyReadableByOwner(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recordings")
+	sessionId := uuid.New()
+
+	r, err := New(dir, sessionId)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+
+	info, err := os.Stat(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	info, err = os.Stat(GetRecordingPath(dir, sessionId))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestRecorderDropsMessagesAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	sessionId := uuid.New()
+
+	r, err := New(dir, sessionId)
+	assert.Nil(t, err)
+
+	r.Record(websocketproxy.Client, websocket.MessageText, []byte(`{"id":1}`))
+	assert.Nil(t, r.Close())
+	r.Record(websocketproxy.Chrome, websocket.MessageText, []byte(`{"id":1,"result":{}}`))
+	assert.Nil(t, r.Close())
+
+	entries, err := ReadRecording(GetRecordingPath(dir, sessionId))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,397 @@
+package recorder
+
+import (
+	"chromium-websocket-proxy/cdpmessage"
+	"chromium-websocket-proxy/websocketproxy"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Divergence is a command whose replayed response does not match the recorded response
+type Divergence struct {
+	Id       int64           `json:"id"`
+	Method   string          `json:"method"`
+	Reason   string          `json:"reason"`
+	Recorded json.RawMessage `json:"recorded,omitempty"`
+	Replayed json.RawMessage `json:"replayed,omitempty"`
+}
+
+type responseKey struct {
+	sessionId string
+	id        int64
+}
+
+// Replayer sends the client messages of a recording to chrome and compares chrome's responses with the recorded ones.
+// Ids created by chrome, e.g. targetId and sessionId, differ between runs. They are mapped from recorded to
+// replayed values by comparing recorded and replayed responses and events, and rewritten in every command sent
+type Replayer struct {
+	conn             websocketproxy.IWebsocketProxyConnection
+	responseTimeout  time.Duration
+	mutex            sync.Mutex
+	ids              map[string]string
+	chromeIds        map[string]bool
+	recordedEvents   map[string][]interface{}
+	replayedEvents   map[string]int
+	pendingResponses map[responseKey]chan []byte
+}
+
+func NewReplayer(conn websocketproxy.IWebsocketProxyConnection, responseTimeout time.Duration) *Replayer {
+	return &Replayer{
+		conn:             conn,
+		responseTimeout:  responseTimeout,
+		ids:              make(map[string]string),
+		chromeIds:        make(map[string]bool),
+		recordedEvents:   make(map[string][]interface{}),
+		replayedEvents:   make(map[string]int),
+		pendingResponses: make(map[responseKey]chan []byte),
+	}
+}
+
+// Replay sends every client message in entries in order, waiting for the response to each command before sending
+// the next one. It returns every command whose response diverged from the recording
+func (rp *Replayer) Replay(ctx context.Context, entries []Entry) ([]Divergence, error) {
+	recordedResponses := rp.indexRecording(entries)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go rp.readLoop(ctx)
+
+	var divergences []Divergence
+	for _, e := range entries {
+		if e.From != websocketproxy.Client {
+			continue
+		}
+
+		msg := e.Bytes()
+		cmd, err := cdpmessage.Parse(msg)
+		if err != nil || !cmd.IsCommand() {
+			if err = rp.send(ctx, e, msg); err != nil {
+				return divergences, err
+			}
+			continue
+		}
+
+		msg, cmd, err = rp.rewriteCommand(ctx, msg)
+		if err != nil {
+			return divergences, err
+		}
+
+		responseC := make(chan []byte, 1)
+		key := responseKey{sessionId: cmd.SessionId, id: *cmd.Id}
+		rp.mutex.Lock()
+		rp.pendingResponses[key] = responseC
+		rp.mutex.Unlock()
+
+		if err = rp.send(ctx, e, msg); err != nil {
+			return divergences, err
+		}
+
+		recorded := recordedResponses[responseKey{sessionId: e.sessionId(), id: *cmd.Id}]
+		select {
+		case replayed := <-responseC:
+			if d := rp.compare(cmd, recorded, replayed); d != nil {
+				divergences = append(divergences, *d)
+			}
+		case <-time.After(rp.responseTimeout):
+			divergences = append(divergences, Divergence{
+				Id:       *cmd.Id,
+				Method:   cmd.Method,
+				Reason:   fmt.Sprintf("no response within %v", rp.responseTimeout),
+				Recorded: recorded,
+			})
+		case <-ctx.Done():
+			return divergences, ctx.Err()
+		}
+
+		rp.mutex.Lock()
+		delete(rp.pendingResponses, key)
+		rp.mutex.Unlock()
+	}
+	return divergences, nil
+}
+
+// indexRecording returns the recorded responses by command and records every id created by chrome
+func (rp *Replayer) indexRecording(entries []Entry) map[responseKey]json.RawMessage {
+	responses := make(map[responseKey]json.RawMessage)
+	for _, e := range entries {
+		if e.From != websocketproxy.Chrome {
+			continue
+		}
+		msg, err := cdpmessage.Parse(e.Bytes())
+		if err != nil {
+			continue
+		}
+
+		var v interface{}
+		_ = json.Unmarshal(e.Bytes(), &v)
+		collectIds(v, "", rp.chromeIds)
+
+		if msg.IsResponse() {
+			responses[responseKey{sessionId: msg.SessionId, id: *msg.Id}] = e.Bytes()
+		} else if msg.IsEvent() {
+			rp.recordedEvents[msg.Method] = append(rp.recordedEvents[msg.Method], v)
+		}
+	}
+	return responses
+}
+
+func (rp *Replayer) readLoop(ctx context.Context) {
+	for {
+		_, reader, err := rp.conn.Reader(ctx)
+		if err != nil {
+			return
+		}
+		b, err := io.ReadAll(reader)
+		if err != nil {
+			return
+		}
+		msg, err := cdpmessage.Parse(b)
+		if err != nil {
+			continue
+		}
+
+		rp.mutex.Lock()
+		if msg.IsResponse() {
+			if responseC, exists := rp.pendingResponses[responseKey{sessionId: msg.SessionId, id: *msg.Id}]; exists {
+				responseC <- b
+			}
+		} else if msg.IsEvent() {
+			// pair the nth replayed event of a method with the nth recorded one to learn the ids chrome created
+			i := rp.replayedEvents[msg.Method]
+			rp.replayedEvents[msg.Method]++
+			if i < len(rp.recordedEvents[msg.Method]) {
+				var v interface{}
+				_ = json.Unmarshal(b, &v)
+				rp.learnIds(rp.recordedEvents[msg.Method][i], v, "")
+			}
+		}
+		rp.mutex.Unlock()
+	}
+}
+
+func (rp *Replayer) send(ctx context.Context, e Entry, msg []byte) error {
+	writer, err := rp.conn.Writer(ctx, e.MessageType())
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(msg); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// rewriteCommand replaces recorded chrome ids in msg with their replayed values. If msg references an id chrome has
+// not created yet in the replay, it waits up to responseTimeout for the id to show up in a response or event
+func (rp *Replayer) rewriteCommand(ctx context.Context, msg []byte) ([]byte, *cdpmessage.Message, error) {
+	var v interface{}
+	if err := json.Unmarshal(msg, &v); err != nil {
+		return nil, nil, err
+	}
+
+	referenced := make(map[string]bool)
+	collectIds(v, "", referenced)
+
+	deadline := time.Now().Add(rp.responseTimeout)
+	for time.Now().Before(deadline) && rp.hasUnmappedIds(referenced) {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	rp.mutex.Lock()
+	v = replaceIds(v, "", rp.ids)
+	rp.mutex.Unlock()
+
+	rewritten, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd, err := cdpmessage.Parse(rewritten)
+	return rewritten, cmd, err
+}
+
+func (rp *Replayer) hasUnmappedIds(referenced map[string]bool) bool {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	for id := range referenced {
+		if _, mapped := rp.ids[id]; rp.chromeIds[id] && !mapped {
+			return true
+		}
+	}
+	return false
+}
+
+func (rp *Replayer) compare(cmd *cdpmessage.Message, recorded json.RawMessage, replayed []byte) *Divergence {
+	d := &Divergence{
+		Id:       *cmd.Id,
+		Method:   cmd.Method,
+		Recorded: recorded,
+		Replayed: replayed,
+	}
+
+	if recorded == nil {
+		d.Reason = "no response was recorded"
+		return d
+	}
+
+	recordedMsg, err := cdpmessage.Parse(recorded)
+	if err != nil {
+		d.Reason = "recorded response is not valid cdp"
+		return d
+	}
+	replayedMsg, err := cdpmessage.Parse(replayed)
+	if err != nil {
+		d.Reason = "replayed response is not valid cdp"
+		return d
+	}
+
+	if recordedMsg.Error != nil && replayedMsg.Error == nil {
+		d.Reason = fmt.Sprintf("recorded error '%s' but replay succeeded", recordedMsg.Error.Message)
+		return d
+	}
+	if recordedMsg.Error == nil && replayedMsg.Error != nil {
+		d.Reason = fmt.Sprintf("replay failed with error '%s'", replayedMsg.Error.Message)
+		return d
+	}
+
+	var recordedResult, replayedResult map[string]interface{}
+	_ = json.Unmarshal(recordedMsg.Result, &recordedResult)
+	_ = json.Unmarshal(replayedMsg.Result, &replayedResult)
+
+	rp.mutex.Lock()
+	rp.learnIds(recordedResult, replayedResult, "")
+	rp.mutex.Unlock()
+
+	if recordedKeys, replayedKeys := sortedKeys(recordedResult), sortedKeys(replayedResult); !reflect.DeepEqual(recordedKeys, replayedKeys) {
+		d.Reason = fmt.Sprintf("result fields %v do not match recorded fields %v", replayedKeys, recordedKeys)
+		return d
+	}
+	for _, k := range sortedKeys(recordedResult) {
+		if !reflect.DeepEqual(maskIds(recordedResult[k], k), maskIds(replayedResult[k], k)) {
+			d.Reason = fmt.Sprintf("result field '%s' does not match the recorded value", k)
+			return d
+		}
+	}
+	return nil
+}
+
+// learnIds maps recorded ids to replayed ids found at the same place in both values. Must be called with the mutex held
+func (rp *Replayer) learnIds(recorded interface{}, replayed interface{}, key string) {
+	switch r := recorded.(type) {
+	case map[string]interface{}:
+		p, ok := replayed.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k := range r {
+			rp.learnIds(r[k], p[k], k)
+		}
+	case []interface{}:
+		p, ok := replayed.([]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < len(r) && i < len(p); i++ {
+			rp.learnIds(r[i], p[i], key)
+		}
+	case string:
+		p, ok := replayed.(string)
+		if !ok || !isIdKey(key) {
+			return
+		}
+		if _, exists := rp.ids[r]; !exists {
+			rp.ids[r] = p
+		}
+	}
+}
+
+func isIdKey(key string) bool {
+	return strings.HasSuffix(key, "Id")
+}
+
+// collectIds adds every string id in v to ids
+func collectIds(v interface{}, key string, ids map[string]bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			collectIds(t[k], k, ids)
+		}
+	case []interface{}:
+		for i := range t {
+			collectIds(t[i], key, ids)
+		}
+	case string:
+		if isIdKey(key) {
+			ids[t] = true
+		}
+	}
+}
+
+// replaceIds returns v with every string id replaced by its mapped value
+func replaceIds(v interface{}, key string, ids map[string]string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = replaceIds(t[k], k, ids)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = replaceIds(t[i], key, ids)
+		}
+	case string:
+		if mapped, exists := ids[t]; exists && isIdKey(key) {
+			return mapped
+		}
+	}
+	return v
+}
+
+// maskIds returns a copy of v with every string id replaced by a placeholder, since chrome creates new ids on every run
+func maskIds(v interface{}, key string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(t))
+		for k := range t {
+			masked[k] = maskIds(t[k], k)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(t))
+		for i := range t {
+			masked[i] = maskIds(t[i], key)
+		}
+		return masked
+	case string:
+		if isIdKey(key) {
+			return "<id>"
+		}
+	}
+	return v
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sessionId returns the cdp session the entry was sent on
+func (e *Entry) sessionId() string {
+	msg, err := cdpmessage.Parse(e.Bytes())
+	if err != nil {
+		return ""
+	}
+	return msg.SessionId
+}
@@ -0,0 +1,159 @@
+package recorder
+
+import (
+	"bytes"
+	"chromium-websocket-proxy/websocketproxy"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"nhooyr.io/websocket"
+	"testing"
+	"time"
+)
+
+// mockChrome answers every command written to it with the messages returned by handle
+type mockChrome struct {
+	handle   func(cmd map[string]interface{}) []string
+	received []map[string]interface{}
+	messages chan []byte
+}
+
+type mockChromeWriter struct {
+	chrome *mockChrome
+	buf    bytes.Buffer
+}
+
+func (w *mockChromeWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *mockChromeWriter) Close() error {
+	var cmd map[string]interface{}
+	if err := json.Unmarshal(w.buf.Bytes(), &cmd); err != nil {
+		return err
+	}
+	w.chrome.received = append(w.chrome.received, cmd)
+	for _, msg := range w.chrome.handle(cmd) {
+		w.chrome.messages <- []byte(msg)
+	}
+	return nil
+}
+
+func newMockChrome(handle func(cmd map[string]interface{}) []string) *mockChrome {
+	return &mockChrome{
+		handle:   handle,
+		messages: make(chan []byte, 10),
+	}
+}
+
+func (mc *mockChrome) Reader(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+	select {
+	case msg := <-mc.messages:
+		return websocket.MessageText, bytes.NewReader(msg), nil
+	case <-ctx.Done():
+		return websocket.MessageText, nil, ctx.Err()
+	}
+}
+
+func (mc *mockChrome) Writer(_ context.Context, _ websocket.MessageType) (io.WriteCloser, error) {
+	return &mockChromeWriter{chrome: mc}, nil
+}
+
+func entry(from websocketproxy.Types, msg string) Entry {
+	return Entry{From: from, Type: TextMessage, Message: json.RawMessage(msg)}
+}
+
+var recording = []Entry{
+	entry(websocketproxy.Client, `{"id":1,"method":"Target.createTarget","params":{"url":"about:blank"}}`),
+	entry(websocketproxy.Chrome, `{"method":"Target.targetCreated","params":{"targetInfo":{"targetId":"recorded-target"}}}`),
+	entry(websocketproxy.Chrome, `{"id":1,"result":{"targetId":"recorded-target"}}`),
+	entry(websocketproxy.Client, `{"id":2,"method":"Target.attachToTarget","params":{"targetId":"recorded-target","flatten":true}}`),
+	entry(websocketproxy.Chrome, `{"id":2,"result":{"sessionId":"recorded-session"}}`),
+	entry(websocketproxy.Client, `{"id":3,"method":"Page.navigate","sessionId":"recorded-session","params":{"url":"https://example.com"}}`),
+	entry(websocketproxy.Chrome, `{"id":3,"sessionId":"recorded-session","result":{"frameId":"recorded-frame","loaderId":"recorded-loader"}}`),
+}
+
+func TestReplayMapsChromeIdsAndReportsNoDivergence(t *testing.T) {
+	chrome := newMockChrome(func(cmd map[string]interface{}) []string {
+		switch cmd["method"] {
+		case "Target.createTarget":
+			return []string{
+				`{"method":"Target.targetCreated","params":{"targetInfo":{"targetId":"replayed-target"}}}`,
+				`{"id":1,"result":{"targetId":"replayed-target"}}`,
+			}
+		case "Target.attachToTarget":
+			return []string{`{"id":2,"result":{"sessionId":"replayed-session"}}`}
+		case "Page.navigate":
+			return []string{`{"id":3,"sessionId":"replayed-session","result":{"frameId":"replayed-frame","loaderId":"replayed-loader"}}`}
+		}
+		return nil
+	})
+
+	divergences, err := NewReplayer(chrome, time.Second).Replay(context.Background(), recording)
+	assert.Nil(t, err)
+	assert.Empty(t, divergences)
+
+	assert.Len(t, chrome.received, 3)
+	assert.Equal(t, "replayed-target", chrome.received[1]["params"].(map[string]interface{})["targetId"])
+	assert.Equal(t, "replayed-session", chrome.received[2]["sessionId"])
+	assert.Equal(t, "https://example.com", chrome.received[2]["params"].(map[string]interface{})["url"])
+}
+
+func TestReplayReportsDivergingResponses(t *testing.T) {
+	chrome := newMockChrome(func(cmd map[string]interface{}) []string {
+		switch cmd["method"] {
+		case "Target.createTarget":
+			return []string{`{"id":1,"result":{"targetId":"replayed-target"}}`}
+		case "Target.attachToTarget":
+			return []string{`{"id":2,"error":{"code":-32602,"message":"No target with given id found"}}`}
+		}
+		// Page.navigate is never answered
+		return nil
+	})
+
+	divergences, err := NewReplayer(chrome, 100*time.Millisecond).Replay(context.Background(), recording)
+	assert.Nil(t, err)
+	assert.Len(t, divergences, 2)
+
+	assert.Equal(t, int64(2), divergences[0].Id)
+	assert.Equal(t, "Target.attachToTarget", divergences[0].Method)
+	assert.Contains(t, divergences[0].Reason, "No target with given id found")
+
+	assert.Equal(t, int64(3), divergences[1].Id)
+	assert.Equal(t, "Page.navigate", divergences[1].Method)
+	assert.Contains(t, divergences[1].Reason, "no response")
+}
+
+func TestReplayReportsDifferentResultFields(t *testing.T) {
+	chrome := newMockChrome(func(cmd map[string]interface{}) []string {
+		return []string{`{"id":1,"result":{"targetId":"replayed-target","extra":true}}`}
+	})
+
+	divergences, err := NewReplayer(chrome, 100*time.Millisecond).Replay(context.Background(), recording[:3])
+	assert.Nil(t, err)
+	assert.Len(t, divergences, 1)
+	assert.Contains(t, divergences[0].Reason, "result fields")
+}
+
+func TestReplayReportsDifferentResultValues(t *testing.T) {
+	recorded := []Entry{
+		entry(websocketproxy.Client, `{"id":1,"method":"Runtime.evaluate","params":{"expression":"document.title"}}`),
+		entry(websocketproxy.Chrome, `{"id":1,"result":{"result":{"type":"string","value":"Example","objectId":"recorded-object"}}}`),
+		entry(websocketproxy.Client, `{"id":2,"method":"Runtime.evaluate","params":{"expression":"location.href"}}`),
+		entry(websocketproxy.Chrome, `{"id":2,"result":{"result":{"type":"string","value":"https://example.com/"}}}`),
+	}
+	chrome := newMockChrome(func(cmd map[string]interface{}) []string {
+		if cmd["id"] == float64(1) {
+			// only the volatile object id differs
+			return []string{`{"id":1,"result":{"result":{"type":"string","value":"Example","objectId":"replayed-object"}}}`}
+		}
+		return []string{`{"id":2,"result":{"result":{"type":"string","value":"https://example.org/"}}}`}
+	})
+
+	divergences, err := NewReplayer(chrome, 100*time.Millisecond).Replay(context.Background(), recorded)
+	assert.Nil(t, err)
+	assert.Len(t, divergences, 1)
+	assert.Equal(t, int64(2), divergences[0].Id)
+	assert.Contains(t, divergences[0].Reason, "'result'")
+}
@@ -0,0 +1,179 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/proxyqueue"
+	"crypto/subtle"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminBrowsersPath = "/admin/browsers"
+
+type AdminBrowser struct {
+	BrowserId  string    `json:"browserId"`
+	Port       int       `json:"port"`
+	Profile    string    `json:"profile"`
+	SessionId  string    `json:"sessionId,omitempty"`
+	IsIdle     bool      `json:"isIdle"`
+	IsNew      bool      `json:"isNew"`
+	StartedAt  time.Time `json:"startedAt"`
+	UptimeSecs float64   `json:"uptimeSecs"`
+}
+
+type AdminBrowsersResponse struct {
+	Browsers []AdminBrowser `json:"browsers"`
+}
+
+type AdminQueueResponse struct {
+	Sessions []proxyqueue.QueuedSession `json:"sessions"`
+}
+
+type AdminPoolResponse struct {
+	Stopped int    `json:"stopped,omitempty"`
+	Started int    `json:"started,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (sm *ServeMux) registerAdminHandlers() {
+	sm.mux.HandleFunc(adminBrowsersPath, sm.adminAuthMiddleware(sm.adminListBrowsers))
+	sm.mux.HandleFunc(adminBrowsersPath+"/", sm.adminAuthMiddleware(sm.adminStopBrowser))
+	sm.mux.HandleFunc("/admin/pool/drain", sm.adminAuthMiddleware(sm.adminDrainPool))
+	sm.mux.HandleFunc("/admin/pool/prewarm", sm.adminAuthMiddleware(sm.adminPrewarmPool))
+	sm.mux.HandleFunc("/admin/queue", sm.adminAuthMiddleware(sm.adminListQueue))
+}
+
+// adminAuthMiddleware requires the ADMIN_ACCESS_TOKEN as a bearer token. It is never read from the query string
+// so that it does not end up in access logs
+func (sm *ServeMux) adminAuthMiddleware(f ServeRequest) ServeRequest {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accessToken := []byte(config.Get().GetServerConfig().AdminAccessToken)
+		bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if len(accessToken) == 0 || !found || subtle.ConstantTimeCompare([]byte(bearer), accessToken) != 1 {
+			writeErrorResponse(w, http.StatusUnauthorized, ErrorCodeUnauthorized, fmt.Sprintf("Authorization header does not contain the required %s bearer token", config.AdminAccessToken))
+			return
+		}
+		f(w, r)
+	}
+}
+
+// adminListBrowsers handles GET /admin/browsers
+func (sm *ServeMux) adminListBrowsers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	now := time.Now()
+	instances := chromePoolGet().GetInstances()
+	res := AdminBrowsersResponse{
+		Browsers: make([]AdminBrowser, 0, len(instances)),
+	}
+	for _, crm := range instances {
+		b := AdminBrowser{
+			BrowserId:  crm.BrowserID().String(),
+			Port:       crm.Port(),
+			Profile:    crm.Options().Profile,
+			IsIdle:     crm.IsIdle(),
+			IsNew:      crm.IsNew(),
+			StartedAt:  crm.StartedAt(),
+			UptimeSecs: now.Sub(crm.StartedAt()).Seconds(),
+		}
+		if crm.SessionId() != uuid.Nil {
+			b.SessionId = crm.SessionId().String()
+		}
+		res.Browsers = append(res.Browsers, b)
+	}
+	writeJsonResponse(w, http.StatusOK, res)
+}
+
+// adminStopBrowser handles DELETE /admin/browsers/{browserId}, force killing the browser and any session using it
+func (sm *ServeMux) adminStopBrowser(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	browserID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, adminBrowsersPath+"/"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusNotFound, ErrorCodeNotFound, "invalid browser id")
+		return
+	}
+
+	if err = chromePoolGet().StopInstance(browserID); err != nil {
+		writeErrorResponse(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	log := logger.Get()
+	log.Info().Ctx(r.Context()).Str("browserId", browserID.String()).Msg("browser stopped through admin api")
+	writeJsonResponse(w, http.StatusOK, AdminPoolResponse{Stopped: 1})
+}
+
+// adminDrainPool handles POST /admin/pool/drain, replacing every idle browser
+func (sm *ServeMux) adminDrainPool(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	stopped := chromePoolGet().DrainIdleInstances()
+
+	log := logger.Get()
+	log.Info().Ctx(r.Context()).Msg(fmt.Sprintf("drained %d idle browser(s) through admin api", stopped))
+	writeJsonResponse(w, http.StatusOK, AdminPoolResponse{Stopped: stopped})
+}
+
+// adminPrewarmPool handles POST /admin/pool/prewarm?count={n}&profile={profile}
+func (sm *ServeMux) adminPrewarmPool(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 1 {
+		writeErrorResponse(w, http.StatusUnprocessableEntity, ErrorCodeInvalidOptions, "req.query['count'] must be a number greater than 0")
+		return
+	}
+
+	options, err := config.NewCreateOptions(&config.ChromeConfigOptionsPayload{
+		Profile: r.URL.Query().Get("profile"),
+	})
+	if err != nil {
+		writeErrorResponse(w, http.StatusUnprocessableEntity, ErrorCodeInvalidOptions, "unable to create options for chrome startup")
+		return
+	}
+
+	started, err := chromePoolGet().PrewarmInstances(count, options)
+
+	log := logger.Get()
+	log.Info().Ctx(r.Context()).Msg(fmt.Sprintf("prewarmed %d of %d browser(s) through admin api", started, count))
+
+	if err != nil {
+		writeJsonResponse(w, http.StatusServiceUnavailable, AdminPoolResponse{Started: started, Error: err.Error()})
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, AdminPoolResponse{Started: started})
+}
+
+// adminListQueue handles GET /admin/queue
+func (sm *ServeMux) adminListQueue(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, AdminQueueResponse{
+		Sessions: proxyQueueGet().GetQueuedSessions(),
+	})
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeErrorResponse(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+	return false
+}
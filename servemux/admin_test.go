@@ -0,0 +1,168 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/test/mocks/chromemock"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const adminAccessToken = "admin-token"
+
+type AdminTestSuite struct {
+	suite.Suite
+	pool *chromepoolmock.MockChromePool
+	sm   *ServeMux
+}
+
+// run before each test
+func (suite *AdminTestSuite) SetupTest() {
+	config.Once = sync.Once{}
+	suite.T().Setenv(config.AdminApiEnabled, "true")
+	suite.T().Setenv(config.AdminAccessToken, adminAccessToken)
+
+	suite.pool = chromepoolmock.NewMock()
+	chromePoolGet = func() chromepool.IChromePool {
+		return suite.pool
+	}
+	suite.sm = NewServeMux(http.NewServeMux())
+}
+
+func (suite *AdminTestSuite) TearDownTest() {
+	chromePoolGet = chromepool.Get
+}
+
+func (suite *AdminTestSuite) request(method string, target string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if len(token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	suite.sm.ServeHTTP(w, r)
+	return w
+}
+
+func (suite *AdminTestSuite) TestRejectsMissingOrInvalidToken() {
+	w := suite.request(http.MethodGet, "/admin/browsers", "")
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	assert.Equal(suite.T(), ErrorCodeUnauthorized, decodeServeResponse(suite.T(), w).Error.Code)
+
+	w = suite.request(http.MethodGet, "/admin/browsers", "wrong")
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+
+	w = suite.request(http.MethodGet, "/admin/browsers?accessToken="+adminAccessToken, "")
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+
+func (suite *AdminTestSuite) TestListBrowsers() {
+	sessionId := uuid.New()
+	busy := chromemock.NewMock()
+	_ = busy.Start()
+	busy.SetBrowserID(uuid.New())
+	busy.SetPort(9222)
+	busy.SetSessionId(sessionId)
+	busy.SetOptions(config.ChromeConfigOptions{Profile: "custom"})
+
+	idle := chromemock.NewMock()
+	_ = idle.Start()
+	idle.SetBrowserID(uuid.New())
+	idle.SetIdleOrStop()
+
+	suite.pool.SetInstances([]chrome.IChrome{busy, idle})
+
+	w := suite.request(http.MethodGet, "/admin/browsers", adminAccessToken)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var res AdminBrowsersResponse
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	assert.Len(suite.T(), res.Browsers, 2)
+
+	assert.Equal(suite.T(), busy.BrowserID().String(), res.Browsers[0].BrowserId)
+	assert.Equal(suite.T(), 9222, res.Browsers[0].Port)
+	assert.Equal(suite.T(), "custom", res.Browsers[0].Profile)
+	assert.Equal(suite.T(), sessionId.String(), res.Browsers[0].SessionId)
+	assert.False(suite.T(), res.Browsers[0].IsIdle)
+	assert.GreaterOrEqual(suite.T(), res.Browsers[0].UptimeSecs, float64(0))
+
+	assert.Equal(suite.T(), idle.BrowserID().String(), res.Browsers[1].BrowserId)
+	assert.Empty(suite.T(), res.Browsers[1].SessionId)
+	assert.True(suite.T(), res.Browsers[1].IsIdle)
+}
+
+func (suite *AdminTestSuite) TestStopBrowser() {
+	browserID := uuid.New()
+	var stopped uuid.UUID
+	suite.pool.SetStopInstance(func(id uuid.UUID) error {
+		if id != browserID {
+			return errors.New("no browser with id")
+		}
+		stopped = id
+		return nil
+	})
+
+	w := suite.request(http.MethodDelete, "/admin/browsers/"+browserID.String(), adminAccessToken)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), browserID, stopped)
+
+	w = suite.request(http.MethodDelete, "/admin/browsers/"+uuid.New().String(), adminAccessToken)
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+
+	w = suite.request(http.MethodDelete, "/admin/browsers/not-a-uuid", adminAccessToken)
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+	assert.Equal(suite.T(), ErrorCodeNotFound, decodeServeResponse(suite.T(), w).Error.Code)
+
+	w = suite.request(http.MethodGet, "/admin/browsers/"+browserID.String(), adminAccessToken)
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, w.Code)
+}
+
+func (suite *AdminTestSuite) TestDrainPool() {
+	suite.pool.SetDrainIdleInstances(func() int {
+		return 3
+	})
+
+	w := suite.request(http.MethodPost, "/admin/pool/drain", adminAccessToken)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var res AdminPoolResponse
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(suite.T(), 3, res.Stopped)
+}
+
+func (suite *AdminTestSuite) TestPrewarmPool() {
+	var requested int
+	suite.pool.SetPrewarmInstances(func(count int, options config.ChromeConfigOptions) (int, error) {
+		requested = count
+		if count > 2 {
+			return 2, errors.New("MAX_BROWSER_INSTANCES have been created already")
+		}
+		return count, nil
+	})
+
+	w := suite.request(http.MethodPost, "/admin/pool/prewarm?count=2", adminAccessToken)
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), 2, requested)
+
+	w = suite.request(http.MethodPost, "/admin/pool/prewarm?count=5", adminAccessToken)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, w.Code)
+	var res AdminPoolResponse
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(suite.T(), 2, res.Started)
+	assert.NotEmpty(suite.T(), res.Error)
+
+	w = suite.request(http.MethodPost, "/admin/pool/prewarm?count=0", adminAccessToken)
+	assert.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestAdminSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
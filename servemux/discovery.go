@@ -0,0 +1,143 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/proxyqueue"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const connectPath = "/connect"
+
+// defaultProtocolVersion is reported by /json/version until a browser has started
+const defaultProtocolVersion = "1.3"
+
+// DiscoveryVersion is the body of /json/version, in the format of Chrome's debug port
+type DiscoveryVersion struct {
+	chrome.Version
+	WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
+}
+
+// DiscoveryTarget is a target of /json/list and /json/new, in the format of Chrome's debug port. Targets do not exist
+// until a client connects to their webSocketDebuggerUrl, every connection opens a new page in its own session
+type DiscoveryTarget struct {
+	Description          string `json:"description"`
+	Id                   string `json:"id"`
+	Title                string `json:"title"`
+	Type                 string `json:"type"`
+	Url                  string `json:"url"`
+	WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
+}
+
+// registerDiscoveryHandlers serves the HTTP endpoints of Chrome's debug port that clients use to find the websocket
+// to connect to, e.g. Playwright's connectOverCDP, Puppeteer's browserURL and chrome-remote-interface
+func (sm *ServeMux) registerDiscoveryHandlers() {
+	sm.mux.HandleFunc("/json/version", sm.accessTokenMiddleware(sm.discoveryVersion))
+	sm.mux.HandleFunc("/json/version/", sm.accessTokenMiddleware(sm.discoveryVersion))
+	sm.mux.HandleFunc("/json", sm.accessTokenMiddleware(sm.discoveryList))
+	sm.mux.HandleFunc("/json/list", sm.accessTokenMiddleware(sm.discoveryList))
+	sm.mux.HandleFunc("/json/list/", sm.accessTokenMiddleware(sm.discoveryList))
+	sm.mux.HandleFunc("/json/new", sm.accessTokenMiddleware(sm.discoveryNew))
+}
+
+// discoveryVersion handles GET /json/version. Its webSocketDebuggerUrl connects to the browser endpoint of a session
+func (sm *ServeMux) discoveryVersion(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, DiscoveryVersion{
+		Version:              browserVersion(),
+		WebSocketDebuggerUrl: connectUrl(r, r.URL.Query()),
+	})
+}
+
+// discoveryList handles GET /json/list with a single page target, so that clients picking the first page connect to
+// a page of their own session
+func (sm *ServeMux) discoveryList(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, []DiscoveryTarget{newDiscoveryTarget(r, r.URL.Query(), "")})
+}
+
+// discoveryNew handles PUT /json/new?{url}. GET is accepted as well, like Chrome did before version 111
+func (sm *ServeMux) discoveryNew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodGet {
+		w.Header().Set("Allow", fmt.Sprintf("%s, %s", http.MethodPut, http.MethodGet))
+		writeErrorResponse(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+	query, pageUrl := newPageQuery(r)
+	writeJsonResponse(w, http.StatusOK, newDiscoveryTarget(r, query, pageUrl))
+}
+
+// newPageQuery returns the connect params and page url of /json/new. Chrome takes the whole query as the url, e.g.
+// /json/new?https://example.com, which is supported as long as the url param is not set
+func newPageQuery(r *http.Request) (url.Values, string) {
+	query := r.URL.Query()
+	if pageUrl := query.Get(proxyqueue.PageUrlParam); len(pageUrl) > 0 {
+		return query, pageUrl
+	}
+	if pageUrl, err := url.QueryUnescape(r.URL.RawQuery); err == nil && strings.Contains(pageUrl, "://") {
+		return url.Values{}, pageUrl
+	}
+	return query, ""
+}
+
+func newDiscoveryTarget(r *http.Request, query url.Values, pageUrl string) DiscoveryTarget {
+	if len(pageUrl) == 0 {
+		pageUrl = "about:blank"
+	}
+
+	pageQuery := url.Values{}
+	for param, values := range query {
+		pageQuery[param] = values
+	}
+	pageQuery.Set(proxyqueue.TargetParam, string(proxyqueue.PageTarget))
+	pageQuery.Set(proxyqueue.PageUrlParam, pageUrl)
+
+	return DiscoveryTarget{
+		// targets only get an id from Chrome once they are opened, this one only identifies the response
+		Id:                   strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")),
+		Title:                pageUrl,
+		Type:                 string(proxyqueue.PageTarget),
+		Url:                  pageUrl,
+		WebSocketDebuggerUrl: connectUrl(r, pageQuery),
+	}
+}
+
+// browserVersion returns the version of the first browser in the pool that has started
+func browserVersion() chrome.Version {
+	for _, crm := range chromePoolGet().GetInstances() {
+		if v := crm.Version(); len(v.Browser) > 0 {
+			return v
+		}
+	}
+	return chrome.Version{
+		Browser:         "Chrome",
+		ProtocolVersion: defaultProtocolVersion,
+	}
+}
+
+// connectUrl returns the websocket url of the connect endpoint on the host r was sent to, with query as its params.
+// X-Forwarded-Proto and X-Forwarded-Host are honored so that the url works behind a load balancer
+func connectUrl(r *http.Request, query url.Values) string {
+	scheme := "ws"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "wss"
+	}
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); len(forwardedHost) > 0 {
+		host = forwardedHost
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     host,
+		Path:     connectPath,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,143 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/test/mocks/chromemock"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+type DiscoveryTestSuite struct {
+	suite.Suite
+	pool *chromepoolmock.MockChromePool
+	sm   *ServeMux
+}
+
+// run before each test
+func (suite *DiscoveryTestSuite) SetupTest() {
+	config.Once = sync.Once{}
+	suite.pool = chromepoolmock.NewMock()
+	chromePoolGet = func() chromepool.IChromePool {
+		return suite.pool
+	}
+	suite.sm = NewServeMux(http.NewServeMux())
+}
+
+func (suite *DiscoveryTestSuite) TearDownTest() {
+	chromePoolGet = chromepool.Get
+}
+
+func (suite *DiscoveryTestSuite) serve(method string, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, nil)
+	r.Host = "proxy.example.com"
+	suite.sm.ServeHTTP(w, r)
+	return w
+}
+
+func (suite *DiscoveryTestSuite) TestVersionPointsAtConnect() {
+	crm := chromemock.NewMock()
+	crm.SetVersion(chrome.Version{Browser: "HeadlessChrome/120.0.6099.109", ProtocolVersion: "1.3"})
+	suite.pool.SetInstances([]chrome.IChrome{chromemock.NewMock(), crm})
+
+	for _, path := range []string{"/json/version", "/json/version/"} {
+		w := suite.serve(http.MethodGet, path+"?profile=checkout&priority=3")
+		assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+		var res map[string]string
+		assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(suite.T(), "HeadlessChrome/120.0.6099.109", res["Browser"])
+		assert.Equal(suite.T(), "1.3", res["Protocol-Version"])
+		assert.Equal(suite.T(), "ws://proxy.example.com/connect?priority=3&profile=checkout", res["webSocketDebuggerUrl"])
+	}
+}
+
+func (suite *DiscoveryTestSuite) TestVersionWithoutBrowsers() {
+	w := suite.serve(http.MethodGet, "/json/version")
+	var res DiscoveryVersion
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(suite.T(), defaultProtocolVersion, res.ProtocolVersion)
+	assert.Equal(suite.T(), "ws://proxy.example.com/connect", res.WebSocketDebuggerUrl)
+}
+
+func (suite *DiscoveryTestSuite) TestForwardedProtoUsesWss() {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/json/version", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "public.example.com")
+	suite.sm.ServeHTTP(w, r)
+
+	var res DiscoveryVersion
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(suite.T(), "wss://public.example.com/connect", res.WebSocketDebuggerUrl)
+}
+
+func (suite *DiscoveryTestSuite) TestListHasOnePageTarget() {
+	for _, path := range []string{"/json", "/json/list"} {
+		w := suite.serve(http.MethodGet, path+"?profile=checkout")
+		assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+		var res []DiscoveryTarget
+		assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+		assert.Len(suite.T(), res, 1)
+		assert.Equal(suite.T(), "page", res[0].Type)
+		assert.NotEmpty(suite.T(), res[0].Id)
+		assert.Equal(suite.T(), "ws://proxy.example.com/connect?profile=checkout&target=page&url=about%3Ablank", res[0].WebSocketDebuggerUrl)
+	}
+}
+
+func (suite *DiscoveryTestSuite) TestNewPageTarget() {
+	decode := func(w *httptest.ResponseRecorder) url.Values {
+		assert.Equal(suite.T(), http.StatusOK, w.Code)
+		var res DiscoveryTarget
+		assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+		u, err := url.Parse(res.WebSocketDebuggerUrl)
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), connectPath, u.Path)
+		assert.Equal(suite.T(), res.Url, u.Query().Get("url"))
+		return u.Query()
+	}
+
+	// chrome takes the whole query as the url
+	query := decode(suite.serve(http.MethodPut, "/json/new?https://example.com/a?b=c"))
+	assert.Equal(suite.T(), "https://example.com/a?b=c", query.Get("url"))
+	assert.Equal(suite.T(), "page", query.Get("target"))
+
+	query = decode(suite.serve(http.MethodGet, "/json/new?profile=checkout&url=https%3A%2F%2Fexample.com"))
+	assert.Equal(suite.T(), "https://example.com", query.Get("url"))
+	assert.Equal(suite.T(), "checkout", query.Get("profile"))
+
+	query = decode(suite.serve(http.MethodPut, "/json/new"))
+	assert.Equal(suite.T(), "about:blank", query.Get("url"))
+
+	w := suite.serve(http.MethodDelete, "/json/new")
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, w.Code)
+}
+
+func (suite *DiscoveryTestSuite) TestDiscoveryRequiresAccessToken() {
+	suite.T().Setenv(config.ServerAccessTokenValidationEnabled, "true")
+	suite.T().Setenv(config.ServerAccessToken, "server-token")
+	config.Once = sync.Once{}
+
+	w := suite.serve(http.MethodGet, "/json/version")
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+
+	w = suite.serve(http.MethodGet, "/json/version?accessToken=server-token")
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	var res DiscoveryVersion
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(suite.T(), "ws://proxy.example.com/connect?accessToken=server-token", res.WebSocketDebuggerUrl)
+}
+
+func TestDiscoverySuite(t *testing.T) {
+	suite.Run(t, new(DiscoveryTestSuite))
+}
@@ -0,0 +1,127 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	HealthStatusOk       = "ok"
+	HealthStatusNotReady = "not ready"
+)
+
+var startedAt = time.Now()
+
+type LivenessResponse struct {
+	Status     string  `json:"status"`
+	UptimeSecs float64 `json:"uptimeSecs"`
+}
+
+type ReadinessPool struct {
+	Instances                 int  `json:"instances"`
+	BusyInstances             int  `json:"busyInstances"`
+	MaxInstances              int  `json:"maxInstances"`
+	IsAtCapacity              bool `json:"isAtCapacity"`
+	ConsecutiveLaunchFailures int  `json:"consecutiveLaunchFailures"`
+}
+
+type ReadinessQueue struct {
+	Length         int `json:"length"`
+	ActiveSessions int `json:"activeSessions"`
+}
+
+type ReadinessResponse struct {
+	Status   string         `json:"status"`
+	Reasons  []string       `json:"reasons,omitempty"`
+	Draining bool           `json:"draining"`
+	Pool     ReadinessPool  `json:"pool"`
+	Queue    ReadinessQueue `json:"queue"`
+}
+
+func (sm *ServeMux) registerHealthHandlers() {
+	sm.mux.HandleFunc("/livez", sm.livez)
+	sm.mux.HandleFunc("/readyz", sm.readyz)
+}
+
+// livez reports whether the process is serving requests. It does not depend on chrome or the queue, so that a
+// replica is not restarted because of load
+func (sm *ServeMux) livez(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, LivenessResponse{
+		Status:     HealthStatusOk,
+		UptimeSecs: time.Since(startedAt).Seconds(),
+	})
+}
+
+// readyz reports whether the replica should be sent new sessions. It is not ready while draining, while more than
+// READINESS_MAX_QUEUE_LENGTH sessions are queued, or after READINESS_MAX_LAUNCH_FAILURES browsers failed to start
+func (sm *ServeMux) readyz(w http.ResponseWriter, r *http.Request) {
+	res := sm.getReadiness()
+	status := http.StatusOK
+	if res.Status != HealthStatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealthResponse(w, status, res)
+}
+
+func (sm *ServeMux) getReadiness() ReadinessResponse {
+	conf := config.Get()
+	serverConf := conf.GetServerConfig()
+	cp := chromePoolGet()
+	pq := proxyQueueGet()
+
+	instances := cp.GetInstances()
+	busy := 0
+	for _, c := range instances {
+		if !c.IsIdle() {
+			busy++
+		}
+	}
+
+	res := ReadinessResponse{
+		Status:   HealthStatusOk,
+		Draining: pq.IsDraining(),
+		Pool: ReadinessPool{
+			Instances:                 len(instances),
+			BusyInstances:             busy,
+			MaxInstances:              conf.GetChromePoolConfig().MaxBrowserInstances,
+			IsAtCapacity:              cp.IsPoolAtCapacity(),
+			ConsecutiveLaunchFailures: cp.GetConsecutiveLaunchFailures(),
+		},
+		Queue: ReadinessQueue{
+			Length:         pq.QueueLength(),
+			ActiveSessions: pq.ActiveSessionCount(),
+		},
+	}
+
+	if res.Draining {
+		res.Reasons = append(res.Reasons, "server is draining")
+	}
+	if serverConf.ReadinessMaxQueueLength > 0 && res.Queue.Length > serverConf.ReadinessMaxQueueLength {
+		res.Reasons = append(res.Reasons, fmt.Sprintf(
+			"%d queued sessions exceed %s of %d",
+			res.Queue.Length,
+			config.ReadinessMaxQueueLength,
+			serverConf.ReadinessMaxQueueLength,
+		))
+	}
+	if serverConf.ReadinessMaxLaunchFailures > 0 && res.Pool.ConsecutiveLaunchFailures >= serverConf.ReadinessMaxLaunchFailures {
+		res.Reasons = append(res.Reasons, fmt.Sprintf(
+			"the last %d browser launches failed",
+			res.Pool.ConsecutiveLaunchFailures,
+		))
+	}
+	if len(res.Reasons) > 0 {
+		res.Status = HealthStatusNotReady
+	}
+	return res
+}
+
+func writeHealthResponse(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,126 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/proxyqueue"
+	"chromium-websocket-proxy/test/mocks/chromemock"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type HealthTestSuite struct {
+	suite.Suite
+	pool *chromepoolmock.MockChromePool
+	pq   *proxyqueue.ProxyQueue
+}
+
+// run before each test
+func (suite *HealthTestSuite) SetupTest() {
+	config.Once = sync.Once{}
+
+	suite.pool = chromepoolmock.NewMock()
+	chromePoolGet = func() chromepool.IChromePool {
+		return suite.pool
+	}
+	suite.pq = &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return suite.pq
+	}
+}
+
+func (suite *HealthTestSuite) TearDownTest() {
+	chromePoolGet = chromepool.Get
+	proxyQueueGet = proxyqueue.Get
+}
+
+func (suite *HealthTestSuite) readyz() (int, ReadinessResponse) {
+	w := httptest.NewRecorder()
+	NewServeMux(http.NewServeMux()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var res ReadinessResponse
+	assert.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&res))
+	return w.Code, res
+}
+
+func (suite *HealthTestSuite) TestLivez() {
+	w := httptest.NewRecorder()
+	NewServeMux(http.NewServeMux()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), HealthStatusOk)
+}
+
+func (suite *HealthTestSuite) TestReadyzDescribesPoolAndQueue() {
+	busy := chromemock.NewMock()
+	idle := chromemock.NewMock()
+	idle.SetIdleOrStop()
+	suite.pool.SetInstances([]chrome.IChrome{busy, idle})
+	suite.pool.SetIsPoolAtCapacity(true)
+
+	code, res := suite.readyz()
+	assert.Equal(suite.T(), http.StatusOK, code)
+	assert.Equal(suite.T(), HealthStatusOk, res.Status)
+	assert.Empty(suite.T(), res.Reasons)
+	assert.Equal(suite.T(), ReadinessPool{
+		Instances:     2,
+		BusyInstances: 1,
+		MaxInstances:  config.Get().GetChromePoolConfig().MaxBrowserInstances,
+		IsAtCapacity:  true,
+	}, res.Pool)
+}
+
+func (suite *HealthTestSuite) TestReadyzFailsAfterConsecutiveLaunchFailures() {
+	suite.T().Setenv(config.ReadinessMaxLaunchFailures, strconv.Itoa(2))
+
+	suite.pool.SetConsecutiveLaunchFailures(1)
+	code, _ := suite.readyz()
+	assert.Equal(suite.T(), http.StatusOK, code)
+
+	suite.pool.SetConsecutiveLaunchFailures(2)
+	config.Once = sync.Once{}
+	code, res := suite.readyz()
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, code)
+	assert.Equal(suite.T(), HealthStatusNotReady, res.Status)
+	assert.Len(suite.T(), res.Reasons, 1)
+}
+
+func (suite *HealthTestSuite) TestReadyzFailsWhenQueueExceedsThreshold() {
+	suite.T().Setenv(config.ReadinessMaxQueueLength, strconv.Itoa(1))
+	_ = metrics.Init()
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/connect", nil)
+		r = r.WithContext(context.WithValue(r.Context(), logger.SessionIdTrackingKey, uuid.New()))
+		eld, err := proxyqueue.NewElementData(httptest.NewRecorder(), r)
+		assert.Nil(suite.T(), err)
+		assert.Nil(suite.T(), suite.pq.AddToList(eld))
+	}
+
+	code, res := suite.readyz()
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, code)
+	assert.Equal(suite.T(), 2, res.Queue.Length)
+}
+
+func (suite *HealthTestSuite) TestReadyzFailsWhenDraining() {
+	suite.pq.StartDraining()
+
+	code, res := suite.readyz()
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, code)
+	assert.True(suite.T(), res.Draining)
+}
+
+func TestHealthSuite(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
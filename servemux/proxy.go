@@ -1,41 +1,193 @@
 package servemux
 
 import (
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
 	"chromium-websocket-proxy/logger"
 	"chromium-websocket-proxy/proxyqueue"
-	"encoding/json"
+	"chromium-websocket-proxy/tenant"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/chromedp/cdproto/target"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (sm *ServeMux) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	log := logger.Get()
 	log.Info().Ctx(r.Context()).Msg("queuing new chrome proxy session")
 
-	pq := proxyqueue.Get()
+	pq := proxyQueueGet()
 
 	eld, err := proxyqueue.NewElementData(w, r)
 	if err != nil {
-		data := ServeResponse{
-			id: -1,
-			error: ServeResponseError{
-				message: err.Error(),
-				code:    -1,
-			},
+		writeErrorResponse(w, http.StatusUnprocessableEntity, ErrorCodeInvalidOptions, err.Error())
+		return
+	}
+
+	// the profile of a resumed session was checked when it was queued
+	if token := r.URL.Query().Get(proxyqueue.ResumeParam); len(token) > 0 {
+		sm.resumeHandler(w, pq, eld, token)
+		return
+	}
+
+	if !eld.Tenant.IsProfileAllowed(eld.ChromeOptions.Profile) {
+		writeErrorResponse(w, http.StatusForbidden, ErrorCodeForbidden, fmt.Sprintf("tenant %s is not allowed to use profile '%s'", eld.Tenant.Name, eld.ChromeOptions.Profile))
+		return
+	}
+
+	if !eld.Claims.IsProfileAllowed(eld.ChromeOptions.Profile) {
+		writeErrorResponse(w, http.StatusForbidden, ErrorCodeForbidden, fmt.Sprintf("access token is not allowed to use profile '%s'", eld.ChromeOptions.Profile))
+		return
+	}
+
+	if err = pq.AddToList(eld); errors.Is(err, tenant.ErrQueueQuota) {
+		sm.shedResponse(w, pq, http.StatusTooManyRequests, ErrorCodeQueueFull, err.Error())
+		return
+	} else if errors.Is(err, proxyqueue.ErrServerDraining) {
+		sm.shedResponse(w, pq, http.StatusServiceUnavailable, ErrorCodeServerDraining, err.Error())
+		return
+	} else if err != nil {
+		sm.shedResponse(w, pq, http.StatusServiceUnavailable, ErrorCodeQueueFull, err.Error())
+		return
+	}
+
+	// a nil channel never receives, so sessions wait indefinitely if MAX_QUEUE_WAIT_IN_SECS is not set
+	var queueWaitTimeout <-chan time.Time
+	if maxQueueWait := config.Get().GetProxyQueueConfig().MaxQueueWaitInSecs; maxQueueWait > 0 {
+		timer := time.NewTimer(maxQueueWait)
+		defer timer.Stop()
+		queueWaitTimeout = timer.C
+	}
+
+	for {
+		select {
+		case status := <-eld.C:
+			if status == proxyqueue.Rejected {
+				sm.shedResponse(w, pq, http.StatusServiceUnavailable, ErrorCodeServerDraining, proxyqueue.ErrServerDraining.Error())
+				return
+			}
+			if status == proxyqueue.QueueWaitExceeded {
+				sm.shedResponse(w, pq, http.StatusServiceUnavailable, ErrorCodeTimeout, fmt.Sprintf("session was queued longer than %s", config.MaxQueueWaitInSecs))
+				return
+			}
+			log.Info().Ctx(r.Context()).Msg(fmt.Sprintf("proxy finished with status: %s", status))
+			// once the websocket is accepted the connection is hijacked and errors are reported with close frames
+			if !eld.Accepted() && status != proxyqueue.Succeeded {
+				proxyFailedResponse(w, status)
+			}
+			return
+		case <-queueWaitTimeout:
+			if pq.Shed(eld, proxyqueue.QueueWaitTimeout) {
+				sm.shedResponse(w, pq, http.StatusServiceUnavailable, ErrorCodeTimeout, fmt.Sprintf("session was queued longer than %s", config.MaxQueueWaitInSecs))
+				return
+			}
+			// the session is being proxied. The queue sheds it if it has to be requeued
+			queueWaitTimeout = nil
+		case <-eld.R.Context().Done():
+			pq.RemoveFromList(eld)
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		_ = json.NewEncoder(w).Encode(data)
+	}
+}
+
+// resumeHandler reattaches the client to the parked session it was given the resume token of. Resumed sessions skip
+// the queue, they still have their browser
+func (sm *ServeMux) resumeHandler(w http.ResponseWriter, pq *proxyqueue.ProxyQueue, eld *proxyqueue.ElementData, token string) {
+	log := logger.Get()
+
+	if err := pq.Resume(token, eld); err != nil {
+		writeErrorResponse(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	// the parked session always answers, even if the client has gone again
+	status := <-eld.C
+	log.Info().Ctx(eld.R.Context()).Msg(fmt.Sprintf("resumed proxy finished with status: %s", status))
+	if !eld.Accepted() && status != proxyqueue.Succeeded {
+		proxyFailedResponse(w, status)
+	}
+}
+
+const pageProxyPath = "/devtools/page/"
+
+// pageProxyHandler connects to a page target of a running session, e.g. /devtools/page/{targetId}?sessionId={id}.
+// The session id is sent to clients in the X-Session-Id header of the upgrade response
+func (sm *ServeMux) pageProxyHandler(w http.ResponseWriter, r *http.Request) {
+	targetID := strings.TrimPrefix(r.URL.Path, pageProxyPath)
+	if len(targetID) == 0 || strings.Contains(targetID, "/") {
+		writeErrorResponse(w, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("%s requires a target id", pageProxyPath))
+		return
+	}
+
+	sessionId, err := proxyqueue.GetSessionId(r)
+	if err != nil {
+		writeErrorResponse(w, http.StatusUnprocessableEntity, ErrorCodeInvalidOptions, err.Error())
+		return
+	}
+
+	// log the page connection with the session it belongs to
+	r = r.WithContext(context.WithValue(r.Context(), logger.SessionIdTrackingKey, sessionId))
+
+	err = proxyQueueGet().ProxyPage(w, r, sessionId, target.ID(targetID))
+	if errors.Is(err, proxyqueue.ErrSessionNotFound) {
+		writeErrorResponse(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+	} else if errors.Is(err, proxyqueue.ErrTargetNotOwned) {
+		writeErrorResponse(w, http.StatusForbidden, ErrorCodeForbidden, err.Error())
+	} else if err != nil {
+		writeErrorResponse(w, http.StatusBadGateway, ErrorCodeNoBrowser, err.Error())
+	}
+}
+
+const stickySessionsPath = "/sessions/"
+
+// StickySessionResponse is returned once the browser pinned to a sticky session key has been released
+type StickySessionResponse struct {
+	Session  string `json:"session"`
+	Released bool   `json:"released"`
+}
+
+// releaseStickySession handles DELETE /sessions/{key}, stopping the browser the tenant pinned to the sticky session
+// key along with any session using it
+func (sm *ServeMux) releaseStickySession(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) {
 		return
 	}
-	el := pq.AddToList(eld)
 
-	select {
-	case status := <-eld.C:
-		log.Info().Ctx(r.Context()).Msg(fmt.Sprintf("proxy finished with status: %s", status))
+	key := strings.TrimPrefix(r.URL.Path, stickySessionsPath)
+	pin := proxyqueue.StickySessionPin(r, key)
+	if err := chromePoolGet().ReleasePin(pin); err != nil {
+		writeErrorResponse(w, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	log := logger.Get()
+	log.Info().Ctx(r.Context()).Str("session", key).Msg("released pinned browser of sticky session")
+	writeJsonResponse(w, http.StatusOK, StickySessionResponse{Session: key, Released: true})
+}
+
+// shedResponse rejects a session that could not be queued with status and a Retry-After header
+func (sm *ServeMux) shedResponse(w http.ResponseWriter, pq *proxyqueue.ProxyQueue, status int, code ErrorCode, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(pq.RetryAfterSecs()))
+	writeErrorResponse(w, status, code, message)
+}
+
+// proxyFailedResponse reports a session that failed before its websocket was accepted
+func proxyFailedResponse(w http.ResponseWriter, status proxyqueue.ProxyResult) {
+	if status == proxyqueue.SessionTimedOut {
+		writeErrorResponse(w, http.StatusGatewayTimeout, ErrorCodeTimeout, "session timed out before it was proxied")
 		return
-	case <-eld.R.Context().Done():
-		pq.RemoveFromList(el)
+	}
+	if status == proxyqueue.PinLimitReached {
+		writeErrorResponse(w, http.StatusTooManyRequests, ErrorCodeNoBrowser, chromepool.ErrPinLimitReached.Error())
+		return
+	}
+	if status == proxyqueue.PinProfileMismatch {
+		writeErrorResponse(w, http.StatusUnprocessableEntity, ErrorCodeInvalidOptions, chromepool.ErrPinProfileMismatch.Error())
 		return
 	}
+	writeErrorResponse(w, http.StatusBadGateway, ErrorCodeNoBrowser, fmt.Sprintf("unable to connect to a browser: %s", status))
 }
@@ -0,0 +1,321 @@
+package servemux
+
+import (
+	"chromium-websocket-proxy/chromepool"
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/proxyqueue"
+	"chromium-websocket-proxy/tenant"
+	"chromium-websocket-proxy/test/mocks/chromepoolmock"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func decodeServeResponse(t *testing.T, w *httptest.ResponseRecorder) ServeResponse {
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var res ServeResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, errorResponseId, res.Id)
+	assert.NotEmpty(t, res.Error.Message)
+	return res
+}
+
+func TestProxyHandlerShedsSessionsQueuedTooLong(t *testing.T) {
+	config.Once = sync.Once{}
+	t.Setenv(config.MaxQueueWaitInSecs, "1")
+	_ = metrics.Init()
+
+	// the queue never proxies sessions without its tickers
+	pq := &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return pq
+	}
+	defer func() {
+		proxyQueueGet = proxyqueue.Get
+	}()
+
+	sm := NewServeMux(http.NewServeMux())
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connect", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, retryAfter, 1)
+	assert.Equal(t, ErrorCodeTimeout, decodeServeResponse(t, w).Error.Code)
+}
+
+func TestDrainingFailsHealthCheckAndRejectsSessions(t *testing.T) {
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+
+	pq := &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return pq
+	}
+	defer func() {
+		proxyQueueGet = proxyqueue.Get
+	}()
+
+	sm := NewServeMux(http.NewServeMux())
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	pq.StartDraining()
+
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connect", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, ErrorCodeServerDraining, decodeServeResponse(t, w).Error.Code)
+}
+
+func TestTenantTokensAreAcceptedAndLimited(t *testing.T) {
+	config.Once = sync.Once{}
+	t.Setenv(config.ServerAccessTokenValidationEnabled, "true")
+	t.Setenv(config.ServerAccessToken, "server-token")
+	_ = metrics.Init()
+
+	reg, err := tenant.NewRegistry([]*tenant.Tenant{
+		{Name: "acme", Tokens: []string{"acme-token"}, AllowedProfiles: []string{"checkout"}, MaxQueuedSessions: 1},
+	})
+	assert.Nil(t, err)
+	tenantRegistryGet = func() *tenant.Registry {
+		return reg
+	}
+	pq := &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return pq
+	}
+	defer func() {
+		tenantRegistryGet = tenant.Get
+		proxyQueueGet = proxyqueue.Get
+	}()
+
+	sm := NewServeMux(http.NewServeMux())
+
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connect?accessToken=unknown", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorCodeUnauthorized, decodeServeResponse(t, w).Error.Code)
+
+	// the token is accepted from the bearer header, but the tenant may not use the default profile
+	r := httptest.NewRequest(http.MethodGet, "/connect", nil)
+	r.Header.Set("Authorization", "Bearer acme-token")
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorCodeForbidden, decodeServeResponse(t, w).Error.Code)
+
+	// fill the tenant's queue quota
+	acme, _ := reg.Lookup("acme-token")
+	assert.True(t, acme.Queue())
+
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connect?accessToken=acme-token&profile=checkout", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, ErrorCodeQueueFull, decodeServeResponse(t, w).Error.Code)
+
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connect?accessToken=acme-token&profile=checkout&priority=high", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrorCodeInvalidOptions, decodeServeResponse(t, w).Error.Code)
+}
+
+func TestServeResponseEncodesErrorCodes(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeErrorResponse(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "denied")
+	assert.JSONEq(t, `{"id":-1,"error":{"code":"unauthorized","message":"denied"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	proxyFailedResponse(w, proxyqueue.ConnectionError)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, ErrorCodeNoBrowser, decodeServeResponse(t, w).Error.Code)
+
+	w = httptest.NewRecorder()
+	proxyFailedResponse(w, proxyqueue.SessionTimedOut)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, ErrorCodeTimeout, decodeServeResponse(t, w).Error.Code)
+}
+
+func TestJwtAccessTokensAreValidatedAndRestricted(t *testing.T) {
+	config.Once = sync.Once{}
+	t.Setenv(config.ServerAccessTokenValidationEnabled, "true")
+	t.Setenv(config.JwtHs256Secret, "secret")
+	_ = metrics.Init()
+
+	validator := jwtauth.NewValidator([]byte("secret"), nil, "", "")
+	jwtValidatorGet = func() *jwtauth.Validator {
+		return validator
+	}
+	pq := &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return pq
+	}
+	defer func() {
+		jwtValidatorGet = jwtauth.Get
+		proxyQueueGet = proxyqueue.Get
+	}()
+
+	sign := func(secret string, expiresIn time.Duration) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwtauth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn))},
+			Profiles:         []string{"checkout"},
+		}).SignedString([]byte(secret))
+		assert.Nil(t, err)
+		return token
+	}
+
+	sm := NewServeMux(http.NewServeMux())
+
+	for _, token := range []string{sign("other", time.Minute), sign("secret", -time.Minute)} {
+		r := httptest.NewRequest(http.MethodGet, "/connect", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, ErrorCodeUnauthorized, decodeServeResponse(t, w).Error.Code)
+	}
+
+	// a valid token may not use profiles outside of its profiles claim
+	r := httptest.NewRequest(http.MethodGet, "/connect?profile=other", nil)
+	r.Header.Set("Authorization", "Bearer "+sign("secret", time.Minute))
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestPageProxyHandlerRejectsUnknownSessions(t *testing.T) {
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+
+	pq := &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return pq
+	}
+	defer func() {
+		proxyQueueGet = proxyqueue.Get
+	}()
+
+	sm := NewServeMux(http.NewServeMux())
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := serve("/devtools/page/")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrorCodeNotFound, decodeServeResponse(t, w).Error.Code)
+
+	w = serve("/devtools/page/ABC")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrorCodeInvalidOptions, decodeServeResponse(t, w).Error.Code)
+
+	w = serve("/devtools/page/ABC?sessionId=" + uuid.NewString())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrorCodeNotFound, decodeServeResponse(t, w).Error.Code)
+}
+
+func TestProxyHandlerRejectsUnknownResumeTokens(t *testing.T) {
+	config.Once = sync.Once{}
+	_ = metrics.Init()
+
+	pq := &proxyqueue.ProxyQueue{}
+	proxyQueueGet = func() *proxyqueue.ProxyQueue {
+		return pq
+	}
+	defer func() {
+		proxyQueueGet = proxyqueue.Get
+	}()
+
+	sm := NewServeMux(http.NewServeMux())
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connect?resume=unknown", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrorCodeNotFound, decodeServeResponse(t, w).Error.Code)
+	assert.Equal(t, 0, pq.QueueLength(), "resumed sessions are not queued")
+}
+
+func TestReleaseStickySessionOfTenant(t *testing.T) {
+	config.Once = sync.Once{}
+	t.Setenv(config.ServerAccessTokenValidationEnabled, "true")
+	t.Setenv(config.ServerAccessToken, "server-token")
+	_ = metrics.Init()
+
+	reg, err := tenant.NewRegistry([]*tenant.Tenant{
+		{Name: "acme", Tokens: []string{"acme-token"}},
+	})
+	assert.Nil(t, err)
+	tenantRegistryGet = func() *tenant.Registry {
+		return reg
+	}
+	pool := chromepoolmock.NewMock()
+	var released []chromepool.Pin
+	pool.SetReleasePin(func(pin chromepool.Pin) error {
+		if pin.Key == "unknown" {
+			return chromepool.ErrPinNotFound
+		}
+		released = append(released, pin)
+		return nil
+	})
+	chromePoolGet = func() chromepool.IChromePool {
+		return pool
+	}
+	defer func() {
+		tenantRegistryGet = tenant.Get
+		chromePoolGet = chromepool.Get
+	}()
+
+	sm := NewServeMux(http.NewServeMux())
+	serveWithToken := func(method string, target string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		sm.ServeHTTP(w, r)
+		return w
+	}
+	serve := func(method string, target string) *httptest.ResponseRecorder {
+		return serveWithToken(method, target, "acme-token")
+	}
+
+	w := serve(http.MethodGet, "/sessions/login")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, ErrorCodeMethodNotAllowed, decodeServeResponse(t, w).Error.Code)
+
+	w = serve(http.MethodDelete, "/sessions/unknown")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrorCodeNotFound, decodeServeResponse(t, w).Error.Code)
+
+	w = serve(http.MethodDelete, "/sessions/login")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res StickySessionResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, StickySessionResponse{Session: "login", Released: true}, res)
+
+	// tenants only release their own pins
+	assert.Equal(t, []chromepool.Pin{{Tenant: "acme", Key: "login"}}, released)
+
+	// callers without a tenant release the pins of their own token
+	w = serveWithToken(http.MethodDelete, "/sessions/login", "server-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, released, 2)
+	assert.NotEqual(t, "acme", released[1].Tenant)
+	assert.NotEmpty(t, released[1].Tenant)
+}
@@ -1,8 +1,13 @@
 package servemux
 
 import (
+	"chromium-websocket-proxy/chromepool"
 	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/jwtauth"
 	"chromium-websocket-proxy/logger"
+	"chromium-websocket-proxy/metrics"
+	"chromium-websocket-proxy/proxyqueue"
+	"chromium-websocket-proxy/tenant"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,18 +22,43 @@ type IHttpMux interface {
 	Handle(pattern string, handler http.Handler)
 }
 
+var chromePoolGet = chromepool.Get
+var proxyQueueGet = proxyqueue.Get
+var tenantRegistryGet = tenant.Get
+var jwtValidatorGet = jwtauth.Get
+
 type ServeRequest = func(http.ResponseWriter, *http.Request)
 
+// ServeResponse is the JSON body of every error response. It has the shape of a CDP error message so that clients
+// expecting a websocket can surface it
 type ServeResponse struct {
-	id    int
-	error ServeResponseError
+	Id    int                `json:"id"`
+	Error ServeResponseError `json:"error"`
 }
 
 type ServeResponseError struct {
-	code    int
-	message string
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
 }
 
+// ErrorCode is a stable identifier clients can branch on. Messages are meant for humans and may change
+type ErrorCode string
+
+const (
+	ErrorCodeUnauthorized     ErrorCode = "unauthorized"       // the access token is missing or invalid
+	ErrorCodeForbidden        ErrorCode = "forbidden"          // the access token is not allowed to use the profile or page target
+	ErrorCodeInvalidOptions   ErrorCode = "invalid_options"    // the request params are invalid
+	ErrorCodeNotFound         ErrorCode = "not_found"          // the browser, session or sticky session does not exist
+	ErrorCodeMethodNotAllowed ErrorCode = "method_not_allowed" // the endpoint does not support the request method
+	ErrorCodeQueueFull        ErrorCode = "queue_full"         // the queue or the tenant's queue quota is full, retry after Retry-After
+	ErrorCodeServerDraining   ErrorCode = "server_draining"    // the server is shutting down, retry on another replica
+	ErrorCodeNoBrowser        ErrorCode = "no_browser"         // no browser could be provided for the session
+	ErrorCodeTimeout          ErrorCode = "timeout"            // the session timed out before it was proxied
+)
+
+// errorResponseId is the id of every ServeResponse. No CDP message has a negative id
+const errorResponseId = -1
+
 type ServeMux struct {
 	mux IHttpMux
 }
@@ -40,36 +70,81 @@ func NewServeMux(
 		mux: mux,
 	}
 	sm.mux.HandleFunc("/healthcheck", sm.healthCheck)
-	sm.mux.HandleFunc("/connect", sm.accessTokenMiddleware(sm.proxyHandler))
+	sm.registerHealthHandlers()
+	sm.mux.HandleFunc(connectPath, sm.accessTokenMiddleware(sm.proxyHandler))
+	sm.registerDiscoveryHandlers()
+	sm.mux.HandleFunc(pageProxyPath, sm.accessTokenMiddleware(sm.pageProxyHandler))
+	sm.mux.HandleFunc(stickySessionsPath, sm.accessTokenMiddleware(sm.releaseStickySession))
+	if config.Get().GetMetricsConfig().PrometheusEnabled {
+		sm.mux.HandleFunc("/metrics", sm.prometheusMetrics)
+	}
+	if config.Get().GetServerConfig().AdminApiEnabled {
+		sm.registerAdminHandlers()
+	}
 	return sm
 }
 
+// accessTokenMiddleware accepts the access token in the Authorization bearer header or the accessToken query param.
+// Tenant tokens attach the tenant to the request context and are always accepted. If JWT_HS256_SECRET or JWT_JWKS_FILE
+// is set, tokens formatted as JWTs must be valid and attach their claims to the request context
 func (sm *ServeMux) accessTokenMiddleware(f ServeRequest) ServeRequest {
 	return func(w http.ResponseWriter, r *http.Request) {
+		accessToken := tenant.GetRequestToken(r)
+
+		if t, exists := tenantRegistryGet().Lookup(accessToken); exists {
+			f(w, r.WithContext(tenant.WithTenant(r.Context(), t)))
+			return
+		}
+
+		if validator := jwtValidatorGet(); validator != nil && jwtauth.IsJwt(accessToken) {
+			claims, err := validator.Validate(accessToken)
+			if err != nil {
+				log := logger.Get()
+				log.Info().Ctx(r.Context()).Err(err).Msg("rejected invalid jwt")
+				writeErrorResponse(w, http.StatusUnauthorized, ErrorCodeUnauthorized, fmt.Sprintf("invalid jwt: %s", err.Error()))
+				return
+			}
+			f(w, r.WithContext(jwtauth.WithClaims(r.Context(), claims)))
+			return
+		}
+
 		if !config.Get().GetServerConfig().AccessTokenValidationEnabled {
 			f(w, r)
 			return
 		}
 
-		accessToken := r.URL.Query().Get("accessToken")
+		serverConfig := config.Get().GetServerConfig()
 
-		if config.Get().GetServerConfig().AccessToken != accessToken {
-			data := ServeResponse{
-				id: -1,
-				error: ServeResponseError{
-					message: fmt.Sprintf("req.query['accessToken'] does not match required %s token", config.ServerAccessToken),
-					code:    -1,
-				},
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			_ = json.NewEncoder(w).Encode(data)
+		// tokens of access token tiers are valid in addition to the server access token
+		_, isTierToken := serverConfig.AccessTokenTiers[accessToken]
+		isServerToken := len(serverConfig.AccessToken) > 0 && serverConfig.AccessToken == accessToken
+
+		if !isServerToken && !isTierToken {
+			writeErrorResponse(w, http.StatusUnauthorized, ErrorCodeUnauthorized, fmt.Sprintf("req.query['accessToken'] does not match required %s token", config.ServerAccessToken))
 			return
 		}
 		f(w, r)
 	}
 }
 
+// writeErrorResponse writes a ServeResponse with status
+func writeErrorResponse(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	data := ServeResponse{
+		Id: errorResponseId,
+		Error: ServeResponseError{
+			Code:    code,
+			Message: message,
+		},
+	}
+	writeJsonResponse(w, status, data)
+}
+
+func writeJsonResponse(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
 func (sm *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sessionId := uuid.New()
 	ctx := context.WithValue(r.Context(), logger.SessionIdTrackingKey, sessionId)
@@ -77,6 +152,15 @@ func (sm *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sm.mux.ServeHTTP(w, rc)
 }
 
+// healthCheck fails once the server is draining so that load balancers stop routing new sessions to it
 func (sm *ServeMux) healthCheck(w http.ResponseWriter, r *http.Request) {
+	if proxyQueueGet().IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
+
+func (sm *ServeMux) prometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Get().Prometheus.ServeHTTP(w, r)
+}
@@ -0,0 +1,276 @@
+package tenant
+
+import (
+	"chromium-websocket-proxy/config"
+	"chromium-websocket-proxy/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant is a set of access tokens sharing quotas. A quota of 0 is unlimited.
+// The quota methods may be called on a nil *Tenant, which is never limited
+type Tenant struct {
+	Name                   string             `json:"name"`
+	Tokens                 []string           `json:"tokens"`
+	MaxConcurrentSessions  int                `json:"maxConcurrentSessions"`
+	MaxQueuedSessions      int                `json:"maxQueuedSessions"`
+	AllowedProfiles        []string           `json:"allowedProfiles"`
+	SessionTimeLimitInSecs int                `json:"sessionTimeLimitInSecs"`
+	MaxPinnedBrowsers      int                `json:"maxPinnedBrowsers"`
+	RateLimits             *config.RateLimits `json:"rateLimits,omitempty"`
+	mutex                  sync.Mutex
+	queued                 int
+	active                 int
+}
+
+// Registry holds every tenant by token
+type Registry struct {
+	tenants []*Tenant
+	tokens  map[string]*Tenant
+}
+
+type tenantsFile struct {
+	Tenants []*Tenant `json:"tenants"`
+}
+
+var ErrQueueQuota = errors.New("tenant has reached its maximum number of queued sessions")
+
+var once sync.Once
+
+var r *Registry
+
+// Init loads the tenants in TENANTS_FILE. Without a TENANTS_FILE the registry is empty
+func Init() error {
+	var err error
+	once.Do(func() {
+		r = &Registry{tokens: make(map[string]*Tenant)}
+		path := config.Get().GetServerConfig().TenantsFile
+		if len(path) == 0 {
+			return
+		}
+		r, err = Load(path)
+	})
+	return err
+}
+
+func Get() *Registry {
+	return r
+}
+
+// Load reads a tenants file formatted as {"tenants": [{"name": "...", "tokens": ["..."], ...}]}
+func Load(path string) (*Registry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tf tenantsFile
+	if err = json.Unmarshal(b, &tf); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse %s: %s", path, err.Error()))
+	}
+	return NewRegistry(tf.Tenants)
+}
+
+// NewRegistry validates tenants and indexes them by token. Tokens must be unique across tenants
+func NewRegistry(tenants []*Tenant) (*Registry, error) {
+	reg := &Registry{
+		tenants: tenants,
+		tokens:  make(map[string]*Tenant),
+	}
+
+	names := make(map[string]bool)
+	for _, t := range tenants {
+		if len(t.Name) == 0 {
+			return nil, errors.New("every tenant requires a name")
+		}
+		if names[t.Name] {
+			return nil, errors.New(fmt.Sprintf("tenant %s is defined more than once", t.Name))
+		}
+		names[t.Name] = true
+
+		if t.MaxConcurrentSessions < 0 || t.MaxQueuedSessions < 0 || t.SessionTimeLimitInSecs < 0 || t.MaxPinnedBrowsers < 0 {
+			return nil, errors.New(fmt.Sprintf("quotas of tenant %s must be greater than or equal to 0", t.Name))
+		}
+		if t.RateLimits != nil {
+			if err := t.RateLimits.Validate(); err != nil {
+				return nil, errors.New(fmt.Sprintf("rate limits of tenant %s are invalid: %s", t.Name, err.Error()))
+			}
+		}
+		if len(t.Tokens) == 0 {
+			return nil, errors.New(fmt.Sprintf("tenant %s requires at least one token", t.Name))
+		}
+		for _, token := range t.Tokens {
+			if len(token) == 0 {
+				return nil, errors.New(fmt.Sprintf("tenant %s has an empty token", t.Name))
+			}
+			if _, exists := reg.tokens[token]; exists {
+				return nil, errors.New(fmt.Sprintf("token of tenant %s is used by another tenant", t.Name))
+			}
+			reg.tokens[token] = t
+		}
+	}
+	return reg, nil
+}
+
+// Lookup returns the tenant owning token
+func (reg *Registry) Lookup(token string) (*Tenant, bool) {
+	if reg == nil || len(token) == 0 {
+		return nil, false
+	}
+	t, exists := reg.tokens[token]
+	return t, exists
+}
+
+// GetTenants returns every tenant in the registry
+func (reg *Registry) GetTenants() []*Tenant {
+	if reg == nil {
+		return nil
+	}
+	return reg.tenants
+}
+
+// GetRequestToken returns the access token of r from the Authorization bearer header, or the accessToken query param
+func GetRequestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("accessToken")
+}
+
+type contextKey struct{}
+
+// WithTenant adds t to ctx, and its name so that it is added to every log
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	ctx = context.WithValue(ctx, contextKey{}, t)
+	return context.WithValue(ctx, logger.TenantTrackingKey, t.Name)
+}
+
+// FromContext returns the tenant added to ctx with WithTenant, or nil
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
+
+// IsProfileAllowed returns true if sessions of the tenant may use profile
+func (t *Tenant) IsProfileAllowed(profile string) bool {
+	if t == nil || len(t.AllowedProfiles) == 0 {
+		return true
+	}
+	for _, p := range t.AllowedProfiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionTimeLimit returns the longest a session of the tenant may be proxied, or 0 if it is unlimited
+func (t *Tenant) SessionTimeLimit() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(t.SessionTimeLimitInSecs) * time.Second
+}
+
+// PinnedBrowserLimit returns how many browsers sticky sessions of the tenant may keep pinned, or 0 if it is unlimited.
+// Tenants without maxPinnedBrowsers use MAX_PINNED_BROWSERS_PER_TENANT
+func (t *Tenant) PinnedBrowserLimit() int {
+	if t == nil || t.MaxPinnedBrowsers == 0 {
+		return config.Get().GetChromePoolConfig().MaxPinnedBrowsersPerTenant
+	}
+	return t.MaxPinnedBrowsers
+}
+
+// GetRateLimits returns the rate limits that override the defaults for sessions of the tenant, or nil
+func (t *Tenant) GetRateLimits() *config.RateLimits {
+	if t == nil {
+		return nil
+	}
+	return t.RateLimits
+}
+
+// Queue counts a session waiting in the queue. Returns false if the tenant has MaxQueuedSessions queued already
+func (t *Tenant) Queue() bool {
+	if t == nil {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.MaxQueuedSessions > 0 && t.queued >= t.MaxQueuedSessions {
+		return false
+	}
+	t.queued++
+	return true
+}
+
+// Unqueue counts a session that left the queue without being proxied
+func (t *Tenant) Unqueue() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.queued--
+}
+
+// CanStart returns false if the tenant has MaxConcurrentSessions proxied already
+func (t *Tenant) CanStart() bool {
+	if t == nil {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.MaxConcurrentSessions <= 0 || t.active < t.MaxConcurrentSessions
+}
+
+// Start moves a queued session to being proxied. Returns false, leaving the session queued, if the tenant has
+// MaxConcurrentSessions proxied already
+func (t *Tenant) Start() bool {
+	if t == nil {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.MaxConcurrentSessions > 0 && t.active >= t.MaxConcurrentSessions {
+		return false
+	}
+	t.queued--
+	t.active++
+	return true
+}
+
+// Finish counts a proxied session that ended
+func (t *Tenant) Finish() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.active--
+}
+
+// Requeue moves a session that could not be proxied back to the queue. Requeued sessions are not limited by
+// MaxQueuedSessions since they were counted when first queued
+func (t *Tenant) Requeue() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.active--
+	t.queued++
+}
+
+// Usage returns the number of queued and proxied sessions
+func (t *Tenant) Usage() (queued int, active int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.queued, t.active
+}
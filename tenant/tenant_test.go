@@ -0,0 +1,138 @@
+package tenant
+
+import (
+	"chromium-websocket-proxy/config"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TenantTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TenantTestSuite) TestLoad() {
+	path := filepath.Join(suite.T().TempDir(), "tenants.json")
+	err := os.WriteFile(path, []byte(`{"tenants":[
+		{"name":"acme","tokens":["a1","a2"],"maxConcurrentSessions":2,"allowedProfiles":["checkout"],"sessionTimeLimitInSecs":60},
+		{"name":"globex","tokens":["g1"]}
+	]}`), os.ModePerm)
+	assert.Nil(suite.T(), err)
+
+	reg, err := Load(path)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), reg.GetTenants(), 2)
+
+	acme, exists := reg.Lookup("a2")
+	assert.True(suite.T(), exists)
+	assert.Equal(suite.T(), "acme", acme.Name)
+	assert.Equal(suite.T(), time.Minute, acme.SessionTimeLimit())
+	assert.True(suite.T(), acme.IsProfileAllowed("checkout"))
+	assert.False(suite.T(), acme.IsProfileAllowed(""))
+
+	globex, exists := reg.Lookup("g1")
+	assert.True(suite.T(), exists)
+	assert.True(suite.T(), globex.IsProfileAllowed("anything"))
+
+	_, exists = reg.Lookup("unknown")
+	assert.False(suite.T(), exists)
+	_, exists = reg.Lookup("")
+	assert.False(suite.T(), exists)
+}
+
+func (suite *TenantTestSuite) TestNewRegistryRejectsInvalidTenants() {
+	_, err := NewRegistry([]*Tenant{{Name: "a", Tokens: []string{"t"}}, {Name: "b", Tokens: []string{"t"}}})
+	assert.ErrorContains(suite.T(), err, "used by another tenant")
+
+	_, err = NewRegistry([]*Tenant{{Name: "a", Tokens: []string{"t"}}, {Name: "a", Tokens: []string{"u"}}})
+	assert.ErrorContains(suite.T(), err, "more than once")
+
+	_, err = NewRegistry([]*Tenant{{Name: "a"}})
+	assert.ErrorContains(suite.T(), err, "at least one token")
+
+	_, err = NewRegistry([]*Tenant{{Name: "a", Tokens: []string{"t"}, MaxQueuedSessions: -1}})
+	assert.ErrorContains(suite.T(), err, "quotas")
+
+	_, err = NewRegistry([]*Tenant{{Name: "a", Tokens: []string{"t"}, MaxPinnedBrowsers: -1}})
+	assert.ErrorContains(suite.T(), err, "quotas")
+
+	_, err = NewRegistry([]*Tenant{{Name: "a", Tokens: []string{"t"}, RateLimits: &config.RateLimits{Client: &config.RateLimit{BytesPerSec: -1}}}})
+	assert.ErrorContains(suite.T(), err, "rate limits")
+}
+
+func (suite *TenantTestSuite) TestQuotas() {
+	t := &Tenant{Name: "acme", MaxConcurrentSessions: 1, MaxQueuedSessions: 2}
+
+	assert.True(suite.T(), t.Queue())
+	assert.True(suite.T(), t.Queue())
+	assert.False(suite.T(), t.Queue())
+
+	// only one session is proxied at a time, the other stays queued
+	assert.True(suite.T(), t.CanStart())
+	assert.True(suite.T(), t.Start())
+	assert.False(suite.T(), t.CanStart())
+	assert.False(suite.T(), t.Start())
+	queued, active := t.Usage()
+	assert.Equal(suite.T(), 1, queued)
+	assert.Equal(suite.T(), 1, active)
+
+	t.Requeue()
+	queued, active = t.Usage()
+	assert.Equal(suite.T(), 2, queued)
+	assert.Equal(suite.T(), 0, active)
+
+	assert.True(suite.T(), t.Start())
+	t.Finish()
+	t.Unqueue()
+	queued, active = t.Usage()
+	assert.Equal(suite.T(), 0, queued)
+	assert.Equal(suite.T(), 0, active)
+}
+
+func (suite *TenantTestSuite) TestNilTenantIsUnlimited() {
+	var t *Tenant
+	assert.True(suite.T(), t.Queue())
+	assert.True(suite.T(), t.Start())
+	assert.True(suite.T(), t.IsProfileAllowed("any"))
+	assert.Equal(suite.T(), time.Duration(0), t.SessionTimeLimit())
+	assert.Nil(suite.T(), t.GetRateLimits())
+	t.Finish()
+	t.Unqueue()
+	t.Requeue()
+}
+
+func (suite *TenantTestSuite) TestPinnedBrowserLimit() {
+	suite.T().Setenv(config.MaxPinnedBrowsersPerTenant, "3")
+	config.Once = sync.Once{}
+
+	var t *Tenant
+	assert.Equal(suite.T(), 3, t.PinnedBrowserLimit())
+	assert.Equal(suite.T(), 3, (&Tenant{Name: "acme"}).PinnedBrowserLimit())
+	assert.Equal(suite.T(), 1, (&Tenant{Name: "acme", MaxPinnedBrowsers: 1}).PinnedBrowserLimit())
+}
+
+func (suite *TenantTestSuite) TestGetRequestToken() {
+	r := httptest.NewRequest("GET", "/connect?accessToken=query", nil)
+	assert.Equal(suite.T(), "query", GetRequestToken(r))
+
+	r.Header.Set("Authorization", "Bearer header")
+	assert.Equal(suite.T(), "header", GetRequestToken(r))
+}
+
+func (suite *TenantTestSuite) TestContext() {
+	assert.Nil(suite.T(), FromContext(context.Background()))
+
+	t := &Tenant{Name: "acme"}
+	ctx := WithTenant(context.Background(), t)
+	assert.Equal(suite.T(), t, FromContext(ctx))
+}
+
+func TestTenantSuite(t *testing.T) {
+	suite.Run(t, new(TenantTestSuite))
+}
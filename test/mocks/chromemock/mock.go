@@ -1,9 +1,16 @@
 package chromemock
 
 import (
+	"chromium-websocket-proxy/chrome"
 	"chromium-websocket-proxy/config"
 	"context"
+	"errors"
+	"fmt"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/target"
 	"github.com/google/uuid"
+	"sync/atomic"
+	"time"
 )
 
 type MockChrome struct {
@@ -20,11 +27,17 @@ type MockChrome struct {
 	isNew               bool
 	options             config.ChromeConfigOptions
 	conf                config.ChromeConfig
+	browserContextIDs   []cdp.BrowserContextID
+	startedAt           time.Time
+	version             chrome.Version
+	targets             map[target.ID]*target.Info
+	tickerPaused        atomic.Bool
 }
 
 func NewMock() *MockChrome {
 	mc := MockChrome{}
 	mc.start = func() error {
+		mc.startedAt = time.Now()
 		return nil
 	}
 	return &mc
@@ -78,6 +91,14 @@ func (mc *MockChrome) Port() int {
 	return mc.port
 }
 
+func (mc *MockChrome) SetPort(port int) {
+	mc.port = port
+}
+
+func (mc *MockChrome) StartedAt() time.Time {
+	return mc.startedAt
+}
+
 func (mc *MockChrome) Stop() {}
 
 func (mc *MockChrome) Start() error {
@@ -99,8 +120,69 @@ func (mc *MockChrome) SetOptions(options config.ChromeConfigOptions) {
 	mc.options = options
 }
 
-func (mc *MockChrome) StartTicker() {}
+func (mc *MockChrome) StartTicker() {
+	mc.tickerPaused.Store(false)
+}
+
+func (mc *MockChrome) PauseTicker() {
+	mc.tickerPaused.Store(true)
+}
 
-func (mc *MockChrome) PauseTicker() {}
+func (mc *MockChrome) IsTickerPaused() bool {
+	return mc.tickerPaused.Load()
+}
 
 func (mc *MockChrome) IsNew() bool { return mc.isNew }
+
+func (mc *MockChrome) CreateBrowserContext() (cdp.BrowserContextID, error) {
+	browserContextID := cdp.BrowserContextID(uuid.New().String())
+	mc.AddBrowserContext(browserContextID)
+	return browserContextID, nil
+}
+
+func (mc *MockChrome) AddBrowserContext(browserContextID cdp.BrowserContextID) {
+	mc.browserContextIDs = append(mc.browserContextIDs, browserContextID)
+}
+
+func (mc *MockChrome) RemoveBrowserContext(browserContextID cdp.BrowserContextID) {
+	for i := range mc.browserContextIDs {
+		if mc.browserContextIDs[i] == browserContextID {
+			mc.browserContextIDs = append(mc.browserContextIDs[:i], mc.browserContextIDs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (mc *MockChrome) BrowserContextIDs() []cdp.BrowserContextID {
+	return mc.browserContextIDs
+}
+
+func (mc *MockChrome) CreatePageTarget(url string, browserContextID cdp.BrowserContextID) (target.ID, error) {
+	return target.ID(uuid.New().String()), nil
+}
+
+func (mc *MockChrome) PageDebugUrl(targetID target.ID) string {
+	return fmt.Sprintf("ws://localhost:%d/devtools/page/%s", mc.port, targetID)
+}
+
+func (mc *MockChrome) Version() chrome.Version {
+	return mc.version
+}
+
+func (mc *MockChrome) SetVersion(version chrome.Version) {
+	mc.version = version
+}
+
+func (mc *MockChrome) GetTargetInfo(targetID target.ID) (*target.Info, error) {
+	if info, exists := mc.targets[targetID]; exists {
+		return info, nil
+	}
+	return nil, errors.New("no target with given id")
+}
+
+func (mc *MockChrome) AddTarget(info *target.Info) {
+	if mc.targets == nil {
+		mc.targets = make(map[target.ID]*target.Info)
+	}
+	mc.targets[info.TargetID] = info
+}
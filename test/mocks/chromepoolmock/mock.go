@@ -2,7 +2,9 @@ package chromepoolmock
 
 import (
 	"chromium-websocket-proxy/chrome"
+	"chromium-websocket-proxy/chromepool"
 	"chromium-websocket-proxy/config"
+	"errors"
 	"github.com/chromedp/cdproto/target"
 	"github.com/google/uuid"
 )
@@ -12,6 +14,18 @@ type MockChromePool struct {
 	getAvailableChrome    func(uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error)
 	hasIdleChromeInstance bool
 	isPoolAtCapacity      bool
+	instances             []chrome.IChrome
+	stopInstance          func(uuid.UUID) error
+	drainIdleInstances    func() int
+	prewarmInstances      func(int, config.ChromeConfigOptions) (int, error)
+	retireIdleInstance    func() bool
+	maintainWarmInstances func() int
+	launchFailures        int
+	getPinnedChrome       func(chromepool.Pin, int, uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error)
+	releasePin            func(chromepool.Pin) error
+	releaseIdlePins       func() int
+	hasAvailablePin       bool
+	returnedPins          []chromepool.Pin
 }
 
 func NewMock() *MockChromePool {
@@ -51,3 +65,119 @@ func (mcp *MockChromePool) GetChromeFirstPageTargetIDAtIndex(i int) (target.ID,
 func (mcp *MockChromePool) CreateNewInstance(options config.ChromeConfigOptions) error {
 	return mcp.createNewInstance(options)
 }
+
+func (mcp *MockChromePool) GetInstances() []chrome.IChrome {
+	return mcp.instances
+}
+
+func (mcp *MockChromePool) SetInstances(instances []chrome.IChrome) {
+	mcp.instances = instances
+}
+
+func (mcp *MockChromePool) StopInstance(browserID uuid.UUID) error {
+	return mcp.stopInstance(browserID)
+}
+
+func (mcp *MockChromePool) SetStopInstance(stopInstance func(uuid.UUID) error) {
+	mcp.stopInstance = stopInstance
+}
+
+func (mcp *MockChromePool) DrainIdleInstances() int {
+	return mcp.drainIdleInstances()
+}
+
+func (mcp *MockChromePool) SetDrainIdleInstances(drainIdleInstances func() int) {
+	mcp.drainIdleInstances = drainIdleInstances
+}
+
+func (mcp *MockChromePool) PrewarmInstances(count int, options config.ChromeConfigOptions) (int, error) {
+	return mcp.prewarmInstances(count, options)
+}
+
+func (mcp *MockChromePool) SetPrewarmInstances(prewarmInstances func(int, config.ChromeConfigOptions) (int, error)) {
+	mcp.prewarmInstances = prewarmInstances
+}
+
+func (mcp *MockChromePool) RetireIdleInstance() bool {
+	return mcp.retireIdleInstance()
+}
+
+func (mcp *MockChromePool) SetRetireIdleInstance(retireIdleInstance func() bool) {
+	mcp.retireIdleInstance = retireIdleInstance
+}
+
+func (mcp *MockChromePool) MaintainWarmInstances() int {
+	return mcp.maintainWarmInstances()
+}
+
+func (mcp *MockChromePool) SetMaintainWarmInstances(maintainWarmInstances func() int) {
+	mcp.maintainWarmInstances = maintainWarmInstances
+}
+
+func (mcp *MockChromePool) GetConsecutiveLaunchFailures() int {
+	return mcp.launchFailures
+}
+
+func (mcp *MockChromePool) SetConsecutiveLaunchFailures(launchFailures int) {
+	mcp.launchFailures = launchFailures
+}
+
+func (mcp *MockChromePool) SetIsPoolAtCapacity(isPoolAtCapacity bool) {
+	mcp.isPoolAtCapacity = isPoolAtCapacity
+}
+
+func (mcp *MockChromePool) GetInstanceBySessionId(sessionId uuid.UUID) (chrome.IChrome, error) {
+	for _, crm := range mcp.instances {
+		if sessionId != uuid.Nil && crm.SessionId() == sessionId {
+			return crm, nil
+		}
+	}
+	return nil, errors.New("no browser for session")
+}
+
+func (mcp *MockChromePool) GetPinnedChrome(pin chromepool.Pin, maxPins int, sessionId uuid.UUID, options config.ChromeConfigOptions) (*chrome.IChrome, error) {
+	crm, err := mcp.getPinnedChrome(pin, maxPins, sessionId, options)
+	return &crm, err
+}
+
+func (mcp *MockChromePool) SetGetPinnedChrome(
+	getPinnedChrome func(chromepool.Pin, int, uuid.UUID, config.ChromeConfigOptions) (chrome.IChrome, error),
+) {
+	mcp.getPinnedChrome = getPinnedChrome
+}
+
+func (mcp *MockChromePool) ReturnPinnedChrome(pin chromepool.Pin) {
+	mcp.returnedPins = append(mcp.returnedPins, pin)
+}
+
+// GetReturnedPins returns the pins handed back with ReturnPinnedChrome
+func (mcp *MockChromePool) GetReturnedPins() []chromepool.Pin {
+	return mcp.returnedPins
+}
+
+func (mcp *MockChromePool) ReleasePin(pin chromepool.Pin) error {
+	return mcp.releasePin(pin)
+}
+
+func (mcp *MockChromePool) SetReleasePin(releasePin func(chromepool.Pin) error) {
+	mcp.releasePin = releasePin
+}
+
+func (mcp *MockChromePool) ReleaseIdlePins() int {
+	if mcp.releaseIdlePins == nil {
+		return 0
+	}
+	return mcp.releaseIdlePins()
+}
+
+func (mcp *MockChromePool) SetReleaseIdlePins(releaseIdlePins func() int) {
+	mcp.releaseIdlePins = releaseIdlePins
+}
+
+func (mcp *MockChromePool) HasAvailablePin() bool {
+	return mcp.hasAvailablePin
+}
+
+func (mcp *MockChromePool) SetHasAvailablePin(hasAvailablePin bool) {
+	mcp.hasAvailablePin = hasAvailablePin
+}
@@ -0,0 +1,67 @@
+package websocketproxy
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// minReportedWait ignores the time a wait takes when the budget is available
+const minReportedWait = time.Millisecond
+
+// Shaper budgets the messages and bytes read from one side of a session. A nil limiter, or a nil *Shaper, is
+// unlimited
+type Shaper struct {
+	messages *rate.Limiter
+	bytes    *rate.Limiter
+	onWait   func(time.Duration)
+}
+
+func NewShaper(messages *rate.Limiter, bytes *rate.Limiter) *Shaper {
+	return &Shaper{
+		messages: messages,
+		bytes:    bytes,
+	}
+}
+
+// OnWait sets f to be called with how long a message was held back, whenever it was
+func (s *Shaper) OnWait(f func(time.Duration)) {
+	s.onWait = f
+}
+
+// WaitMessage waits until the message budget allows another message
+func (s *Shaper) WaitMessage(ctx context.Context) error {
+	if s == nil || s.messages == nil {
+		return nil
+	}
+	return s.wait(ctx, s.messages, 1)
+}
+
+// WaitBytes waits until the byte budget allows n more bytes. n is waited for in pieces of at most the burst, so that
+// messages larger than the burst are paced instead of rejected. Each piece may wait for pieceTimeout, 0 waits as long
+// as ctx allows
+func (s *Shaper) WaitBytes(ctx context.Context, n int, pieceTimeout time.Duration) error {
+	if s == nil || s.bytes == nil {
+		return nil
+	}
+	for n > 0 {
+		piece := min(n, s.bytes.Burst())
+		pieceCtx, cancel := withTimeout(ctx, pieceTimeout)
+		err := s.wait(pieceCtx, s.bytes, piece)
+		cancel()
+		if err != nil {
+			return err
+		}
+		n -= piece
+	}
+	return nil
+}
+
+func (s *Shaper) wait(ctx context.Context, limiter *rate.Limiter, n int) error {
+	start := time.Now()
+	err := limiter.WaitN(ctx, n)
+	if waited := time.Since(start); err == nil && waited >= minReportedWait && s.onWait != nil {
+		s.onWait(waited)
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package websocketproxy
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"testing"
+	"time"
+)
+
+func TestNilShaperIsUnlimited(t *testing.T) {
+	var s *Shaper
+	assert.Nil(t, s.WaitMessage(context.Background()))
+	assert.Nil(t, s.WaitBytes(context.Background(), 1<<30, 0))
+
+	s = NewShaper(nil, nil)
+	assert.Nil(t, s.WaitMessage(context.Background()))
+	assert.Nil(t, s.WaitBytes(context.Background(), 1<<30, 0))
+}
+
+func TestShaperPacesBytesLargerThanBurst(t *testing.T) {
+	// 10 bytes of burst refilled at 1000 bytes a second, 30 bytes have to wait for about 20ms
+	s := NewShaper(nil, rate.NewLimiter(1000, 10))
+	var waited time.Duration
+	s.OnWait(func(d time.Duration) {
+		waited += d
+	})
+
+	start := time.Now()
+	assert.Nil(t, s.WaitBytes(context.Background(), 30, 0))
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+	assert.Greater(t, waited, time.Duration(0))
+}
+
+func TestShaperTimesOutEachPieceOfBytes(t *testing.T) {
+	// every piece of 10 bytes waits about 10ms, which fits in the piece timeout even though the whole wait does not
+	s := NewShaper(nil, rate.NewLimiter(1000, 10))
+	assert.Nil(t, s.WaitBytes(context.Background(), 60, 30*time.Millisecond))
+
+	s = NewShaper(nil, rate.NewLimiter(rate.Every(time.Hour), 10))
+	assert.Nil(t, s.WaitBytes(context.Background(), 10, 10*time.Millisecond))
+	assert.Error(t, s.WaitBytes(context.Background(), 10, 10*time.Millisecond))
+}
+
+func TestShaperMessageWaitIsCancelled(t *testing.T) {
+	s := NewShaper(rate.NewLimiter(rate.Every(time.Hour), 1), nil)
+	reported := false
+	s.OnWait(func(time.Duration) {
+		reported = true
+	})
+
+	assert.Nil(t, s.WaitMessage(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, s.WaitMessage(ctx))
+	assert.False(t, reported)
+}
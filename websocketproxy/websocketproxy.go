@@ -1,12 +1,16 @@
 package websocketproxy
 
 import (
+	"bytes"
 	"chromium-websocket-proxy/logger"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/rs/zerolog"
-	"golang.org/x/time/rate"
 	"io"
 	"nhooyr.io/websocket"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,36 +21,102 @@ const (
 	Client Types = "client"
 )
 
+// Timeouts bound each step of proxying a message. A timeout of 0 waits as long as the connection's context allows
+type Timeouts struct {
+	// ReadIdle is how long to wait for the next message from rConn
+	ReadIdle time.Duration
+	// Write is how long writing a message, or a piece of a streamed message, to a connection may take
+	Write time.Duration
+	// LimiterWait is how long a message may be held back by each wait for the Shaper
+	LimiterWait time.Duration
+}
+
+// Errors returned by Proxy when a Timeouts deadline is reached. Errors of the connection's own context are returned
+// as is
+var (
+	ErrReadIdleTimeout    = errors.New("read idle timeout")
+	ErrWriteTimeout       = errors.New("write timeout")
+	ErrLimiterWaitTimeout = errors.New("rate limiter wait timeout")
+)
+
+const (
+	// previewSize is how much of a message is logged
+	previewSize = 200
+	// streamBufferSize is the size of the pooled buffers messages are streamed through
+	streamBufferSize = 32 * 1024
+	// maxPooledMessageSize is the largest buffer of a whole message kept in the pool. Pooled buffers are released
+	// after going unused for two garbage collections, the cap only keeps outliers from being reused
+	maxPooledMessageSize = 64 * 1024 * 1024
+)
+
+var streamBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, streamBufferSize)
+		return &buf
+	},
+}
+
+var messageBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // IWebsocketProxyConnection - only expose needed connection functions
 type IWebsocketProxyConnection interface {
 	Writer(context.Context, websocket.MessageType) (io.WriteCloser, error)
 	Reader(context.Context) (websocket.MessageType, io.Reader, error)
 }
 
+// IMessageInterceptor inspects every message read from rConn before it is written to wConn.
+// A nil forward drops the message and a non-nil reply is written back to rConn. msg is reused once Intercept returns,
+// so it must not be retained
+type IMessageInterceptor interface {
+	Intercept(ctx context.Context, msg []byte) (forward []byte, reply []byte)
+}
+
+// IMessageInspector is implemented by interceptors that only need some of the messages larger than streamBufferSize.
+// Inspects is called with the first streamBufferSize bytes of such a message, and the message is streamed past the
+// interceptors unless one of them returns true. Messages of interceptors without Inspects are always read whole
+type IMessageInspector interface {
+	Inspects(header []byte) bool
+}
+
+// IMessageRecorder receives every message read by the proxy that passed its interceptors, and every reply written
+// by an interceptor, so that recordings hold the session as the client saw it. msg is reused once Record returns, so
+// it must not be retained
+type IMessageRecorder interface {
+	Record(from Types, msgT websocket.MessageType, msg []byte)
+}
+
 type WebsocketProxy struct {
-	rConn             IWebsocketProxyConnection
-	rContext          context.Context
-	rType             Types
-	rLimiter          *rate.Limiter
-	wConn             IWebsocketProxyConnection
-	wContext          context.Context
-	waitTimeoutInSecs int
+	rConn        IWebsocketProxyConnection
+	rContext     context.Context
+	rType        Types
+	rShaper      *Shaper
+	wConn        IWebsocketProxyConnection
+	wContext     context.Context
+	timeouts     Timeouts
+	interceptors []IMessageInterceptor
+	recorder     IMessageRecorder
+	lastReadAt   atomic.Int64
 }
 
 func NewWebsocketProxy(
 	rConn IWebsocketProxyConnection,
 	rContext context.Context,
 	rType Types,
-	rlimiter *rate.Limiter,
-	waitTimeoutInSecs int,
+	rShaper *Shaper,
+	timeouts Timeouts,
 ) *WebsocketProxy {
 	wp := &WebsocketProxy{
-		rConn:             rConn,
-		rContext:          rContext,
-		rType:             rType,
-		rLimiter:          rlimiter,
-		waitTimeoutInSecs: waitTimeoutInSecs,
+		rConn:    rConn,
+		rContext: rContext,
+		rType:    rType,
+		rShaper:  rShaper,
+		timeouts: timeouts,
 	}
+	wp.lastReadAt.Store(time.Now().UnixNano())
 	return wp
 }
 
@@ -58,48 +128,314 @@ func (wp *WebsocketProxy) SetWriteConnection(
 	wp.wContext = wContext
 }
 
-func getTruncatedString(b *[]byte) string {
-	s := string(*b)
-	if len(s) > 200 {
-		s = s[:200] + "..."
+// AddInterceptor appends an interceptor. Interceptors run in the order they are added
+func (wp *WebsocketProxy) AddInterceptor(interceptor IMessageInterceptor) {
+	wp.interceptors = append(wp.interceptors, interceptor)
+}
+
+func (wp *WebsocketProxy) SetRecorder(recorder IMessageRecorder) {
+	wp.recorder = recorder
+}
+
+// LastReadAt returns when the last message was read from rConn, or when the proxy was created if none has been
+func (wp *WebsocketProxy) LastReadAt() time.Time {
+	return time.Unix(0, wp.lastReadAt.Load())
+}
+
+// preview keeps the start of a message for logs without holding on to the message
+type preview struct {
+	buf       [previewSize]byte
+	n         int
+	truncated bool
+}
+
+func (p *preview) Write(b []byte) {
+	copied := copy(p.buf[p.n:], b)
+	p.n += copied
+	if copied < len(b) {
+		p.truncated = true
+	}
+}
+
+func (p *preview) String() string {
+	s := string(p.buf[:p.n])
+	if p.truncated {
+		s += "..."
 	}
 	return s
 }
 
-func (wp *WebsocketProxy) read() (websocket.MessageType, []byte, error) {
-	msgT, reader, err := wp.rConn.Reader(wp.rContext)
+// withTimeout returns ctx with a deadline of timeout, or ctx if timeout is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutErr returns timeoutErr wrapping err if the deadline of ctx caused err rather than parent being done
+func timeoutErr(err error, ctx context.Context, parent context.Context, timeoutErr error, timeout time.Duration, t Types) error {
+	if err == nil || ctx.Err() == nil || parent.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("%w of %s on %s connection: %w", timeoutErr, timeout, t, err)
+}
+
+// proxyBuffered reads the whole message into a pooled buffer so that it can be recorded and intercepted
+func (wp *WebsocketProxy) proxyBuffered(readCtx context.Context, msgT websocket.MessageType, reader io.Reader) error {
+	buf := messageBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putMessageBuffer(buf)
+
+	var p preview
+	_, err := buf.ReadFrom(reader)
+	p.Write(buf.Bytes())
 	if err != nil {
-		return -1, nil, err
+		wp.logReadError(err, &p)
+		return wp.readErr(err, readCtx)
 	}
+	wp.logMessage(&p)
 
-	log := logger.Get()
+	msg := buf.Bytes()
+	forward, err := wp.intercept(msgT, msg)
+	if err != nil || forward == nil {
+		return err
+	}
+
+	if wp.recorder != nil {
+		// client messages are recorded as sent, since rewrites such as the isolation's browser context
+		// only exist in the proxy and would fail when replayed against a fresh chrome
+		if wp.rType == Client {
+			wp.recorder.Record(wp.rType, msgT, msg)
+		} else {
+			wp.recorder.Record(wp.rType, msgT, forward)
+		}
+	}
+	msg = forward
+
+	if err = wp.waitBytes(len(msg)); err != nil {
+		return err
+	}
+	return wp.write(msgT, &msg)
+}
+
+// stream copies the message from reader to wConn through a pooled buffer as it is read, so that large messages such as
+// screenshots are never held in memory as a whole
+func (wp *WebsocketProxy) stream(readCtx context.Context, msgT websocket.MessageType, reader io.Reader) error {
+	wd := newWriteDeadline(wp.wContext, wp.timeouts.Write)
+	defer wd.close()
 
-	msg, err := io.ReadAll(reader)
+	wd.start()
+	writer, err := wp.wConn.Writer(wd.ctx, msgT)
+	wd.stop()
 	if err != nil {
-		log.Error().
+		return wp.streamWriteErr(err, wd)
+	}
+
+	// the writer holds the write lock of wConn until it is closed, so it is closed even if the message could not be
+	// read or written whole. The peer receives a truncated message rather than never receiving another one
+	closed := false
+	closeWriter := func() error {
+		closed = true
+		wd.start()
+		defer wd.stop()
+		return writer.Close()
+	}
+	defer func() {
+		if !closed {
+			_ = closeWriter()
+		}
+	}()
+
+	buf := streamBuffers.Get().(*[]byte)
+	defer streamBuffers.Put(buf)
+
+	var p preview
+	for {
+		n, err := reader.Read(*buf)
+		if n > 0 {
+			p.Write((*buf)[:n])
+			if err := wp.waitBytes(n); err != nil {
+				return err
+			}
+			wd.start()
+			_, err := writer.Write((*buf)[:n])
+			wd.stop()
+			if err != nil {
+				return wp.streamWriteErr(err, wd)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			wp.logReadError(err, &p)
+			return wp.readErr(err, readCtx)
+		}
+	}
+	wp.logMessage(&p)
+
+	return wp.streamWriteErr(closeWriter(), wd)
+}
+
+// writeDeadline cancels ctx once a single write takes longer than timeout. Unlike a context deadline, the time
+// between writes, spent reading and throttling a streamed message, does not count
+type writeDeadline struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	timer    *time.Timer
+	timeout  time.Duration
+	exceeded atomic.Bool
+}
+
+func newWriteDeadline(parent context.Context, timeout time.Duration) *writeDeadline {
+	ctx, cancel := context.WithCancel(parent)
+	wd := &writeDeadline{
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+	}
+	if timeout > 0 {
+		wd.timer = time.AfterFunc(timeout, func() {
+			wd.exceeded.Store(true)
+			cancel()
+		})
+		wd.timer.Stop()
+	}
+	return wd
+}
+
+func (wd *writeDeadline) start() {
+	if wd.timer != nil {
+		wd.timer.Reset(wd.timeout)
+	}
+}
+
+func (wd *writeDeadline) stop() {
+	if wd.timer != nil {
+		wd.timer.Stop()
+	}
+}
+
+func (wd *writeDeadline) close() {
+	wd.stop()
+	wd.cancel()
+}
+
+func (wp *WebsocketProxy) streamWriteErr(err error, wd *writeDeadline) error {
+	if err == nil || !wd.exceeded.Load() || wp.wContext.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("%w of %s on %s connection: %w", ErrWriteTimeout, wd.timeout, wp.writeType(), err)
+}
+
+// waitLimiter waits for the shaper with the LimiterWait timeout
+func (wp *WebsocketProxy) waitLimiter(wait func(ctx context.Context) error) error {
+	waitCtx, cancel := withTimeout(wp.rContext, wp.timeouts.LimiterWait)
+	defer cancel()
+
+	return wp.limiterWaitErr(wait(waitCtx))
+}
+
+// waitBytes waits for the byte budget of n bytes. Each piece of at most the burst gets its own LimiterWait timeout,
+// so that messages larger than the burst are paced instead of timing out
+func (wp *WebsocketProxy) waitBytes(n int) error {
+	return wp.limiterWaitErr(wp.rShaper.WaitBytes(wp.rContext, n, wp.timeouts.LimiterWait))
+}
+
+// limiterWaitErr returns ErrLimiterWaitTimeout wrapping err if a LimiterWait timeout caused err. Rate limiters fail
+// without waiting if the wait would exceed the deadline, so check the read context instead of the deadline to tell a
+// throttled message from a closed connection
+func (wp *WebsocketProxy) limiterWaitErr(err error) error {
+	if err == nil || wp.rContext.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("%w of %s on %s connection: %w", ErrLimiterWaitTimeout, wp.timeouts.LimiterWait, wp.rType, err)
+}
+
+func putMessageBuffer(buf *bytes.Buffer) {
+	// let the garbage collector have buffers grown by exceptionally large messages
+	if buf.Cap() > maxPooledMessageSize {
+		return
+	}
+	messageBuffers.Put(buf)
+}
+
+func (wp *WebsocketProxy) readErr(err error, readCtx context.Context) error {
+	return timeoutErr(err, readCtx, wp.rContext, ErrReadIdleTimeout, wp.timeouts.ReadIdle, wp.rType)
+}
+
+func (wp *WebsocketProxy) logReadError(err error, p *preview) {
+	log := logger.Get()
+	log.Error().
+		Ctx(wp.rContext).
+		Err(err).
+		Str("messageBody", p.String()).
+		Str("from", string(wp.rType)).
+		Msg("error reading message")
+}
+
+func (wp *WebsocketProxy) logMessage(p *preview) {
+	log := logger.Get()
+
+	// don't bother with the preview unless debug level is set
+	if log.GetLevel() != zerolog.DebugLevel {
+		return
+	}
+	messageBody := p.String()
+	go func() {
+		log.Debug().
 			Ctx(wp.rContext).
-			Err(err).
-			Str("messageBody", getTruncatedString(&msg)).
+			Str("messageBody", messageBody).
 			Str("from", string(wp.rType)).
-			Msg("error reading message")
-		return msgT, msg, err
-	}
+			Msg("received message")
+	}()
+}
 
-	// don't bother with truncation unless debug level is set
-	if log.GetLevel() == zerolog.DebugLevel {
-		go func() {
-			log.Debug().
-				Ctx(wp.rContext).
-				Str("messageBody", getTruncatedString(&msg)).
-				Str("from", string(wp.rType)).
-				Msg("received message")
-		}()
+// intercept runs msg through every interceptor, writing replies back to rConn.
+// It returns nil if the message should not be forwarded
+func (wp *WebsocketProxy) intercept(msgT websocket.MessageType, msg []byte) ([]byte, error) {
+	for _, interceptor := range wp.interceptors {
+		forward, reply := interceptor.Intercept(wp.rContext, msg)
+		if reply != nil {
+			// replies stand in for a message of wConn
+			if wp.recorder != nil {
+				wp.recorder.Record(wp.writeType(), msgT, reply)
+			}
+			if err := wp.writeTo(wp.rConn, wp.rContext, wp.rType, msgT, &reply); err != nil {
+				return nil, err
+			}
+		}
+		if forward == nil {
+			return nil, nil
+		}
+		msg = forward
 	}
-	return msgT, msg, nil
+	return msg, nil
 }
 
 func (wp *WebsocketProxy) write(msgT websocket.MessageType, msg *[]byte) (err error) {
-	writer, err := wp.wConn.Writer(wp.wContext, msgT)
+	return wp.writeTo(wp.wConn, wp.wContext, wp.writeType(), msgT, msg)
+}
+
+// writeType returns the type of wConn
+func (wp *WebsocketProxy) writeType() Types {
+	if wp.rType == Client {
+		return Chrome
+	}
+	return Client
+}
+
+func (wp *WebsocketProxy) writeTo(conn IWebsocketProxyConnection, ctx context.Context, t Types, msgT websocket.MessageType, msg *[]byte) error {
+	writeCtx, cancel := withTimeout(ctx, wp.timeouts.Write)
+	defer cancel()
+
+	err := writeMessage(conn, writeCtx, msgT, msg)
+	return timeoutErr(err, writeCtx, ctx, ErrWriteTimeout, wp.timeouts.Write, t)
+}
+
+func writeMessage(conn IWebsocketProxyConnection, ctx context.Context, msgT websocket.MessageType, msg *[]byte) (err error) {
+	writer, err := conn.Writer(ctx, msgT)
 	if err != nil {
 		return err
 	}
@@ -110,22 +446,65 @@ func (wp *WebsocketProxy) write(msgT websocket.MessageType, msg *[]byte) (err er
 	return writer.Close()
 }
 
+// Proxy reads one message from rConn and writes it to wConn. Timeouts are returned as ErrReadIdleTimeout,
+// ErrWriteTimeout or ErrLimiterWaitTimeout
 func (wp *WebsocketProxy) Proxy() error {
-	rCtxWithTimeout, cancel := context.WithTimeout(
-		wp.rContext,
-		time.Second*time.Duration(wp.waitTimeoutInSecs),
-	)
-	defer cancel()
+	if err := wp.waitLimiter(wp.rShaper.WaitMessage); err != nil {
+		return err
+	}
 
-	// wait
-	err := wp.rLimiter.Wait(rCtxWithTimeout)
+	// the deadline covers waiting for the next message and reading all of it
+	readCtx, cancelRead := withTimeout(wp.rContext, wp.timeouts.ReadIdle)
+	defer cancelRead()
+
+	msgT, reader, err := wp.rConn.Reader(readCtx)
 	if err != nil {
-		return err
+		return wp.readErr(err, readCtx)
+	}
+	wp.lastReadAt.Store(time.Now().UnixNano())
+
+	if len(wp.interceptors) == 0 && wp.recorder == nil {
+		return wp.stream(readCtx, msgT, reader)
 	}
+	// recordings hold every message whole
+	if wp.recorder != nil {
+		return wp.proxyBuffered(readCtx, msgT, reader)
+	}
+	return wp.proxyIntercepted(readCtx, msgT, reader)
+}
 
-	msgT, msg, err := wp.read()
+// proxyIntercepted peeks at the first streamBufferSize bytes of the message, and only reads the whole message into
+// memory if it fits in them or an interceptor needs to inspect it
+func (wp *WebsocketProxy) proxyIntercepted(readCtx context.Context, msgT websocket.MessageType, reader io.Reader) error {
+	buf := streamBuffers.Get().(*[]byte)
+	defer streamBuffers.Put(buf)
+
+	n, err := io.ReadFull(reader, *buf)
+	header := (*buf)[:n]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return wp.proxyBuffered(readCtx, msgT, bytes.NewReader(header))
+	}
 	if err != nil {
-		return err
+		var p preview
+		p.Write(header)
+		wp.logReadError(err, &p)
+		return wp.readErr(err, readCtx)
 	}
-	return wp.write(msgT, &msg)
+
+	reader = io.MultiReader(bytes.NewReader(header), reader)
+	if wp.inspects(header) {
+		return wp.proxyBuffered(readCtx, msgT, reader)
+	}
+	return wp.stream(readCtx, msgT, reader)
+}
+
+// inspects returns true if an interceptor needs the whole message starting with header
+func (wp *WebsocketProxy) inspects(header []byte) bool {
+	for _, interceptor := range wp.interceptors {
+		inspector, ok := interceptor.(IMessageInspector)
+		if !ok || inspector.Inspects(header) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,6 +1,7 @@
 package websocketproxy
 
 import (
+	"bytes"
 	"chromium-websocket-proxy/test/mocks/writeclosermock"
 	"chromium-websocket-proxy/test/mocks/wsconnmock"
 	"context"
@@ -62,15 +63,18 @@ func TestProxyReadAndWrite(t *testing.T) {
 		mockRConn,
 		context.Background(),
 		Client,
-		limiter,
-		10,
+		NewShaper(limiter, nil),
+		Timeouts{Write: 10 * time.Second, LimiterWait: 10 * time.Second},
 	)
 	wp.SetWriteConnection(mockWConn, context.Background())
+	createdAt := wp.LastReadAt()
+	time.Sleep(time.Millisecond)
 
 	err := wp.Proxy()
 
 	assert.Nil(t, err)
 	assert.Equal(t, expectedBody, string(rWriteBytes))
+	assert.True(t, wp.LastReadAt().After(createdAt))
 }
 
 func TestProxyReadFailureDoesNotWrite(t *testing.T) {
@@ -122,8 +126,8 @@ func TestProxyReadFailureDoesNotWrite(t *testing.T) {
 		mockRConn,
 		context.Background(),
 		Client,
-		limiter,
-		10,
+		NewShaper(limiter, nil),
+		Timeouts{Write: 10 * time.Second, LimiterWait: 10 * time.Second},
 	)
 	wp.SetWriteConnection(mockWConn, context.Background())
 
@@ -182,8 +186,8 @@ func TestProxyWriteFailureReturnsError(t *testing.T) {
 		mockRConn,
 		context.Background(),
 		Client,
-		limiter,
-		10,
+		NewShaper(limiter, nil),
+		Timeouts{Write: 10 * time.Second, LimiterWait: 10 * time.Second},
 	)
 	wp.SetWriteConnection(mockWConn, context.Background())
 
@@ -192,3 +196,359 @@ func TestProxyWriteFailureReturnsError(t *testing.T) {
 	assert.Equal(t, expectedErr, err)
 	assert.Equal(t, expectedBody, string(rWriteBytes))
 }
+
+type replyInterceptor struct {
+	reply []byte
+}
+
+func (ri replyInterceptor) Intercept(_ context.Context, _ []byte) ([]byte, []byte) {
+	return nil, ri.reply
+}
+
+type recordedMessage struct {
+	from Types
+	msg  string
+}
+
+type sliceRecorder struct {
+	messages []recordedMessage
+}
+
+func (sr *sliceRecorder) Record(from Types, _ websocket.MessageType, msg []byte) {
+	sr.messages = append(sr.messages, recordedMessage{from: from, msg: string(msg)})
+}
+
+func TestProxyInterceptorReplyIsWrittenToReadConnection(t *testing.T) {
+	expectedReply := `{"id":1,"error":{"code":-32000,"message":"denied"}}`
+	var rWriteBytes []byte
+	wWritten := false
+
+	mockRWriteCloser := writeclosermock.NewMock(
+		func(bytes []byte) (n int, err error) {
+			rWriteBytes = bytes
+			return len(bytes), nil
+		},
+		func() error {
+			return nil
+		},
+	)
+
+	mockRConn := wsconnmock.NewMock(
+		func(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+			return websocket.MessageText, strings.NewReader(`{"id":1,"method":"Browser.close"}`), nil
+		},
+		func(ctx context.Context, messageType websocket.MessageType) (io.WriteCloser, error) {
+			return mockRWriteCloser, nil
+		},
+	)
+
+	mockWConn := wsconnmock.NewMock(
+		func(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+			return websocket.MessageText, strings.NewReader(""), errors.New("w conn should not be read from")
+		},
+		func(ctx context.Context, messageType websocket.MessageType) (io.WriteCloser, error) {
+			wWritten = true
+			return nil, errors.New("w conn should not be written to")
+		},
+	)
+
+	limiter := rate.NewLimiter(rate.Every(time.Millisecond*10), 10)
+
+	// init websocket proxy
+	wp := NewWebsocketProxy(
+		mockRConn,
+		context.Background(),
+		Client,
+		NewShaper(limiter, nil),
+		Timeouts{Write: 10 * time.Second, LimiterWait: 10 * time.Second},
+	)
+	wp.SetWriteConnection(mockWConn, context.Background())
+	wp.AddInterceptor(replyInterceptor{reply: []byte(expectedReply)})
+	rec := &sliceRecorder{}
+	wp.SetRecorder(rec)
+
+	err := wp.Proxy()
+
+	assert.Nil(t, err)
+	assert.False(t, wWritten)
+	assert.Equal(t, expectedReply, string(rWriteBytes))
+
+	// the dropped command never reached chrome, the client only saw the reply
+	assert.Equal(t, []recordedMessage{{from: Chrome, msg: expectedReply}}, rec.messages)
+}
+
+// blockingConn blocks reads and writes until their context is done
+func blockingConn() *wsconnmock.MockConn {
+	return wsconnmock.NewMock(
+		func(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+			<-ctx.Done()
+			return -1, nil, ctx.Err()
+		},
+		func(ctx context.Context, messageType websocket.MessageType) (io.WriteCloser, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	)
+}
+
+func TestProxyReadIdleTimeout(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+
+	wp := NewWebsocketProxy(blockingConn(), context.Background(), Chrome, NewShaper(limiter, nil), Timeouts{ReadIdle: 10 * time.Millisecond})
+	wp.SetWriteConnection(blockingConn(), context.Background())
+
+	err := wp.Proxy()
+	assert.ErrorIs(t, err, ErrReadIdleTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestProxyWriteTimeout(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	mockRConn := wsconnmock.NewMock(
+		func(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+			return websocket.MessageText, strings.NewReader(`{}`), nil
+		},
+		func(ctx context.Context, messageType websocket.MessageType) (io.WriteCloser, error) {
+			return nil, errors.New("r conn should not be written to")
+		},
+	)
+
+	wp := NewWebsocketProxy(mockRConn, context.Background(), Client, NewShaper(limiter, nil), Timeouts{Write: 10 * time.Millisecond})
+	wp.SetWriteConnection(blockingConn(), context.Background())
+
+	err := wp.Proxy()
+	assert.ErrorIs(t, err, ErrWriteTimeout)
+	assert.ErrorContains(t, err, string(Chrome))
+}
+
+func TestProxyLimiterWaitTimeout(t *testing.T) {
+	// the only token is taken, the next one is an hour away
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	limiter.Allow()
+
+	wp := NewWebsocketProxy(blockingConn(), context.Background(), Client, NewShaper(limiter, nil), Timeouts{LimiterWait: 10 * time.Millisecond})
+	wp.SetWriteConnection(blockingConn(), context.Background())
+
+	err := wp.Proxy()
+	assert.ErrorIs(t, err, ErrLimiterWaitTimeout)
+}
+
+func TestProxyReturnsContextErrorsWhenConnectionIsDone(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	wp := NewWebsocketProxy(blockingConn(), ctx, Client, NewShaper(limiter, nil), Timeouts{ReadIdle: time.Hour, LimiterWait: time.Hour})
+	wp.SetWriteConnection(blockingConn(), context.Background())
+
+	err := wp.Proxy()
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrReadIdleTimeout)
+}
+
+// bufferWriteCloser collects everything written to a message writer
+type bufferWriteCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferWriteCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+// messageConn serves msg to every reader and hands out w to every writer
+func messageConn(msg []byte, w io.WriteCloser) *wsconnmock.MockConn {
+	return wsconnmock.NewMock(
+		func(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+			return websocket.MessageBinary, bytes.NewReader(msg), nil
+		},
+		func(ctx context.Context, messageType websocket.MessageType) (io.WriteCloser, error) {
+			return w, nil
+		},
+	)
+}
+
+func passthrough(_ context.Context, msg []byte) ([]byte, []byte) {
+	return msg, nil
+}
+
+type interceptorFunc func(ctx context.Context, msg []byte) ([]byte, []byte)
+
+func (f interceptorFunc) Intercept(ctx context.Context, msg []byte) ([]byte, []byte) {
+	return f(ctx, msg)
+}
+
+func TestProxyStreamsMessagesLargerThanTheBuffer(t *testing.T) {
+	msg := bytes.Repeat([]byte("0123456789"), streamBufferSize)
+
+	for _, buffered := range []bool{false, true} {
+		w := &bufferWriteCloser{}
+		wp := NewWebsocketProxy(messageConn(msg, nil), context.Background(), Chrome, nil, Timeouts{})
+		wp.SetWriteConnection(messageConn(nil, w), context.Background())
+		if buffered {
+			wp.AddInterceptor(interceptorFunc(passthrough))
+		}
+
+		assert.Nil(t, wp.Proxy())
+		assert.True(t, w.closed)
+		assert.Equal(t, msg, w.Bytes())
+	}
+}
+
+func TestProxyPacesMessagesLargerThanTheByteBurst(t *testing.T) {
+	// 10 bytes of burst refilled at 1000 bytes a second, the message takes about 190ms, far longer than LimiterWait
+	msg := bytes.Repeat([]byte("0123456789"), 20)
+
+	for _, buffered := range []bool{false, true} {
+		w := &bufferWriteCloser{}
+		shaper := NewShaper(nil, rate.NewLimiter(1000, 10))
+		wp := NewWebsocketProxy(messageConn(msg, nil), context.Background(), Chrome, shaper, Timeouts{LimiterWait: 50 * time.Millisecond})
+		wp.SetWriteConnection(messageConn(nil, w), context.Background())
+		if buffered {
+			wp.AddInterceptor(interceptorFunc(passthrough))
+		}
+
+		start := time.Now()
+		assert.Nil(t, wp.Proxy())
+		assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+		assert.Equal(t, msg, w.Bytes())
+	}
+}
+
+// inspectingInterceptor counts the messages it intercepts and only inspects large messages if inspect is set
+type inspectingInterceptor struct {
+	inspect     bool
+	intercepted int
+}
+
+func (i *inspectingInterceptor) Intercept(_ context.Context, msg []byte) ([]byte, []byte) {
+	i.intercepted++
+	return msg, nil
+}
+
+func (i *inspectingInterceptor) Inspects([]byte) bool {
+	return i.inspect
+}
+
+func TestProxyStreamsLargeMessagesInterceptorsDoNotInspect(t *testing.T) {
+	small := []byte(`{"id":1,"result":{}}`)
+	large := bytes.Repeat([]byte("0123456789"), streamBufferSize)
+
+	for _, inspect := range []bool{false, true} {
+		interceptor := &inspectingInterceptor{inspect: inspect}
+		for _, msg := range [][]byte{small, large} {
+			w := &bufferWriteCloser{}
+			wp := NewWebsocketProxy(messageConn(msg, nil), context.Background(), Chrome, nil, Timeouts{})
+			wp.SetWriteConnection(messageConn(nil, w), context.Background())
+			wp.AddInterceptor(interceptor)
+
+			assert.Nil(t, wp.Proxy())
+			assert.True(t, w.closed)
+			assert.Equal(t, msg, w.Bytes())
+		}
+
+		// messages that fit in the header are always intercepted
+		if inspect {
+			assert.Equal(t, 2, interceptor.intercepted)
+		} else {
+			assert.Equal(t, 1, interceptor.intercepted)
+		}
+	}
+}
+
+// failingReader returns the first chunk of a message and then fails, as a dropped connection does mid-message
+type failingReader struct {
+	chunk []byte
+	read  bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection dropped")
+	}
+	r.read = true
+	return copy(p, r.chunk), nil
+}
+
+func TestProxyClosesStreamWriterOnReadError(t *testing.T) {
+	w := &bufferWriteCloser{}
+	rConn := wsconnmock.NewMock(
+		func(ctx context.Context) (websocket.MessageType, io.Reader, error) {
+			return websocket.MessageBinary, &failingReader{chunk: []byte(`{"id":1,`)}, nil
+		},
+		func(ctx context.Context, messageType websocket.MessageType) (io.WriteCloser, error) {
+			return nil, errors.New("r conn should not be written to")
+		},
+	)
+	wp := NewWebsocketProxy(rConn, context.Background(), Client, nil, Timeouts{})
+	wp.SetWriteConnection(messageConn(nil, w), context.Background())
+
+	assert.Error(t, wp.Proxy())
+	assert.True(t, w.closed, "the writer must not keep holding the write lock of the connection")
+}
+
+func TestPreviewKeepsTheStartOfMessages(t *testing.T) {
+	var p preview
+	p.Write([]byte("short"))
+	assert.Equal(t, "short", p.String())
+
+	p.Write(bytes.Repeat([]byte("a"), previewSize))
+	assert.Len(t, p.String(), previewSize+len("..."))
+	assert.True(t, strings.HasPrefix(p.String(), "shortaaa"))
+}
+
+// discardWriteCloser drops everything written to it
+type discardWriteCloser struct{}
+
+func (discardWriteCloser) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (discardWriteCloser) Close() error {
+	return nil
+}
+
+// benchmarkProxy proxies a 16MB message, such as a full page screenshot, per iteration
+func benchmarkProxy(b *testing.B, interceptors ...IMessageInterceptor) {
+	msg := bytes.Repeat([]byte("a"), 16*1024*1024)
+	wp := NewWebsocketProxy(messageConn(msg, nil), context.Background(), Chrome, nil, Timeouts{})
+	wp.SetWriteConnection(messageConn(nil, discardWriteCloser{}), context.Background())
+	for _, interceptor := range interceptors {
+		wp.AddInterceptor(interceptor)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := wp.Proxy(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProxyStreaming(b *testing.B) {
+	benchmarkProxy(b)
+}
+
+// BenchmarkProxyBuffered reads whole messages like every message was read before streaming
+func BenchmarkProxyBuffered(b *testing.B) {
+	benchmarkProxy(b, interceptorFunc(passthrough))
+}
+
+// BenchmarkProxyReadAll is the baseline of reading every message with io.ReadAll before writing it
+func BenchmarkProxyReadAll(b *testing.B) {
+	msg := bytes.Repeat([]byte("a"), 16*1024*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		read, err := io.ReadAll(bytes.NewReader(msg))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _ = discardWriteCloser{}.Write(read)
+	}
+}